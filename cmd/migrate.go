@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var migrateCmd = &cobra.Command{
+	Use:   "migrate [collection...]",
+	Short: "Upgrade stored items to their collection's current schema version",
+	Long: `Migrate rewrites every stored item that is behind its collection's current
+schema version, applying the collection's migrations. Items are upgraded
+lazily when read anyway; migrating stores the upgrade. All collections are
+migrated when none are named.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager := newManager()
+		defer manager.Close()
+
+		ctx := context.Background()
+		if len(args) == 0 {
+			collections, err := manager.GetCollections(ctx)
+			if err != nil {
+				log.Fatalf("Unable to list collections with error: `%v`", err)
+			}
+			for _, collection := range collections {
+				args = append(args, collection.Name)
+			}
+		}
+
+		for _, name := range args {
+			report, err := manager.MigrateItems(ctx, name)
+			if err != nil {
+				log.Fatalf("Unable to migrate %s with error: `%v`", name, err)
+			}
+			fmt.Printf("%s: %d items checked, %d migrated, %d failed\n", name, report.ItemsChecked, report.ItemsMigrated, len(report.Failures))
+			for _, failure := range report.Failures {
+				for _, e := range failure.Errors {
+					fmt.Printf("  %s: %s\n", failure.ItemID, e)
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(migrateCmd)
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/spf13/cobra"
+)
+
+var reindexCmd = &cobra.Command{
+	Use:   "reindex [collection...]",
+	Short: "Rebuild the full-text search index from the stored items",
+	Long: `Reindex drops a collection's search index and indexes every stored item
+again, for instance after the index was lost or the items were changed
+without going through ninja. All collections are reindexed when none are
+named. A running server holds the index open, so stop it first.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		manager := newManager()
+		defer manager.Close()
+
+		ctx := context.Background()
+		if len(args) == 0 {
+			collections, err := manager.GetCollections(ctx)
+			if err != nil {
+				log.Fatalf("Unable to list collections with error: `%v`", err)
+			}
+			for _, collection := range collections {
+				args = append(args, collection.Name)
+			}
+		}
+
+		for _, name := range args {
+			indexed, err := manager.Reindex(ctx, name)
+			if err != nil {
+				log.Fatalf("Unable to reindex %s with error: `%v`", name, err)
+			}
+			fmt.Printf("%s: %d items indexed\n", name, indexed)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reindexCmd)
+}
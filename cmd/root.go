@@ -5,13 +5,19 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"github.com/tonyalaribe/ninja/core"
 	"github.com/tonyalaribe/ninja/datalayer"
-	_ "github.com/tonyalaribe/ninja/datalayer/mongodb"
+	_ "github.com/tonyalaribe/ninja/datalayer/bolt"
+	_ "github.com/tonyalaribe/ninja/datalayer/fs"
+	"github.com/tonyalaribe/ninja/datalayer/memory"
+	"github.com/tonyalaribe/ninja/datalayer/mongodb"
+	"github.com/tonyalaribe/ninja/datalayer/search"
+	_ "github.com/tonyalaribe/ninja/datalayer/sql"
 	"github.com/tonyalaribe/ninja/uilayer"
 )
 
@@ -21,21 +27,48 @@ var rootCmd = &cobra.Command{
 	Short: "Ninja is a dynamic api engine",
 	Long:  `Ninja lets you build powerful api's(REST, graphql, grpc, etc) for your apps and web applications using a very simple interface.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		datastore, err := datalayer.Connect(config.DBConfig.DriverType, config.DBConfig)
-		if err != nil {
-			log.Fatalf("Unable to initialize datalayer with error: `%v`", err)
-			return
-		}
-
-		manager, err := core.New(core.UseDataStore(datastore))
-		if err != nil {
-			log.Fatalf("Unable to initialize core with error: `%v`", err)
-			return
-		}
+		manager := newManager()
 		uilayer.Register(manager)
 	},
 }
 
+// newManager connects the configured datastore, opens the search index and
+// wraps both in a core manager, exiting if any of them fails.
+func newManager() core.Manager {
+	datastore, err := datalayer.Connect(config.DBConfig.DriverType, config.DBConfig)
+	if err != nil {
+		log.Fatalf("Unable to initialize datalayer with error: `%v`", err)
+	}
+	index, err := search.Open(searchIndexDir())
+	if err != nil {
+		log.Fatalf("Unable to open search index with error: `%v`", err)
+	}
+
+	manager, err := core.New(core.UseDataStore(datastore), core.UseSearchIndex(index))
+	if err != nil {
+		log.Fatalf("Unable to initialize core with error: `%v`", err)
+	}
+	return manager
+}
+
+// searchIndexDir returns where the search index is stored: search_index_dir
+// if set, and otherwise next to the data, or nowhere for in-memory data.
+func searchIndexDir() string {
+	if config.SearchIndexDir != "" {
+		return config.SearchIndexDir
+	}
+	switch config.DBConfig.DriverType {
+	case memory.DriverName:
+		return ""
+	case mongodb.DriverName:
+		return config.DBConfig.DatabaseName + ".search"
+	}
+	if config.DBConfig.ConnectionString == "" || config.DBConfig.ConnectionString == ":memory:" {
+		return ""
+	}
+	return strings.TrimSuffix(config.DBConfig.ConnectionString, "/") + ".search"
+}
+
 func Execute() {
 	var cfgFile string
 	cobra.OnInitialize(initConfig(cfgFile))
@@ -51,6 +84,10 @@ type Config struct {
 	ShortName    string             `mapstructure:"short_name"`
 	LongName     string             `mapstructure:"long_name"`
 	DBConfig     datalayer.DBConfig `mapstructure:"db_config"`
+
+	// SearchIndexDir is where the full-text search index is stored. It
+	// defaults to the data file or directory with a .search suffix.
+	SearchIndexDir string `mapstructure:"search_index_dir"`
 }
 
 func initConfig(cfgFile string) func() {
@@ -3,15 +3,18 @@ package core
 import (
 	"context"
 	"errors"
+	"io"
 	"strings"
 
-	"github.com/globalsign/mgo/bson"
 	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/tonyalaribe/ninja/datalayer/search"
 	"github.com/xeipuuv/gojsonschema"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Config struct {
 	datastore datalayer.DataStore
+	index     *search.Index
 	test      bool
 }
 
@@ -20,10 +23,33 @@ type configFunc func(*Config)
 type Manager interface {
 	CreateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error
 	GetCollections(ctx context.Context) (collections []datalayer.CollectionVM, err error)
+	UpdateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}, force bool) error
+	CheckSchemaChange(ctx context.Context, collectionName string, schema, metadata map[string]interface{}) (*SchemaChangeReport, error)
+	GetSchemaVersions(ctx context.Context, collectionName string) ([]datalayer.SchemaVersion, error)
+	MigrateItems(ctx context.Context, collectionName string) (*MigrationReport, error)
+	RenameCollection(ctx context.Context, name, newName string) error
+	DeleteCollection(ctx context.Context, name string) error
 	GetSchema(ctx context.Context, collectionName string) (map[string]interface{}, error)
 	SaveItem(ctx context.Context, collectionName string, item map[string]interface{}) error
-	GetItem(ctx context.Context, collectionName, itemID string) (item map[string]interface{}, err error)
+	UpdateItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}, ifVersion int) error
+	UpsertItem(ctx context.Context, collectionName string, item map[string]interface{}) (created bool, err error)
+	PatchItem(ctx context.Context, collectionName, itemID string, patchType PatchType, patch []byte, ifVersion int) (item map[string]interface{}, err error)
+	GetItem(ctx context.Context, collectionName, itemID string, fields datalayer.Projection) (item map[string]interface{}, err error)
+	GetItemBySlug(ctx context.Context, collectionName, slug string) (item map[string]interface{}, err error)
 	GetItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error)
+	Aggregate(ctx context.Context, collectionName string, aggregation datalayer.Aggregation) ([]datalayer.Group, error)
+	DeleteItem(ctx context.Context, collectionName, itemID string, ifVersion int) error
+	GetTrashedItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error)
+	RestoreItem(ctx context.Context, collectionName, itemID string) error
+	PurgeItem(ctx context.Context, collectionName, itemID string) error
+	GetRevisions(ctx context.Context, collectionName, itemID string, queryMeta datalayer.QueryMeta) (revisions []datalayer.Revision, respInfo datalayer.ItemsResponseInfo, err error)
+	GetRevision(ctx context.Context, collectionName, itemID string, number int) (datalayer.Revision, error)
+	DiffRevisions(ctx context.Context, collectionName, itemID string, from, to int) ([]PatchOperation, error)
+	RevertItem(ctx context.Context, collectionName, itemID string, number int) (item map[string]interface{}, err error)
+	Search(ctx context.Context, collectionName, text string, queryMeta datalayer.QueryMeta) (hits []SearchHit, respInfo datalayer.ItemsResponseInfo, err error)
+	Reindex(ctx context.Context, collectionName string) (int, error)
+	Capabilities() datalayer.Capabilities
+	Close() error
 }
 
 func New(configFuncs ...configFunc) (*Config, error) {
@@ -58,6 +84,12 @@ func (cf *Config) CreateCollection(ctx context.Context, name string, schema, met
 	if err != nil {
 		return err
 	}
+	if _, err = migrationsFromMeta(metadata); err != nil {
+		return err
+	}
+	if _, err = searchFieldsFromMeta(validatedSchema.(map[string]interface{}), metadata); err != nil {
+		return err
+	}
 	return cf.datastore.CreateCollection(ctx, name, validatedSchema.(map[string]interface{}), metadata)
 }
 
@@ -65,41 +97,295 @@ func (cf *Config) GetCollections(ctx context.Context) (collections []datalayer.C
 	return cf.datastore.GetCollections(ctx)
 }
 
+// UpdateCollection replaces the schema and/or metadata of a collection. A nil
+// schema or metadata is left unchanged. A new schema is refused with an
+// *IncompatibleSchemaError if stored items would fail validation against it,
+// unless force is set. Items are reindexed if the search fields change.
+func (cf *Config) UpdateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}, force bool) error {
+	if _, err := migrationsFromMeta(metadata); err != nil {
+		return err
+	}
+	before, err := cf.datastore.GetCollection(ctx, name)
+	if err != nil {
+		return err
+	}
+	if schema != nil {
+		loader := gojsonschema.NewGoLoader(schema)
+		validatedSchema, err := loader.LoadJSON()
+		if err != nil {
+			return err
+		}
+		schema = validatedSchema.(map[string]interface{})
+
+		if !force {
+			report, err := cf.CheckSchemaChange(ctx, name, schema, metadata)
+			if err != nil {
+				return err
+			}
+			if !report.Compatible() {
+				return &IncompatibleSchemaError{Report: report}
+			}
+		}
+	}
+
+	newSchema, newMeta := before.Schema, before.Meta
+	if schema != nil {
+		newSchema = schema
+	}
+	if metadata != nil {
+		newMeta = metadata
+	}
+	if _, err = searchFieldsFromMeta(newSchema, newMeta); err != nil {
+		return err
+	}
+	if err = cf.datastore.UpdateCollection(ctx, name, schema, metadata); err != nil {
+		return err
+	}
+	return cf.reindexOnChange(ctx, before)
+}
+
+func (cf *Config) RenameCollection(ctx context.Context, name, newName string) error {
+	if newName == "" {
+		return errors.New("CORE: rename failed. empty collection name")
+	}
+	if err := cf.datastore.RenameCollection(ctx, name, newName); err != nil {
+		return err
+	}
+	if cf.index != nil {
+		return cf.index.Rename(name, newName)
+	}
+	return nil
+}
+
+// DeleteCollection drops a collection together with its items, trash and
+// search index.
+func (cf *Config) DeleteCollection(ctx context.Context, name string) error {
+	if err := cf.datastore.DeleteCollection(ctx, name); err != nil {
+		return err
+	}
+	if cf.index != nil {
+		return cf.index.Drop(name)
+	}
+	return nil
+}
+
 func (cf *Config) GetSchema(ctx context.Context, collectionName string) (schema map[string]interface{}, err error) {
 	return cf.datastore.GetSchema(ctx, collectionName)
 }
 
-func (cf *Config) SaveItem(ctx context.Context, collectionName string, item map[string]interface{}) error {
-	schema, err := cf.datastore.GetSchema(ctx, collectionName)
-	schemaLoader := gojsonschema.NewGoLoader(schema)
-	dataLoader := gojsonschema.NewGoLoader(item)
-
-	result, err := gojsonschema.Validate(schemaLoader, dataLoader)
+// validateItem checks item against the current schema of collectionName and
+// stamps it with that schema version. The collection is returned.
+func (cf *Config) validateItem(ctx context.Context, collectionName string, item map[string]interface{}) (collection datalayer.CollectionVM, err error) {
+	collection, err = cf.datastore.GetCollection(ctx, collectionName)
 	if err != nil {
-		return err
+		return collection, err
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(collection.Schema))
+	if err != nil {
+		return collection, err
 	}
 
-	if !result.Valid() {
+	errs, err := validate(schema, item)
+	if err != nil {
+		return collection, err
+	}
+	if errs != nil {
 		// invalid document. Should case error back into gojsonschema error list in uilayer
-		return ValidationErrors(result.Errors())
+		return collection, errs
+	}
+	item[SchemaVersionField] = collection.Version
+	return collection, nil
+}
+
+// SaveItem validates item and stores it under its `_id`, or under a new ID if
+// it has none. Items of collections with a slug field get a unique slug.
+func (cf *Config) SaveItem(ctx context.Context, collectionName string, item map[string]interface{}) error {
+	collection, err := cf.validateItem(ctx, collectionName, item)
+	if err != nil {
+		return err
 	}
 
-	itemID := bson.NewObjectId().Hex()
+	itemID := primitive.NewObjectID().Hex()
 	if n_id, ok := item["_id"].(string); ok && n_id != "" {
 		itemID = n_id
 	}
+	err = cf.writeWithSlug(ctx, collection, itemID, item, false, func() error {
+		return cf.datastore.SaveItem(ctx, collectionName, itemID, item)
+	})
+	if err != nil {
+		return err
+	}
+	if err = cf.saveRevision(ctx, collectionName, itemID, item); err != nil {
+		return err
+	}
+	return cf.indexItem(collection, itemID, item)
+}
 
-	// TODO(tonyalaribe): investigate how to handle slugs, and indexing.
+// UpdateItem replaces the stored item with item after validating it against
+// the collection's schema. A non-zero ifVersion makes the update fail with
+// datalayer.ErrVersionMismatch unless the stored item is at that version.
+func (cf *Config) UpdateItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}, ifVersion int) error {
+	collection, err := cf.validateItem(ctx, collectionName, item)
+	if err != nil {
+		return err
+	}
+	err = cf.writeWithSlug(ctx, collection, itemID, item, true, func() error {
+		return cf.datastore.UpdateItem(ctx, collectionName, itemID, item, ifVersion)
+	})
+	if err != nil {
+		return err
+	}
+	if err = cf.saveRevision(ctx, collectionName, itemID, item); err != nil {
+		return err
+	}
+	return cf.indexItem(collection, itemID, item)
+}
 
-	return cf.datastore.SaveItem(ctx, collectionName, itemID, item)
+// UpsertItem validates item and stores it under its `_id`, replacing the item
+// stored there if there is one. It reports whether the item was created.
+func (cf *Config) UpsertItem(ctx context.Context, collectionName string, item map[string]interface{}) (created bool, err error) {
+	itemID, _ := item["_id"].(string)
+	if itemID == "" {
+		return false, errors.New("CORE: upsert failed. missing _id")
+	}
+	collection, err := cf.validateItem(ctx, collectionName, item)
+	if err != nil {
+		return false, err
+	}
+	err = cf.writeWithSlug(ctx, collection, itemID, item, true, func() (err error) {
+		created, err = cf.datastore.UpsertItem(ctx, collectionName, itemID, item)
+		return err
+	})
+	if err != nil {
+		return false, err
+	}
+	if err = cf.saveRevision(ctx, collectionName, itemID, item); err != nil {
+		return created, err
+	}
+	return created, cf.indexItem(collection, itemID, item)
 }
 
-func (cf *Config) GetItem(ctx context.Context, collectionName, itemID string) (item map[string]interface{}, err error) {
-	return cf.datastore.GetItem(ctx, collectionName, itemID)
+// GetItem returns an item, upgraded to the current schema version if it was
+// saved under an older one. If fields are listed, only those are returned.
+func (cf *Config) GetItem(ctx context.Context, collectionName, itemID string, fields datalayer.Projection) (item map[string]interface{}, err error) {
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkProjection(collection, fields); err != nil {
+		return nil, err
+	}
+	item, err = cf.datastore.GetItem(ctx, collectionName, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if err = upgradeItems(collection, item); err != nil {
+		return nil, err
+	}
+	return fields.Apply(item), nil
 }
 
+// GetItems lists the items of a collection that match queryMeta's filter, in
+// the order of its sort, after checking both against the collection's schema.
+// If fields are listed, only those are returned. Full pages come with a cursor
+// that resumes the listing after their last item. A text query narrows the
+// listing to the items the search index matches, still in listing order.
 func (cf *Config) GetItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
-	return cf.datastore.GetItems(ctx, collectionName, queryMeta)
+	if queryMeta.QueryString != "" {
+		if err := cf.Capabilities().Require(datalayer.FullTextSearch, "text queries"); err != nil {
+			return nil, respInfo, err
+		}
+	}
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, respInfo, err
+	}
+	if queryMeta.Filter, err = checkFilter(collection, queryMeta.Filter); err != nil {
+		return nil, respInfo, err
+	}
+	if err = checkSort(collection, queryMeta.Sort); err != nil {
+		return nil, respInfo, err
+	}
+	if err = checkProjection(collection, queryMeta.Fields); err != nil {
+		return nil, respInfo, err
+	}
+	if queryMeta.QueryString != "" {
+		ids, err := cf.matchingIDs(collection, queryMeta.QueryString)
+		if err != nil {
+			return nil, respInfo, err
+		}
+		if len(ids) == 0 {
+			return []map[string]interface{}{}, queryMeta.ResponseInfo(0, 0), nil
+		}
+		queryMeta.Filter = append(append(datalayer.Filter{}, queryMeta.Filter...), datalayer.Condition{Path: []string{"_id"}, Op: datalayer.In, Value: ids})
+	}
+
+	// Migrations may need fields outside the projection, so items that could
+	// be upgraded are read whole and projected once upgraded. The next cursor
+	// needs the sort fields.
+	query := queryMeta
+	migrations, err := migrationsFromMeta(collection.Meta)
+	if err != nil {
+		return nil, respInfo, err
+	}
+	if len(migrations) > 0 {
+		query.Fields = nil
+	} else if len(query.Fields) > 0 {
+		for _, f := range query.Sort {
+			query.Fields = query.Fields.With(f.Path)
+		}
+	}
+	items, respInfo, err = cf.datastore.GetItems(ctx, collectionName, query)
+	if err != nil {
+		return nil, respInfo, err
+	}
+	if queryMeta.Count > 0 && len(items) == queryMeta.Count {
+		respInfo.NextCursor = datalayer.NewCursor(queryMeta.Sort, items[len(items)-1]).Encode()
+	}
+	if err = upgradeItems(collection, items...); err != nil {
+		return nil, respInfo, err
+	}
+	project(queryMeta.Fields, items)
+	return items, respInfo, nil
+}
+
+// Aggregate summarises the items of a collection that match the
+// aggregation's filter, after checking the aggregation against the
+// collection's schema. Items are aggregated as stored, without upgrading
+// them to the current schema version, like filters match them.
+func (cf *Config) Aggregate(ctx context.Context, collectionName string, aggregation datalayer.Aggregation) ([]datalayer.Group, error) {
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	if aggregation, err = checkAggregation(collection, aggregation); err != nil {
+		return nil, err
+	}
+	return cf.datastore.Aggregate(ctx, collectionName, aggregation)
+}
+
+// Capabilities returns the optional features the datastore supports, and
+// full-text search if a search index is configured.
+func (cf *Config) Capabilities() datalayer.Capabilities {
+	capabilities := datalayer.CapabilitiesOf(cf.datastore)
+	if cf.index != nil && !capabilities.Has(datalayer.FullTextSearch) {
+		capabilities = append(append(datalayer.Capabilities{}, capabilities...), datalayer.FullTextSearch)
+	}
+	return capabilities
+}
+
+// Close releases the datastore's connections, if it holds any, and closes
+// the search index.
+func (cf *Config) Close() error {
+	if cf.index != nil {
+		if err := cf.index.Close(); err != nil {
+			return err
+		}
+	}
+	if closer, ok := cf.datastore.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
 }
 
 func UseDataStore(ds datalayer.DataStore) configFunc {
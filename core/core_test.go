@@ -1 +1,1171 @@
 package core_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/core"
+	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/tonyalaribe/ninja/datalayer/memory"
+	"github.com/tonyalaribe/ninja/datalayer/search"
+)
+
+func newManager(t *testing.T) *core.Config {
+	t.Helper()
+	ds, err := memory.NewDatastore(datalayer.DBConfig{})
+	if err != nil {
+		t.Fatalf("unable to create datastore: %v", err)
+	}
+	manager, err := core.New(core.UseDataStore(ds))
+	if err != nil {
+		t.Fatalf("unable to create core: %v", err)
+	}
+	return manager
+}
+
+func TestGetItemsRejectsUnsupportedTextQuery(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	if err := manager.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+
+	_, _, err := manager.GetItems(ctx, "posts", datalayer.QueryMeta{QueryString: "ninja"})
+	unsupported, ok := errors.Cause(err).(*datalayer.UnsupportedError)
+	if !ok {
+		t.Fatalf("got error %v, want *datalayer.UnsupportedError", err)
+	}
+	if unsupported.Capability != datalayer.FullTextSearch {
+		t.Errorf("got capability %q, want %q", unsupported.Capability, datalayer.FullTextSearch)
+	}
+
+	if _, _, err = manager.GetItems(ctx, "posts", datalayer.QueryMeta{}); err != nil {
+		t.Errorf("GetItems without a text query failed: %v", err)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title":  map[string]interface{}{"type": "string"},
+			"views":  map[string]interface{}{"type": "integer"},
+			"draft":  map[string]interface{}{"type": "boolean"},
+			"tags":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"author": map[string]interface{}{"type": "object", "properties": map[string]interface{}{"name": map[string]interface{}{"type": "string"}}},
+			"extra":  map[string]interface{}{"type": "object"},
+		},
+	}
+	if err := manager.CreateCollection(ctx, "posts", schema, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	for i, title := range []string{"First", "Second", "Third"} {
+		item := map[string]interface{}{
+			"_id":    fmt.Sprint("post-", i+1),
+			"title":  title,
+			"views":  float64(i * 10),
+			"draft":  i == 2,
+			"tags":   []interface{}{"tag", title},
+			"author": map[string]interface{}{"name": title},
+			"extra":  map[string]interface{}{"code": fmt.Sprint(i)},
+		}
+		if err := manager.SaveItem(ctx, "posts", item); err != nil {
+			t.Fatalf("SaveItem failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"filter[views][gte]=10", []string{"post-2", "post-3"}},
+		{"filter[views][in]=0,20", []string{"post-1", "post-3"}},
+		{"filter[draft]=true", []string{"post-3"}},
+		{"filter[tags]=Second", []string{"post-2"}},
+		{"filter[author.name][prefix]=Th", []string{"post-3"}},
+		{"filter[extra.code]=1", []string{"post-2"}},
+		{"filter[_id][ne]=post-1&filter[views][lt]=20", []string{"post-2"}},
+		{"filter[_version]=1", []string{"post-1", "post-2", "post-3"}},
+		{"filter[author][exists]=false", nil},
+	}
+	for _, tt := range tests {
+		params, _ := url.ParseQuery(tt.query)
+		filter, err := datalayer.ParseFilter(params)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q) failed: %v", tt.query, err)
+		}
+		items, _, err := manager.GetItems(ctx, "posts", datalayer.QueryMeta{Filter: filter})
+		if err != nil {
+			t.Errorf("GetItems(%q) failed: %v", tt.query, err)
+			continue
+		}
+		var ids []string
+		for _, item := range items {
+			ids = append(ids, item["_id"].(string))
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("GetItems(%q): got %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+
+	for _, query := range []string{
+		"filter[missing]=1",
+		"filter[title.first]=a",
+		"filter[tags.name]=a",
+		"filter[views]=many",
+		"filter[views]=1.5",
+		"filter[draft]=maybe",
+		"filter[draft][gt]=true",
+		"filter[views][prefix]=1",
+		"filter[author]=Ann",
+		"filter[views][in]=1,x",
+	} {
+		params, _ := url.ParseQuery(query)
+		filter, err := datalayer.ParseFilter(params)
+		if err != nil {
+			t.Fatalf("ParseFilter(%q) failed: %v", query, err)
+		}
+		_, _, err = manager.GetItems(ctx, "posts", datalayer.QueryMeta{Filter: filter})
+		if _, ok := errors.Cause(err).(*datalayer.InvalidQueryError); !ok {
+			t.Errorf("GetItems(%q): got error %v, want *datalayer.InvalidQueryError", query, err)
+		}
+	}
+}
+
+func TestParseFilter(t *testing.T) {
+	for _, query := range []string{"filter[title][like]=a", "filter[title]]=a", "filter[$where]=a", "filter[a..b]=a", "filter[draft][exists]=maybe"} {
+		params, _ := url.ParseQuery(query)
+		if _, err := datalayer.ParseFilter(params); err == nil {
+			t.Errorf("ParseFilter(%q) succeeded, want an error", query)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"status": map[string]interface{}{"type": "string"},
+			"price":  map[string]interface{}{"type": "number"},
+			"paid":   map[string]interface{}{"type": "boolean"},
+			"tags":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"extra":  map[string]interface{}{"type": "object"},
+		},
+	}
+	if err := manager.CreateCollection(ctx, "orders", schema, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	for i, status := range []string{"paid", "paid", "open"} {
+		item := map[string]interface{}{"status": status, "price": float64(i + 1), "paid": status == "paid"}
+		if err := manager.SaveItem(ctx, "orders", item); err != nil {
+			t.Fatalf("SaveItem failed: %v", err)
+		}
+	}
+
+	params, _ := url.ParseQuery("groupBy=status&metrics=count,sum(price),max(status)&filter[price][gt]=1")
+	aggregation, err := datalayer.ParseAggregation(params)
+	if err != nil {
+		t.Fatalf("ParseAggregation failed: %v", err)
+	}
+	groups, err := manager.Aggregate(ctx, "orders", aggregation)
+	if err != nil {
+		t.Fatalf("Aggregate failed: %v", err)
+	}
+	want := []datalayer.Group{
+		{Key: map[string]interface{}{"status": "open"}, Metrics: map[string]interface{}{"count": 1, "sum(price)": 3.0, "max(status)": "open"}},
+		{Key: map[string]interface{}{"status": "paid"}, Metrics: map[string]interface{}{"count": 1, "sum(price)": 2.0, "max(status)": "paid"}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("got %v, want %v", groups, want)
+	}
+
+	for _, query := range []string{
+		"groupBy=missing",
+		"groupBy=tags",
+		"groupBy=extra",
+		"metrics=sum(status)",
+		"metrics=avg(paid)",
+		"metrics=min(paid)",
+		"metrics=max(tags)",
+		"metrics=count,sum(missing)",
+		"filter[price]=cheap",
+	} {
+		params, _ := url.ParseQuery(query)
+		aggregation, err := datalayer.ParseAggregation(params)
+		if err != nil {
+			t.Fatalf("ParseAggregation(%q) failed: %v", query, err)
+		}
+		_, err = manager.Aggregate(ctx, "orders", aggregation)
+		if _, ok := errors.Cause(err).(*datalayer.InvalidQueryError); !ok {
+			t.Errorf("Aggregate(%q): got error %v, want *datalayer.InvalidQueryError", query, err)
+		}
+	}
+}
+
+func TestParseAggregation(t *testing.T) {
+	for _, query := range []string{"groupBy=a..b", "groupBy=a,a", "metrics=median(price)", "metrics=sum()", "metrics=count(price)", "metrics=count,count", "metrics=sum(price,count"} {
+		params, _ := url.ParseQuery(query)
+		if _, err := datalayer.ParseAggregation(params); err == nil {
+			t.Errorf("ParseAggregation(%q) succeeded, want an error", query)
+		}
+	}
+}
+
+func TestSort(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title":  map[string]interface{}{"type": "string"},
+			"views":  map[string]interface{}{"type": "integer"},
+			"tags":   map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"author": map[string]interface{}{"type": "object"},
+		},
+	}
+	if err := manager.CreateCollection(ctx, "posts", schema, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	for i, title := range []string{"B", "A", "C"} {
+		item := map[string]interface{}{"_id": fmt.Sprint("post-", i+1), "title": title, "views": float64(i % 2)}
+		if err := manager.SaveItem(ctx, "posts", item); err != nil {
+			t.Fatalf("SaveItem failed: %v", err)
+		}
+	}
+
+	sort, err := datalayer.ParseSort("-views,title")
+	if err != nil {
+		t.Fatalf("ParseSort failed: %v", err)
+	}
+	items, _, err := manager.GetItems(ctx, "posts", datalayer.QueryMeta{Sort: sort})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	var ids []string
+	for _, item := range items {
+		ids = append(ids, item["_id"].(string))
+	}
+	if want := []string{"post-2", "post-1", "post-3"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+
+	for _, value := range []string{"missing", "tags", "author", "author.name", "title.first"} {
+		sort, err := datalayer.ParseSort(value)
+		if err != nil {
+			t.Fatalf("ParseSort(%q) failed: %v", value, err)
+		}
+		_, _, err = manager.GetItems(ctx, "posts", datalayer.QueryMeta{Sort: sort})
+		if value == "author.name" {
+			if err != nil {
+				t.Errorf("sorting by a field of an untyped object failed: %v", err)
+			}
+			continue
+		}
+		if _, ok := errors.Cause(err).(*datalayer.InvalidQueryError); !ok {
+			t.Errorf("sort=%s: got error %v, want *datalayer.InvalidQueryError", value, err)
+		}
+	}
+	for _, value := range []string{"title,,views", "title,-title", "$natural"} {
+		if _, err := datalayer.ParseSort(value); err == nil {
+			t.Errorf("ParseSort(%q) succeeded, want an error", value)
+		}
+	}
+}
+
+func TestProjection(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"name":   map[string]interface{}{"type": "string"},
+			"body":   map[string]interface{}{"type": "string"},
+			"author": map[string]interface{}{"type": "object"},
+		},
+	}
+	if err := manager.CreateCollection(ctx, "posts", schema, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	item := map[string]interface{}{"_id": "post-1", "name": "Hello", "body": "Long", "author": map[string]interface{}{"name": "Ann", "id": "1"}}
+	if err := manager.SaveItem(ctx, "posts", item); err != nil {
+		t.Fatalf("SaveItem failed: %v", err)
+	}
+
+	fields, _ := datalayer.ParseProjection("name,author.name")
+	want := map[string]interface{}{"_id": "post-1", datalayer.VersionField: 1, "name": "Hello", "author": map[string]interface{}{"name": "Ann"}}
+	stored, err := manager.GetItem(ctx, "posts", "post-1", fields)
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if !reflect.DeepEqual(stored, want) {
+		t.Errorf("GetItem: got %v, want %v", stored, want)
+	}
+
+	// Renaming name to title needs name, which the projection leaves out.
+	schema["properties"].(map[string]interface{})["title"] = map[string]interface{}{"type": "string"}
+	meta := map[string]interface{}{core.MigrationsKey: []interface{}{map[string]interface{}{"version": 2, "rename": map[string]interface{}{"name": "title"}}}}
+	if err = manager.UpdateCollection(ctx, "posts", schema, meta, true); err != nil {
+		t.Fatalf("UpdateCollection failed: %v", err)
+	}
+	fields, _ = datalayer.ParseProjection("title")
+	items, _, err := manager.GetItems(ctx, "posts", datalayer.QueryMeta{Fields: fields})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	want = map[string]interface{}{"_id": "post-1", datalayer.VersionField: 1, "title": "Hello"}
+	if len(items) != 1 || !reflect.DeepEqual(items[0], want) {
+		t.Errorf("GetItems: got %v, want [%v]", items, want)
+	}
+
+	fields, _ = datalayer.ParseProjection("missing")
+	if _, err = manager.GetItem(ctx, "posts", "post-1", fields); err == nil {
+		t.Errorf("GetItem with an unknown field succeeded, want an error")
+	}
+	if _, _, err = manager.GetItems(ctx, "posts", datalayer.QueryMeta{Fields: fields}); err == nil {
+		t.Errorf("GetItems with an unknown field succeeded, want an error")
+	}
+}
+
+func TestCursor(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": "string"},
+			"views": map[string]interface{}{"type": "number"},
+		},
+	}
+	if err := manager.CreateCollection(ctx, "posts", schema, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		item := map[string]interface{}{"_id": fmt.Sprint("post-", i), "title": fmt.Sprint("Post ", i), "views": float64(i % 2)}
+		if err := manager.SaveItem(ctx, "posts", item); err != nil {
+			t.Fatalf("SaveItem failed: %v", err)
+		}
+	}
+
+	sort, _ := datalayer.ParseSort("-views")
+	fields, _ := datalayer.ParseProjection("title")
+	query := datalayer.QueryMeta{Count: 2, Sort: sort, Fields: fields}
+	var ids []string
+	for pages := 0; ; pages++ {
+		items, respInfo, err := manager.GetItems(ctx, "posts", query)
+		if err != nil {
+			t.Fatalf("GetItems failed: %v", err)
+		}
+		for _, item := range items {
+			if _, ok := item["views"]; ok {
+				t.Errorf("got %v, want only the projected fields", item)
+			}
+			ids = append(ids, item["_id"].(string))
+		}
+		if respInfo.NextCursor == "" || pages > 5 {
+			break
+		}
+		if query.Cursor, err = datalayer.ParseCursor(respInfo.NextCursor, sort); err != nil {
+			t.Fatalf("ParseCursor failed: %v", err)
+		}
+	}
+	if want := []string{"post-1", "post-3", "post-0", "post-2", "post-4"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+}
+
+// queryRecorder records the queries core sends to the datastore.
+type queryRecorder struct {
+	datalayer.DataStore
+	queries []datalayer.QueryMeta
+}
+
+func (r *queryRecorder) GetItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) ([]map[string]interface{}, datalayer.ItemsResponseInfo, error) {
+	r.queries = append(r.queries, queryMeta)
+	return r.DataStore.GetItems(ctx, collectionName, queryMeta)
+}
+
+func TestCursorProjectionOfNestedSort(t *testing.T) {
+	ds, err := memory.NewDatastore(datalayer.DBConfig{})
+	if err != nil {
+		t.Fatalf("unable to create datastore: %v", err)
+	}
+	recorder := &queryRecorder{DataStore: ds}
+	manager, err := core.New(core.UseDataStore(recorder))
+	if err != nil {
+		t.Fatalf("unable to create core: %v", err)
+	}
+	ctx := context.Background()
+	if err = manager.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+
+	// Databases like MongoDB reject projections with a field nested in
+	// another, so sort fields are only added if they aren't listed already.
+	tests := []struct {
+		fields, sort, want string
+	}{
+		{"author", "author.name", "[[author]]"},
+		{"author.name", "author", "[[author]]"},
+		{"title", "author.name", "[[title] [author name]]"},
+	}
+	for _, tt := range tests {
+		fields, _ := datalayer.ParseProjection(tt.fields)
+		sort, _ := datalayer.ParseSort(tt.sort)
+		recorder.queries = nil
+		if _, _, err = manager.GetItems(ctx, "posts", datalayer.QueryMeta{Count: 2, Sort: sort, Fields: fields}); err != nil {
+			t.Fatalf("GetItems failed: %v", err)
+		}
+		if got := fmt.Sprint(recorder.queries[0].Fields); got != tt.want {
+			t.Errorf("fields=%s&sort=%s: got projection %s, want %s", tt.fields, tt.sort, got, tt.want)
+		}
+	}
+}
+
+func TestSearch(t *testing.T) {
+	ds, err := memory.NewDatastore(datalayer.DBConfig{})
+	if err != nil {
+		t.Fatalf("unable to create datastore: %v", err)
+	}
+	index, _ := search.Open("")
+	manager, err := core.New(core.UseDataStore(ds), core.UseSearchIndex(index))
+	if err != nil {
+		t.Fatalf("unable to create core: %v", err)
+	}
+	ctx := context.Background()
+	schema := map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": "string"},
+			"body":  map[string]interface{}{"type": "string"},
+			"tags":  map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			"views": map[string]interface{}{"type": "number"},
+		},
+	}
+
+	for _, fields := range []interface{}{"title", []interface{}{"views"}, []interface{}{"missing"}, []interface{}{"title."}} {
+		err := manager.CreateCollection(ctx, "bad", schema, map[string]interface{}{core.SearchFieldsKey: fields})
+		if _, ok := errors.Cause(err).(*core.InvalidSearchFieldsError); !ok {
+			t.Errorf("search fields %v: got error %v, want *core.InvalidSearchFieldsError", fields, err)
+		}
+	}
+
+	meta := map[string]interface{}{core.SearchFieldsKey: []interface{}{"title", "tags"}, core.SoftDeleteKey: true}
+	if err := manager.CreateCollection(ctx, "posts", schema, meta); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	items := []map[string]interface{}{
+		{"_id": "a", "title": "Ninja search", "body": "kittens", "views": 1.0},
+		{"_id": "b", "title": "Other post", "tags": []interface{}{"ninja"}, "body": "kittens"},
+		{"_id": "c", "title": "Unrelated", "body": "kittens"},
+	}
+	for _, item := range items {
+		if err := manager.SaveItem(ctx, "posts", item); err != nil {
+			t.Fatalf("SaveItem failed: %v", err)
+		}
+	}
+
+	ids := func(text string) []string {
+		t.Helper()
+		hits, _, err := manager.Search(ctx, "posts", text, datalayer.QueryMeta{})
+		if err != nil {
+			t.Fatalf("Search failed: %v", err)
+		}
+		ids := []string{}
+		for _, hit := range hits {
+			ids = append(ids, hit.Item["_id"].(string))
+		}
+		return ids
+	}
+	if got, want := ids("ninjas"), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if got := ids("kittens"); len(got) != 0 {
+		t.Errorf("got %v, want no match outside the search fields", got)
+	}
+
+	fields, _ := datalayer.ParseProjection("title")
+	hits, respInfo, err := manager.Search(ctx, "posts", "ninja", datalayer.QueryMeta{Page: 2, Count: 1, Fields: fields})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+	if len(hits) != 1 || respInfo.TotalCount != 2 || respInfo.ItemsSkipped != 1 {
+		t.Fatalf("got %v and %+v, want the second of 2 hits", hits, respInfo)
+	}
+	if want := map[string]string{"tags": "<mark>ninja</mark>"}; !reflect.DeepEqual(hits[0].Highlights, want) {
+		t.Errorf("got highlights %v, want %v", hits[0].Highlights, want)
+	}
+	if _, ok := hits[0].Item["body"]; ok {
+		t.Errorf("got %v, want only the projected fields", hits[0].Item)
+	}
+
+	if !manager.Capabilities().Has(datalayer.FullTextSearch) {
+		t.Errorf("got capabilities %v, want %q with a search index", manager.Capabilities(), datalayer.FullTextSearch)
+	}
+	byTitle, _ := datalayer.ParseSort("-title")
+	listed, respInfo, err := manager.GetItems(ctx, "posts", datalayer.QueryMeta{QueryString: "ninja", Sort: byTitle})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if len(listed) != 2 || listed[0]["_id"] != "b" || listed[1]["_id"] != "a" || respInfo.TotalCount != 2 {
+		t.Errorf("got %v and %+v, want b and a, the matching items in listing order", listed, respInfo)
+	}
+	if listed, _, err = manager.GetItems(ctx, "posts", datalayer.QueryMeta{QueryString: "nothing"}); err != nil || len(listed) != 0 {
+		t.Errorf("got %v with error %v, want no items", listed, err)
+	}
+
+	if err = manager.UpdateItem(ctx, "posts", "a", map[string]interface{}{"_id": "a", "title": "Renamed"}, 0); err != nil {
+		t.Fatalf("UpdateItem failed: %v", err)
+	}
+	if _, err = manager.PatchItem(ctx, "posts", "c", core.MergePatch, []byte(`{"title": "Ninja patched"}`), 0); err != nil {
+		t.Fatalf("PatchItem failed: %v", err)
+	}
+	if err = manager.DeleteItem(ctx, "posts", "b", 0); err != nil {
+		t.Fatalf("DeleteItem failed: %v", err)
+	}
+	if got, want := ids("ninja"), []string{"c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after writes got %v, want %v", got, want)
+	}
+	if err = manager.RestoreItem(ctx, "posts", "b"); err != nil {
+		t.Fatalf("RestoreItem failed: %v", err)
+	}
+	if got := ids("ninja"); len(got) != 2 {
+		t.Errorf("after restore got %v, want b and c", got)
+	}
+
+	meta[core.SearchFieldsKey] = []interface{}{"body"}
+	if err = manager.UpdateCollection(ctx, "posts", nil, meta, false); err != nil {
+		t.Fatalf("UpdateCollection failed: %v", err)
+	}
+	if got := ids("ninja"); len(got) != 0 {
+		t.Errorf("got %v, want the old search fields dropped", got)
+	}
+	if got := ids("kittens"); len(got) != 2 {
+		t.Errorf("got %v, want b and c, the items with a body", got)
+	}
+
+	index.Drop("posts")
+	if indexed, err := manager.Reindex(ctx, "posts"); err != nil || indexed != 3 {
+		t.Errorf("Reindex indexed %d items with error %v, want 3", indexed, err)
+	}
+	if got := ids("kittens"); len(got) != 2 {
+		t.Errorf("after Reindex got %v, want 2 hits", got)
+	}
+	if err = manager.RenameCollection(ctx, "posts", "articles"); err != nil {
+		t.Fatalf("RenameCollection failed: %v", err)
+	}
+	if hits, _, err = manager.Search(ctx, "articles", "kittens", datalayer.QueryMeta{}); err != nil || len(hits) != 2 {
+		t.Errorf("after rename got %v with error %v, want 2 hits", hits, err)
+	}
+
+	if _, _, err = manager.Search(ctx, "articles", " ", datalayer.QueryMeta{}); errors.Cause(err) == nil {
+		t.Errorf("got no error for an empty query")
+	}
+	if _, _, err = newManager(t).Search(ctx, "articles", "ninja", datalayer.QueryMeta{}); err == nil {
+		t.Errorf("got no error without a search index")
+	} else if _, ok := errors.Cause(err).(*datalayer.UnsupportedError); !ok {
+		t.Errorf("got error %v, want *datalayer.UnsupportedError", err)
+	}
+}
+
+func TestPatchItem(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	schema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"title"},
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": "string"},
+			"tags":  map[string]interface{}{"type": "array"},
+		},
+	}
+	if err := manager.CreateCollection(ctx, "posts", schema, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	item := map[string]interface{}{"_id": "post-1", "title": "Hello", "body": "World", "tags": []interface{}{"a"}}
+	if err := manager.SaveItem(ctx, "posts", item); err != nil {
+		t.Fatalf("SaveItem failed: %v", err)
+	}
+
+	patched, err := manager.PatchItem(ctx, "posts", "post-1", core.MergePatch, []byte(`{"title":"Hi","body":null}`), 0)
+	if err != nil {
+		t.Fatalf("merge patch failed: %v", err)
+	}
+	if patched["title"] != "Hi" || patched["_id"] != "post-1" {
+		t.Errorf("got %v after merge patch", patched)
+	}
+	if _, ok := patched["body"]; ok {
+		t.Errorf("merge patch with null kept body: %v", patched)
+	}
+
+	_, err = manager.PatchItem(ctx, "posts", "post-1", core.JSONPatch, []byte(`[{"op":"add","path":"/tags/-","value":"b"}]`), 0)
+	if err != nil {
+		t.Fatalf("JSON patch failed: %v", err)
+	}
+	stored, err := manager.GetItem(ctx, "posts", "post-1", nil)
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if tags := stored["tags"].([]interface{}); len(tags) != 2 || tags[1] != "b" {
+		t.Errorf("got tags %v after JSON patch, want [a b]", tags)
+	}
+
+	// Removing a required field must fail validation and leave the item alone.
+	_, err = manager.PatchItem(ctx, "posts", "post-1", core.JSONPatch, []byte(`[{"op":"remove","path":"/title"}]`), 0)
+	if _, ok := err.(core.ValidationErrors); !ok {
+		t.Errorf("got error %v, want core.ValidationErrors", err)
+	}
+	stored, _ = manager.GetItem(ctx, "posts", "post-1", nil)
+	if stored["title"] != "Hi" {
+		t.Errorf("invalid patch was persisted: %v", stored)
+	}
+
+	_, err = manager.PatchItem(ctx, "posts", "post-1", core.JSONPatch, []byte(`{"not":"a patch"}`), 0)
+	if _, ok := err.(*core.InvalidPatchError); !ok {
+		t.Errorf("got error %v, want *core.InvalidPatchError", err)
+	}
+}
+
+func TestUpsertItem(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	if err := manager.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+
+	if _, err := manager.UpsertItem(ctx, "posts", map[string]interface{}{"title": "Hello"}); err == nil {
+		t.Errorf("upserting an item without an _id succeeded, want an error")
+	}
+	for i, want := range []bool{true, false} {
+		created, err := manager.UpsertItem(ctx, "posts", map[string]interface{}{"_id": "post-1", "title": fmt.Sprint("Hello ", i)})
+		if err != nil {
+			t.Fatalf("UpsertItem failed: %v", err)
+		}
+		if created != want {
+			t.Errorf("upsert %d: got created %v, want %v", i+1, created, want)
+		}
+	}
+
+	revisions, _, err := manager.GetRevisions(ctx, "posts", "post-1", datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetRevisions failed: %v", err)
+	}
+	if len(revisions) != 2 || revisions[1].Item["title"] != "Hello 1" {
+		t.Errorf("got revisions %v, want one per upsert", revisions)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"Hello, World!", "hello-world"},
+		{"  Crème brûlée  ", "creme-brulee"},
+		{"Straße in Łódź", "strasse-in-lodz"},
+		{"Привет мир", "privet-mir"},
+		{"C++ & Go -- 2019", "c-go-2019"},
+		{"日本語", ""},
+	}
+	for _, tt := range tests {
+		if got := core.Slugify(tt.in); got != tt.want {
+			t.Errorf("Slugify(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSlugs(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	meta := map[string]interface{}{core.SlugFieldKey: "title"}
+	if err := manager.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, meta); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+
+	for i, want := range []string{"hello-world", "hello-world-2", "hello-world-3"} {
+		item := map[string]interface{}{"_id": fmt.Sprint("post-", i+1), "title": "Hello World"}
+		if err := manager.SaveItem(ctx, "posts", item); err != nil {
+			t.Fatalf("SaveItem failed: %v", err)
+		}
+		if item[datalayer.SlugField] != want {
+			t.Errorf("got slug %v, want %q", item[datalayer.SlugField], want)
+		}
+	}
+	item := map[string]interface{}{"_id": "post-4", "title": "日本語"}
+	if err := manager.SaveItem(ctx, "posts", item); err != nil {
+		t.Fatalf("SaveItem failed: %v", err)
+	}
+	if item[datalayer.SlugField] != "post-4" {
+		t.Errorf("got slug %v for a title with nothing to transliterate, want the item ID", item[datalayer.SlugField])
+	}
+
+	// Slugs survive changes to the title.
+	if err := manager.UpdateItem(ctx, "posts", "post-2", map[string]interface{}{"title": "Renamed"}, 0); err != nil {
+		t.Fatalf("UpdateItem failed: %v", err)
+	}
+	got, err := manager.GetItemBySlug(ctx, "posts", "hello-world-2")
+	if err != nil {
+		t.Fatalf("GetItemBySlug failed: %v", err)
+	}
+	if got["_id"] != "post-2" || got["title"] != "Renamed" {
+		t.Errorf("got %v for slug hello-world-2, want the renamed post-2", got)
+	}
+
+	// An explicit slug is normalized and made unique.
+	patched, err := manager.PatchItem(ctx, "posts", "post-4", core.MergePatch, []byte(`{"_slug":"Hello World"}`), 0)
+	if err != nil {
+		t.Fatalf("PatchItem failed: %v", err)
+	}
+	if patched[datalayer.SlugField] != "hello-world-4" {
+		t.Errorf("got slug %v after a patch, want %q", patched[datalayer.SlugField], "hello-world-4")
+	}
+
+	_, err = manager.GetItemBySlug(ctx, "posts", "missing")
+	if errors.Cause(err) != datalayer.ErrNotFound {
+		t.Errorf("got error %v for a missing slug, want ErrNotFound", err)
+	}
+}
+
+func TestIfVersion(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	meta := map[string]interface{}{core.SoftDeleteKey: true}
+	if err := manager.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, meta); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	if err := manager.SaveItem(ctx, "posts", map[string]interface{}{"_id": "post-1", "title": "Hello"}); err != nil {
+		t.Fatalf("SaveItem failed: %v", err)
+	}
+
+	patched, err := manager.PatchItem(ctx, "posts", "post-1", core.MergePatch, []byte(`{"title":"Hi"}`), 1)
+	if err != nil {
+		t.Fatalf("PatchItem at the current version failed: %v", err)
+	}
+	if got := datalayer.VersionOf(patched); got != 2 {
+		t.Errorf("got version %d after a patch, want 2", got)
+	}
+
+	_, err = manager.PatchItem(ctx, "posts", "post-1", core.MergePatch, []byte(`{"title":"Stale"}`), 1)
+	if errors.Cause(err) != datalayer.ErrVersionMismatch {
+		t.Errorf("got error %v for a stale patch, want ErrVersionMismatch", err)
+	}
+	err = manager.UpdateItem(ctx, "posts", "post-1", map[string]interface{}{"title": "Stale"}, 1)
+	if errors.Cause(err) != datalayer.ErrVersionMismatch {
+		t.Errorf("got error %v for a stale update, want ErrVersionMismatch", err)
+	}
+	err = manager.DeleteItem(ctx, "posts", "post-1", 1)
+	if errors.Cause(err) != datalayer.ErrVersionMismatch {
+		t.Errorf("got error %v for a stale delete, want ErrVersionMismatch", err)
+	}
+
+	if err = manager.DeleteItem(ctx, "posts", "post-1", 2); err != nil {
+		t.Fatalf("DeleteItem at the current version failed: %v", err)
+	}
+	trashed, _, err := manager.GetTrashedItems(ctx, "posts", datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetTrashedItems failed: %v", err)
+	}
+	if len(trashed) != 1 || trashed[0]["title"] != "Hi" {
+		t.Errorf("got trash %v, want the patched item", trashed)
+	}
+}
+
+func TestConcurrentSlugs(t *testing.T) {
+	const writers = 8
+	manager := newManager(t)
+	ctx := context.Background()
+	meta := map[string]interface{}{core.SlugFieldKey: "title"}
+	if err := manager.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, meta); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+
+	// Writers racing for the same slug each end up with one of their own.
+	var wg sync.WaitGroup
+	slugs := make(chan interface{}, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			item := map[string]interface{}{"_id": fmt.Sprint("post-", i), "title": "Hello World"}
+			if err := manager.SaveItem(ctx, "posts", item); err != nil {
+				t.Errorf("SaveItem failed: %v", err)
+			}
+			slugs <- item[datalayer.SlugField]
+		}(i)
+	}
+	wg.Wait()
+	close(slugs)
+	seen := map[interface{}]bool{}
+	for slug := range slugs {
+		if seen[slug] {
+			t.Errorf("slug %v was given twice", slug)
+		}
+		seen[slug] = true
+	}
+}
+
+func TestCheckSchemaChange(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	meta := map[string]interface{}{core.SoftDeleteKey: true}
+	if err := manager.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, meta); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	// Enough items to span several pages, with every tenth one lacking a title.
+	const total = 250
+	for i := 0; i < total; i++ {
+		item := map[string]interface{}{"_id": fmt.Sprintf("item-%03d", i)}
+		if i%10 != 0 {
+			item["title"] = "Post"
+		}
+		if err := manager.SaveItem(ctx, "posts", item); err != nil {
+			t.Fatalf("SaveItem failed: %v", err)
+		}
+	}
+	// Trashed items would fail once restored, so they are checked too.
+	if err := manager.DeleteItem(ctx, "posts", "item-000", 0); err != nil {
+		t.Fatalf("DeleteItem failed: %v", err)
+	}
+
+	schema := map[string]interface{}{"type": "object", "required": []interface{}{"title"}}
+	report, err := manager.CheckSchemaChange(ctx, "posts", schema, nil)
+	if err != nil {
+		t.Fatalf("CheckSchemaChange failed: %v", err)
+	}
+	if report.ItemsChecked != total {
+		t.Errorf("checked %d items, want %d", report.ItemsChecked, total)
+	}
+	if len(report.Failures) != total/10 {
+		t.Fatalf("got %d failures, want %d", len(report.Failures), total/10)
+	}
+	if report.Failures[0].ItemID != "item-010" || report.Failures[0].Trashed || len(report.Failures[0].Errors) == 0 {
+		t.Errorf("got failure %+v, want item-010 with errors", report.Failures[0])
+	}
+	if last := report.Failures[len(report.Failures)-1]; last.ItemID != "item-000" || !last.Trashed {
+		t.Errorf("got failure %+v, want trashed item-000", last)
+	}
+
+	err = manager.UpdateCollection(ctx, "posts", schema, nil, false)
+	if _, ok := errors.Cause(err).(*core.IncompatibleSchemaError); !ok {
+		t.Fatalf("got error %v, want *core.IncompatibleSchemaError", err)
+	}
+	if stored, _ := manager.GetSchema(ctx, "posts"); stored["required"] != nil {
+		t.Errorf("refused schema change was stored anyway")
+	}
+
+	if err = manager.UpdateCollection(ctx, "posts", schema, nil, true); err != nil {
+		t.Errorf("forced UpdateCollection failed: %v", err)
+	}
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	v1 := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"name"},
+	}
+	if err := manager.CreateCollection(ctx, "posts", v1, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	for _, item := range []map[string]interface{}{
+		{"_id": "post-1", "name": "Hello", "views": "12"},
+		{"_id": "post-2", "name": "World"},
+	} {
+		if err := manager.SaveItem(ctx, "posts", item); err != nil {
+			t.Fatalf("SaveItem failed: %v", err)
+		}
+	}
+	stored, _ := manager.GetItem(ctx, "posts", "post-1", nil)
+	if stored[core.SchemaVersionField] != 1 {
+		t.Errorf("got schema version %v on a new item, want 1", stored[core.SchemaVersionField])
+	}
+
+	v2 := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"title", "draft"},
+		"properties": map[string]interface{}{
+			"views": map[string]interface{}{"type": "integer"},
+		},
+	}
+	meta := map[string]interface{}{
+		core.MigrationsKey: []interface{}{
+			map[string]interface{}{
+				"version":  2,
+				"rename":   map[string]interface{}{"name": "title"},
+				"defaults": map[string]interface{}{"draft": false},
+				"convert":  map[string]interface{}{"views": "integer"},
+			},
+		},
+	}
+	if err := manager.UpdateCollection(ctx, "posts", v2, meta, false); err != nil {
+		t.Fatalf("UpdateCollection failed: %v", err)
+	}
+
+	// Reads upgrade items without storing them.
+	item, err := manager.GetItem(ctx, "posts", "post-1", nil)
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if item["title"] != "Hello" || item["draft"] != false || item["views"] != float64(12) || item[core.SchemaVersionField] != 2 {
+		t.Errorf("got %v, want the item upgraded to version 2", item)
+	}
+	if _, ok := item["name"]; ok {
+		t.Errorf("renamed field kept: %v", item)
+	}
+
+	report, err := manager.MigrateItems(ctx, "posts")
+	if err != nil {
+		t.Fatalf("MigrateItems failed: %v", err)
+	}
+	if report.ItemsChecked != 2 || report.ItemsMigrated != 2 || len(report.Failures) != 0 {
+		t.Errorf("got report %+v, want 2 items checked and migrated", report)
+	}
+	if report, _ = manager.MigrateItems(ctx, "posts"); report.ItemsMigrated != 0 {
+		t.Errorf("second migration migrated %d items, want 0", report.ItemsMigrated)
+	}
+
+	versions, err := manager.GetSchemaVersions(ctx, "posts")
+	if err != nil {
+		t.Fatalf("GetSchemaVersions failed: %v", err)
+	}
+	if len(versions) != 2 || versions[1].Version != 2 {
+		t.Errorf("got versions %v, want 1 and 2", versions)
+	}
+}
+
+func TestInvalidMigrations(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	for _, migrations := range []interface{}{
+		"not a list",
+		[]interface{}{map[string]interface{}{"version": 1}},
+		[]interface{}{map[string]interface{}{"version": 2}, map[string]interface{}{"version": 2}},
+		[]interface{}{map[string]interface{}{"version": 2, "convert": map[string]interface{}{"views": "date"}}},
+	} {
+		meta := map[string]interface{}{core.MigrationsKey: migrations}
+		err := manager.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, meta)
+		if _, ok := errors.Cause(err).(*core.InvalidMigrationError); !ok {
+			t.Errorf("migrations %v: got error %v, want *core.InvalidMigrationError", migrations, err)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	from := map[string]interface{}{
+		"title":  "Hello",
+		"draft":  true,
+		"tags":   []interface{}{"a", "b"},
+		"author": map[string]interface{}{"name": "Tony", "email": "tony@example.com"},
+		"a/b~c":  1.0,
+	}
+	to := map[string]interface{}{
+		"title":  "Hello, World",
+		"tags":   []interface{}{"a"},
+		"author": map[string]interface{}{"name": "Tony", "twitter": "@tony"},
+		"a/b~c":  1.0,
+		"views":  nil,
+	}
+
+	ops := core.Diff(from, to)
+	want := []core.PatchOperation{
+		{Op: "remove", Path: "/author/email"},
+		{Op: "add", Path: "/author/twitter", Value: "@tony"},
+		{Op: "remove", Path: "/draft"},
+		{Op: "replace", Path: "/tags", Value: []interface{}{"a"}},
+		{Op: "replace", Path: "/title", Value: "Hello, World"},
+		{Op: "add", Path: "/views", Value: nil},
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("got %v, want %v", ops, want)
+	}
+
+	// Applying the diff to from must give to.
+	patch, _ := json.Marshal(ops)
+	doc, _ := json.Marshal(from)
+	decoded, err := jsonpatch.DecodePatch(patch)
+	if err != nil {
+		t.Fatalf("diff is not a valid JSON Patch: %v", err)
+	}
+	patched, err := decoded.Apply(doc)
+	if err != nil {
+		t.Fatalf("unable to apply diff: %v", err)
+	}
+	got := map[string]interface{}{}
+	json.Unmarshal(patched, &got)
+	if !reflect.DeepEqual(got, to) {
+		t.Errorf("applying the diff gave %v, want %v", got, to)
+	}
+
+	if ops := core.Diff(from, from); len(ops) != 0 {
+		t.Errorf("got %v diffing a document with itself, want no operations", ops)
+	}
+}
+
+func TestRevisions(t *testing.T) {
+	manager := newManager(t)
+	ctx := core.WithActor(context.Background(), "editor")
+	schema := map[string]interface{}{"type": "object", "required": []interface{}{"title"}}
+	if err := manager.CreateCollection(ctx, "posts", schema, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	if err := manager.SaveItem(ctx, "posts", map[string]interface{}{"_id": "post-1", "title": "Hello"}); err != nil {
+		t.Fatalf("SaveItem failed: %v", err)
+	}
+	if err := manager.UpdateItem(ctx, "posts", "post-1", map[string]interface{}{"title": "Hi"}, 0); err != nil {
+		t.Fatalf("UpdateItem failed: %v", err)
+	}
+	if _, err := manager.PatchItem(ctx, "posts", "post-1", core.MergePatch, []byte(`{"body":"World"}`), 0); err != nil {
+		t.Fatalf("PatchItem failed: %v", err)
+	}
+
+	revisions, respInfo, err := manager.GetRevisions(ctx, "posts", "post-1", datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetRevisions failed: %v", err)
+	}
+	if respInfo.TotalCount != 3 {
+		t.Fatalf("got %d revisions, want 3", respInfo.TotalCount)
+	}
+	first := revisions[0]
+	if first.Revision != 1 || first.Actor != "editor" || first.SchemaVersion != 1 || first.Item["title"] != "Hello" || first.CreatedAt.IsZero() {
+		t.Errorf("got first revision %+v", first)
+	}
+
+	ops, err := manager.DiffRevisions(ctx, "posts", "post-1", 1, 3)
+	if err != nil {
+		t.Fatalf("DiffRevisions failed: %v", err)
+	}
+	want := []core.PatchOperation{
+		{Op: "add", Path: "/body", Value: "World"},
+		{Op: "replace", Path: "/title", Value: "Hi"},
+	}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("got diff %v, want %v", ops, want)
+	}
+
+	// Reverting writes a new revision rather than rewriting history.
+	item, err := manager.RevertItem(context.Background(), "posts", "post-1", 1)
+	if err != nil {
+		t.Fatalf("RevertItem failed: %v", err)
+	}
+	stored, _ := manager.GetItem(ctx, "posts", "post-1", nil)
+	if stored["title"] != "Hello" || stored["body"] != nil || item["title"] != "Hello" {
+		t.Errorf("got %v after revert, want revision 1", stored)
+	}
+	latest, err := manager.GetRevision(ctx, "posts", "post-1", 4)
+	if err != nil {
+		t.Fatalf("GetRevision failed: %v", err)
+	}
+	if latest.Item["title"] != "Hello" || latest.Actor != "" {
+		t.Errorf("got revision %+v after revert", latest)
+	}
+
+	_, err = manager.RevertItem(ctx, "posts", "post-1", 9)
+	if errors.Cause(err) != datalayer.ErrNotFound {
+		t.Errorf("got error %v reverting to a missing revision, want datalayer.ErrNotFound", err)
+	}
+}
+
+func TestConcurrentRevisions(t *testing.T) {
+	const writers = 8
+	manager := newManager(t)
+	ctx := context.Background()
+	if err := manager.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	if err := manager.SaveItem(ctx, "posts", map[string]interface{}{"_id": "post-1", "views": 0}); err != nil {
+		t.Fatalf("SaveItem failed: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := manager.UpdateItem(ctx, "posts", "post-1", map[string]interface{}{"views": i}, 0); err != nil {
+				t.Errorf("UpdateItem failed: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every write has its own revision, numbered by the version it wrote.
+	revisions, respInfo, err := manager.GetRevisions(ctx, "posts", "post-1", datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetRevisions failed: %v", err)
+	}
+	if respInfo.TotalCount != writers+1 {
+		t.Fatalf("got %d revisions, want %d", respInfo.TotalCount, writers+1)
+	}
+	for i, revision := range revisions {
+		if revision.Revision != i+1 || datalayer.VersionOf(revision.Item) != i+1 {
+			t.Errorf("got revision %d of version %d at position %d, want both %d", revision.Revision, datalayer.VersionOf(revision.Item), i, i+1)
+		}
+	}
+	stored, _ := manager.GetItem(ctx, "posts", "post-1", nil)
+	if last := revisions[len(revisions)-1]; fmt.Sprint(last.Item["views"]) != fmt.Sprint(stored["views"]) {
+		t.Errorf("got %v in the latest revision, want the stored %v", last.Item["views"], stored["views"])
+	}
+}
+
+func TestStrictSchemaRoundTrip(t *testing.T) {
+	manager := newManager(t)
+	ctx := context.Background()
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties": map[string]interface{}{
+			"_id":   map[string]interface{}{"type": "string"},
+			"title": map[string]interface{}{"type": "string"},
+		},
+	}
+	meta := map[string]interface{}{core.SlugFieldKey: "title"}
+	if err := manager.CreateCollection(ctx, "posts", schema, meta); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	if err := manager.SaveItem(ctx, "posts", map[string]interface{}{"_id": "post-1", "title": "Hello"}); err != nil {
+		t.Fatalf("SaveItem failed: %v", err)
+	}
+
+	// Items read back carry the fields ninja stamps on them, which the schema
+	// doesn't declare.
+	item, err := manager.GetItem(ctx, "posts", "post-1", nil)
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	item["title"] = "Hi"
+	if err = manager.UpdateItem(ctx, "posts", "post-1", item, 0); err != nil {
+		t.Errorf("UpdateItem with a stored item failed: %v", err)
+	}
+	if _, err = manager.PatchItem(ctx, "posts", "post-1", core.MergePatch, []byte(`{"title":"Hey"}`), 0); err != nil {
+		t.Errorf("PatchItem failed: %v", err)
+	}
+	if _, err = manager.RevertItem(ctx, "posts", "post-1", 1); err != nil {
+		t.Errorf("RevertItem failed: %v", err)
+	}
+
+	report, err := manager.CheckSchemaChange(ctx, "posts", schema, meta)
+	if err != nil {
+		t.Fatalf("CheckSchemaChange failed: %v", err)
+	}
+	if len(report.Failures) != 0 {
+		t.Errorf("got failures %+v for an unchanged schema", report.Failures)
+	}
+	if err = manager.UpdateCollection(ctx, "posts", schema, meta, false); err != nil {
+		t.Errorf("UpdateCollection with an unchanged schema failed: %v", err)
+	}
+
+	err = manager.SaveItem(ctx, "posts", map[string]interface{}{"_id": "post-2", "title": "Hello", "extra": true})
+	if _, ok := errors.Cause(err).(core.ValidationErrors); !ok {
+		t.Errorf("got error %v for an undeclared field, want ValidationErrors", err)
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"context"
+
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// SoftDeleteKey is the collection metadata flag that makes DeleteItem move
+// items to the collection's trash instead of deleting them for good.
+const SoftDeleteKey = "soft_delete"
+
+// DeleteItem trashes the item if its collection has soft delete turned on,
+// and deletes it permanently otherwise. A non-zero ifVersion makes it fail
+// with datalayer.ErrVersionMismatch unless the item is at that version.
+func (cf *Config) DeleteItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return err
+	}
+	if softDelete, _ := collection.Meta[SoftDeleteKey].(bool); softDelete {
+		err = cf.datastore.TrashItem(ctx, collectionName, itemID, ifVersion)
+	} else {
+		err = cf.datastore.DeleteItem(ctx, collectionName, itemID, ifVersion)
+	}
+	if err != nil {
+		return err
+	}
+	return cf.unindexItem(collectionName, itemID)
+}
+
+// GetTrashedItems lists the trash of a collection. It can be paged but not
+// searched.
+func (cf *Config) GetTrashedItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if queryMeta.QueryString != "" {
+		return nil, respInfo, &datalayer.InvalidQueryError{Param: "q", Reason: "trashed items can't be searched"}
+	}
+	return cf.datastore.GetTrashedItems(ctx, collectionName, queryMeta)
+}
+
+// RestoreItem moves a trashed item back into its collection. It fails if an
+// item with the same ID was saved in the meantime.
+func (cf *Config) RestoreItem(ctx context.Context, collectionName, itemID string) error {
+	if err := cf.datastore.RestoreItem(ctx, collectionName, itemID); err != nil {
+		return err
+	}
+	if cf.index == nil {
+		return nil
+	}
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return err
+	}
+	item, err := cf.datastore.GetItem(ctx, collectionName, itemID)
+	if err != nil {
+		return err
+	}
+	if err = upgradeItems(collection, item); err != nil {
+		return err
+	}
+	return cf.indexItem(collection, itemID, item)
+}
+
+// PurgeItem permanently deletes a trashed item.
+func (cf *Config) PurgeItem(ctx context.Context, collectionName, itemID string) error {
+	return cf.datastore.PurgeItem(ctx, collectionName, itemID)
+}
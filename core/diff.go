@@ -0,0 +1,79 @@
+package core
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// PatchOperation is a single JSON Patch (RFC 6902) operation.
+type PatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// MarshalJSON leaves out the value of remove operations, which have none.
+func (op PatchOperation) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	type operation PatchOperation
+	return json.Marshal(operation(op))
+}
+
+// Diff returns the JSON Patch that turns from into to. Objects are compared
+// key by key, in sorted order; arrays and other values that differ are
+// replaced whole.
+func Diff(from, to map[string]interface{}) []PatchOperation {
+	return diffObjects("", from, to, []PatchOperation{})
+}
+
+func diffObjects(path string, from, to map[string]interface{}, ops []PatchOperation) []PatchOperation {
+	keys := make([]string, 0, len(from)+len(to))
+	for k := range from {
+		keys = append(keys, k)
+	}
+	for k := range to {
+		if _, ok := from[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		p := path + "/" + escapePointer(k)
+		a, inFrom := from[k]
+		b, inTo := to[k]
+		switch {
+		case !inTo:
+			ops = append(ops, PatchOperation{Op: "remove", Path: p})
+		case !inFrom:
+			ops = append(ops, PatchOperation{Op: "add", Path: p, Value: b})
+		default:
+			ops = diffValues(p, a, b, ops)
+		}
+	}
+	return ops
+}
+
+func diffValues(path string, from, to interface{}, ops []PatchOperation) []PatchOperation {
+	fromObject, ok := from.(map[string]interface{})
+	toObject, ok2 := to.(map[string]interface{})
+	if ok && ok2 {
+		return diffObjects(path, fromObject, toObject, ops)
+	}
+	if reflect.DeepEqual(from, to) {
+		return ops
+	}
+	return append(ops, PatchOperation{Op: "replace", Path: path, Value: to})
+}
+
+// escapePointer escapes a key for use in a JSON Pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
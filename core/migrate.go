@@ -0,0 +1,277 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+const (
+	// SchemaVersionField holds the schema version an item was last validated
+	// against. Items saved before schemas were versioned are at version 1.
+	SchemaVersionField = "_schema_version"
+
+	// MigrationsKey is the collection metadata key holding its Migrations.
+	MigrationsKey = "migrations"
+)
+
+// Migration upgrades items to schema version Version. Steps run in order:
+// fields are renamed, then missing fields get their defaults, then values
+// are converted to the named type (string, number, integer or boolean).
+//
+//	"migrations": [
+//		{"version": 2, "rename": {"name": "title"}, "defaults": {"draft": false}},
+//		{"version": 3, "convert": {"views": "integer"}}
+//	]
+type Migration struct {
+	Version  int                    `json:"version"`
+	Rename   map[string]string      `json:"rename,omitempty"`
+	Defaults map[string]interface{} `json:"defaults,omitempty"`
+	Convert  map[string]string      `json:"convert,omitempty"`
+}
+
+// Migrations are the migration steps of a collection, sorted by version.
+type Migrations []Migration
+
+// InvalidMigrationError is returned when collection metadata holds
+// migrations that can't be decoded.
+type InvalidMigrationError struct {
+	Err error
+}
+
+func (e *InvalidMigrationError) Error() string {
+	return fmt.Sprintf("CORE: invalid migrations: %v", e.Err)
+}
+
+// migrationsFromMeta decodes and checks the migrations in collection
+// metadata.
+func migrationsFromMeta(meta map[string]interface{}) (Migrations, error) {
+	raw, ok := meta[MigrationsKey]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(raw)
+	if err != nil {
+		return nil, &InvalidMigrationError{Err: err}
+	}
+	migrations := Migrations{}
+	if err = json.Unmarshal(data, &migrations); err != nil {
+		return nil, &InvalidMigrationError{Err: err}
+	}
+
+	sort.SliceStable(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	for i, m := range migrations {
+		if m.Version < 2 {
+			return nil, &InvalidMigrationError{Err: fmt.Errorf("version %d: migrations upgrade to version 2 or later", m.Version)}
+		}
+		if i > 0 && migrations[i-1].Version == m.Version {
+			return nil, &InvalidMigrationError{Err: fmt.Errorf("version %d: more than one migration", m.Version)}
+		}
+		for field, typ := range m.Convert {
+			if _, ok := converters[typ]; !ok {
+				return nil, &InvalidMigrationError{Err: fmt.Errorf("version %d: can't convert %s to %q", m.Version, field, typ)}
+			}
+		}
+	}
+	return migrations, nil
+}
+
+// itemVersion returns the schema version stamped on item.
+func itemVersion(item map[string]interface{}) int {
+	switch v := item[SchemaVersionField].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 1
+}
+
+// Upgrade applies the migrations item needs to reach schema version target,
+// in place, and reports whether the item changed.
+func (migrations Migrations) Upgrade(item map[string]interface{}, target int) bool {
+	version := itemVersion(item)
+	if version >= target {
+		return false
+	}
+	for _, m := range migrations {
+		if m.Version <= version || m.Version > target {
+			continue
+		}
+		for from, to := range m.Rename {
+			if value, ok := item[from]; ok {
+				if _, taken := item[to]; !taken {
+					item[to] = value
+				}
+				delete(item, from)
+			}
+		}
+		for field, value := range m.Defaults {
+			if _, ok := item[field]; !ok {
+				item[field] = value
+			}
+		}
+		for field, typ := range m.Convert {
+			if value, ok := item[field]; ok {
+				item[field] = converters[typ](value)
+			}
+		}
+	}
+	item[SchemaVersionField] = target
+	return true
+}
+
+// converters turn a JSON value into the named type. Values that can't be
+// converted are returned unchanged, so validation reports them.
+var converters = map[string]func(interface{}) interface{}{
+	"string": func(v interface{}) interface{} {
+		switch vv := v.(type) {
+		case string:
+			return vv
+		case float64:
+			return strconv.FormatFloat(vv, 'f', -1, 64)
+		case nil:
+			return v
+		}
+		return fmt.Sprint(v)
+	},
+	"number": func(v interface{}) interface{} {
+		if f, ok := toFloat(v); ok {
+			return f
+		}
+		return v
+	},
+	"integer": func(v interface{}) interface{} {
+		if f, ok := toFloat(v); ok {
+			return math.Trunc(f)
+		}
+		return v
+	},
+	"boolean": func(v interface{}) interface{} {
+		switch vv := v.(type) {
+		case bool:
+			return vv
+		case string:
+			if b, err := strconv.ParseBool(vv); err == nil {
+				return b
+			}
+		default:
+			if f, ok := toFloat(v); ok {
+				return f != 0
+			}
+		}
+		return v
+	},
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch vv := v.(type) {
+	case float64:
+		return vv, true
+	case int:
+		return float64(vv), true
+	case int32:
+		return float64(vv), true
+	case int64:
+		return float64(vv), true
+	case bool:
+		if vv {
+			return 1, true
+		}
+		return 0, true
+	case string:
+		f, err := strconv.ParseFloat(vv, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// upgradeItems upgrades items read from collection to its current schema
+// version. The stored items are left as they are.
+func upgradeItems(collection datalayer.CollectionVM, items ...map[string]interface{}) error {
+	migrations, err := migrationsFromMeta(collection.Meta)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		migrations.Upgrade(item, collection.Version)
+	}
+	return nil
+}
+
+// MigrationReport summarises an eager migration of a collection's items.
+type MigrationReport struct {
+	ItemsChecked  int                     `json:"itemsChecked"`
+	ItemsMigrated int                     `json:"itemsMigrated"`
+	Failures      []ItemValidationFailure `json:"failures"`
+}
+
+// MigrateItems upgrades every item of collectionName that is behind the
+// current schema version and stores it. Upgraded items that fail validation
+// against the current schema are reported and left untouched, as are items
+// written by someone else while the migration ran.
+func (cf *Config) MigrateItems(ctx context.Context, collectionName string) (*MigrationReport, error) {
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := migrationsFromMeta(collection.Meta)
+	if err != nil {
+		return nil, err
+	}
+	schema, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(collection.Schema))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &MigrationReport{Failures: []ItemValidationFailure{}}
+	err = cf.eachItem(ctx, collectionName, func(item map[string]interface{}) error {
+		report.ItemsChecked++
+		if !migrations.Upgrade(item, collection.Version) {
+			return nil
+		}
+		if errs, err := validate(schema, item); err != nil {
+			return err
+		} else if errs != nil {
+			report.Failures = append(report.Failures, ItemValidationFailure{ItemID: fmt.Sprint(item["_id"]), Errors: errs})
+			return nil
+		}
+		itemID := fmt.Sprint(item["_id"])
+		err := cf.datastore.UpdateItem(ctx, collectionName, itemID, item, datalayer.VersionOf(item))
+		if errors.Cause(err) == datalayer.ErrVersionMismatch {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := cf.saveRevision(ctx, collectionName, itemID, item); err != nil {
+			return err
+		}
+		if err := cf.indexItem(collection, itemID, item); err != nil {
+			return err
+		}
+		report.ItemsMigrated++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (cf *Config) GetSchemaVersions(ctx context.Context, collectionName string) ([]datalayer.SchemaVersion, error) {
+	return cf.datastore.GetSchemaVersions(ctx, collectionName)
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// PatchType names a partial update format, by its media type.
+type PatchType string
+
+const (
+	// MergePatch is a JSON Merge Patch (RFC 7396).
+	MergePatch PatchType = "application/merge-patch+json"
+	// JSONPatch is a JSON Patch (RFC 6902).
+	JSONPatch PatchType = "application/json-patch+json"
+)
+
+// InvalidPatchError is returned when a patch can't be decoded or applied to
+// the stored item.
+type InvalidPatchError struct {
+	Err error
+}
+
+func (e *InvalidPatchError) Error() string {
+	return fmt.Sprintf("CORE: invalid patch: %v", e.Err)
+}
+
+// applyPatch applies patch to item and returns the patched copy.
+func applyPatch(item map[string]interface{}, patchType PatchType, patch []byte) (map[string]interface{}, error) {
+	doc, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	var patched []byte
+	switch patchType {
+	case MergePatch:
+		patched, err = jsonpatch.MergePatch(doc, patch)
+	case JSONPatch:
+		var decoded jsonpatch.Patch
+		decoded, err = jsonpatch.DecodePatch(patch)
+		if err == nil {
+			patched, err = decoded.Apply(doc)
+		}
+	default:
+		return nil, &InvalidPatchError{Err: fmt.Errorf("unsupported patch type %q", patchType)}
+	}
+	if err != nil {
+		return nil, &InvalidPatchError{Err: err}
+	}
+
+	result := map[string]interface{}{}
+	if err = json.Unmarshal(patched, &result); err != nil {
+		return nil, &InvalidPatchError{Err: err}
+	}
+	return result, nil
+}
+
+// PatchItem applies patch to the stored item, validates the result against
+// the collection's schema and only then persists it. The patched item is
+// returned. The write fails with datalayer.ErrVersionMismatch if the item
+// changed after it was read, or if ifVersion is non-zero and the item is not
+// at that version.
+func (cf *Config) PatchItem(ctx context.Context, collectionName, itemID string, patchType PatchType, patch []byte, ifVersion int) (map[string]interface{}, error) {
+	item, err := cf.GetItem(ctx, collectionName, itemID, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = datalayer.CheckVersion(item, ifVersion); err != nil {
+		return nil, err
+	}
+
+	patched, err := applyPatch(item, patchType, patch)
+	if err != nil {
+		return nil, err
+	}
+	// The ID is the item's address, so patches can't move it.
+	patched["_id"] = itemID
+
+	collection, err := cf.validateItem(ctx, collectionName, patched)
+	if err != nil {
+		return nil, err
+	}
+	err = cf.writeWithSlug(ctx, collection, itemID, patched, true, func() error {
+		return cf.datastore.UpdateItem(ctx, collectionName, itemID, patched, datalayer.VersionOf(item))
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = cf.saveRevision(ctx, collectionName, itemID, patched); err != nil {
+		return nil, err
+	}
+	if err = cf.indexItem(collection, itemID, patched); err != nil {
+		return nil, err
+	}
+	return patched, nil
+}
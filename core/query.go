@@ -0,0 +1,209 @@
+package core
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// builtinFields are the types of the fields ninja stores on every item.
+var builtinFields = map[string]string{
+	"_id":                  "string",
+	datalayer.SlugField:    "string",
+	datalayer.VersionField: "integer",
+	SchemaVersionField:     "integer",
+}
+
+// ordered are the field types the ordering operators work on.
+var ordered = map[string]bool{"": true, "string": true, "number": true, "integer": true}
+
+// checkFilter checks the fields and operators of filter against the schema of
+// collection, and converts the string values parsed from the query to the
+// fields' types. Fields of objects that declare no properties are untyped and
+// compared as strings.
+func checkFilter(collection datalayer.CollectionVM, filter datalayer.Filter) (datalayer.Filter, error) {
+	checked := make(datalayer.Filter, 0, len(filter))
+	for _, c := range filter {
+		param := fmt.Sprintf("filter[%s][%s]", c.Field(), c.Op)
+		fieldType, _, err := queryFieldType(collection.Schema, c.Path)
+		if err != nil {
+			return nil, &datalayer.InvalidQueryError{Param: param, Reason: err.Error()}
+		}
+		if c.Op == datalayer.Exists {
+			checked = append(checked, c)
+			continue
+		}
+
+		switch {
+		case fieldType == "object":
+			return nil, &datalayer.InvalidQueryError{Param: param, Reason: "objects can only be filtered with exists"}
+		case c.Op == datalayer.Prefix && fieldType != "" && fieldType != "string":
+			return nil, &datalayer.InvalidQueryError{Param: param, Reason: fmt.Sprintf("prefix needs a string field, not %s", fieldType)}
+		case (c.Op == datalayer.Gt || c.Op == datalayer.Gte || c.Op == datalayer.Lt || c.Op == datalayer.Lte) && !ordered[fieldType]:
+			return nil, &datalayer.InvalidQueryError{Param: param, Reason: fmt.Sprintf("%s fields can't be ordered", fieldType)}
+		}
+
+		if values, ok := c.Value.([]interface{}); ok {
+			converted := make([]interface{}, len(values))
+			for i, v := range values {
+				if converted[i], err = filterValue(fieldType, v.(string)); err != nil {
+					return nil, &datalayer.InvalidQueryError{Param: param, Reason: err.Error()}
+				}
+			}
+			c.Value = converted
+		} else if c.Value, err = filterValue(fieldType, c.Value.(string)); err != nil {
+			return nil, &datalayer.InvalidQueryError{Param: param, Reason: err.Error()}
+		}
+		checked = append(checked, c)
+	}
+	return checked, nil
+}
+
+// queryFieldType returns the JSON Schema type of the field at path. Arrays
+// give the type of their elements, since filters apply to each element, and
+// report array.
+func queryFieldType(schema map[string]interface{}, path []string) (fieldType string, array bool, err error) {
+	if fieldType, ok := builtinFields[path[0]]; ok && len(path) == 1 {
+		return fieldType, false, nil
+	}
+
+	current := schema
+	for i, p := range path {
+		properties, ok := current["properties"].(map[string]interface{})
+		if !ok {
+			if t := schemaType(current); t != "" && t != "object" {
+				return "", false, fmt.Errorf("%s is not an object", strings.Join(path[:i], "."))
+			}
+			return "", false, nil
+		}
+		if current, ok = properties[p].(map[string]interface{}); !ok {
+			return "", false, fmt.Errorf("unknown field %q", strings.Join(path[:i+1], "."))
+		}
+	}
+
+	fieldType = schemaType(current)
+	if fieldType == "array" {
+		items, _ := current["items"].(map[string]interface{})
+		return schemaType(items), true, nil
+	}
+	return fieldType, false, nil
+}
+
+// checkProjection checks that the fields of p are declared in the schema of
+// collection.
+func checkProjection(collection datalayer.CollectionVM, p datalayer.Projection) error {
+	for _, path := range p {
+		if _, _, err := queryFieldType(collection.Schema, path); err != nil {
+			return &datalayer.InvalidQueryError{Param: "fields", Reason: err.Error()}
+		}
+	}
+	return nil
+}
+
+// project applies p to items that were read whole or upgraded, which adds
+// fields a driver's projection left out.
+func project(p datalayer.Projection, items []map[string]interface{}) {
+	for i := range items {
+		items[i] = p.Apply(items[i])
+	}
+}
+
+// checkSort checks that the fields of s are declared in the schema of
+// collection and hold single values that can be ordered.
+func checkSort(collection datalayer.CollectionVM, s datalayer.Sort) error {
+	for _, f := range s {
+		fieldType, array, err := queryFieldType(collection.Schema, f.Path)
+		if err != nil {
+			return &datalayer.InvalidQueryError{Param: "sort", Reason: err.Error()}
+		}
+		if array {
+			fieldType = "array"
+		}
+		if fieldType == "array" || fieldType == "object" {
+			return &datalayer.InvalidQueryError{Param: "sort", Reason: fmt.Sprintf("%s is an %s and can't be sorted by", f.Field(), fieldType)}
+		}
+	}
+	return nil
+}
+
+// checkAggregation checks the filter, group fields and metrics of a against
+// the schema of collection, and converts the filter's values like
+// checkFilter. Groups need fields holding single values, sum and avg need
+// numbers, and min and max values that can be ordered.
+func checkAggregation(collection datalayer.CollectionVM, a datalayer.Aggregation) (datalayer.Aggregation, error) {
+	var err error
+	if a.Filter, err = checkFilter(collection, a.Filter); err != nil {
+		return a, err
+	}
+	for _, path := range a.GroupBy {
+		fieldType, array, err := queryFieldType(collection.Schema, path)
+		if err != nil {
+			return a, &datalayer.InvalidQueryError{Param: "groupBy", Reason: err.Error()}
+		}
+		if array {
+			fieldType = "array"
+		}
+		if fieldType == "array" || fieldType == "object" {
+			return a, &datalayer.InvalidQueryError{Param: "groupBy", Reason: fmt.Sprintf("%s is an %s and can't be grouped by", strings.Join(path, "."), fieldType)}
+		}
+	}
+	for _, m := range a.Metrics {
+		if m.Op == datalayer.Count {
+			continue
+		}
+		fieldType, array, err := queryFieldType(collection.Schema, m.Path)
+		if err != nil {
+			return a, &datalayer.InvalidQueryError{Param: "metrics", Reason: err.Error()}
+		}
+		if array {
+			fieldType = "array"
+		}
+		switch {
+		case (m.Op == datalayer.Sum || m.Op == datalayer.Avg) && fieldType != "" && fieldType != "number" && fieldType != "integer":
+			return a, &datalayer.InvalidQueryError{Param: "metrics", Reason: fmt.Sprintf("%s needs a number field, not %s", m.Name(), fieldType)}
+		case !ordered[fieldType]:
+			return a, &datalayer.InvalidQueryError{Param: "metrics", Reason: fmt.Sprintf("%s needs a string or number field, not %s", m.Name(), fieldType)}
+		}
+	}
+	return a, nil
+}
+
+// schemaType returns the type a property schema declares, ignoring null.
+func schemaType(schema map[string]interface{}) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		for _, v := range t {
+			if s, ok := v.(string); ok && s != "null" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// filterValue converts raw to fieldType.
+func filterValue(fieldType, raw string) (interface{}, error) {
+	switch fieldType {
+	case "number", "integer":
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return nil, fmt.Errorf("expected a number, got %q", raw)
+		}
+		if fieldType == "integer" && n != math.Trunc(n) {
+			return nil, fmt.Errorf("expected an integer, got %q", raw)
+		}
+		return n, nil
+	case "boolean":
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("expected true or false, got %q", raw)
+		}
+		return b, nil
+	}
+	return raw, nil
+}
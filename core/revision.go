@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"time"
+
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+type actorKey struct{}
+
+// WithActor returns a context recording who is making the changes done with
+// it. The actor is stored on the revisions those changes create.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+func actorFrom(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// saveRevision records item, as just written, as a revision of itemID. The
+// revision is numbered by the version the driver gave the item, which its
+// compare-and-swap makes unique, so writers racing each other can't take the
+// same number or record their revisions out of order.
+func (cf *Config) saveRevision(ctx context.Context, collectionName, itemID string, item map[string]interface{}) error {
+	revision := datalayer.Revision{
+		Revision:      datalayer.VersionOf(item),
+		Item:          item,
+		CreatedAt:     time.Now().UTC(),
+		Actor:         actorFrom(ctx),
+		SchemaVersion: itemVersion(item),
+	}
+	return cf.datastore.SaveRevision(ctx, collectionName, itemID, revision)
+}
+
+func (cf *Config) GetRevisions(ctx context.Context, collectionName, itemID string, queryMeta datalayer.QueryMeta) (revisions []datalayer.Revision, respInfo datalayer.ItemsResponseInfo, err error) {
+	return cf.datastore.GetRevisions(ctx, collectionName, itemID, queryMeta)
+}
+
+func (cf *Config) GetRevision(ctx context.Context, collectionName, itemID string, number int) (datalayer.Revision, error) {
+	return cf.datastore.GetRevision(ctx, collectionName, itemID, number)
+}
+
+// DiffRevisions returns the JSON Patch that turns revision from of an item
+// into revision to. The item version, which every write changes, is left out.
+func (cf *Config) DiffRevisions(ctx context.Context, collectionName, itemID string, from, to int) ([]PatchOperation, error) {
+	a, err := cf.datastore.GetRevision(ctx, collectionName, itemID, from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := cf.datastore.GetRevision(ctx, collectionName, itemID, to)
+	if err != nil {
+		return nil, err
+	}
+	delete(a.Item, datalayer.VersionField)
+	delete(b.Item, datalayer.VersionField)
+	return Diff(a.Item, b.Item), nil
+}
+
+// RevertItem replaces an item with the document stored in one of its
+// revisions, upgraded to the current schema version. The revert is a write
+// like any other, so it is validated and recorded as a new revision. The
+// reverted item is returned.
+func (cf *Config) RevertItem(ctx context.Context, collectionName, itemID string, number int) (map[string]interface{}, error) {
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	revision, err := cf.datastore.GetRevision(ctx, collectionName, itemID, number)
+	if err != nil {
+		return nil, err
+	}
+	item := revision.Item
+	if err = upgradeItems(collection, item); err != nil {
+		return nil, err
+	}
+	item["_id"] = itemID
+
+	if err = cf.UpdateItem(ctx, collectionName, itemID, item, 0); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
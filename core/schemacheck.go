@@ -0,0 +1,170 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// itemsPageSize is how many items are loaded at a time when every item of a
+// collection is visited.
+const itemsPageSize = 100
+
+// SchemaChangeReport lists the stored items, trashed ones included, that
+// would fail validation against a proposed schema.
+type SchemaChangeReport struct {
+	ItemsChecked int                     `json:"itemsChecked"`
+	Failures     []ItemValidationFailure `json:"failures"`
+}
+
+// Compatible reports whether every stored item validates against the
+// proposed schema.
+func (r *SchemaChangeReport) Compatible() bool {
+	return len(r.Failures) == 0
+}
+
+// ItemValidationFailure is an item failing validation. Trashed is set for
+// items in the trash, which would fail once restored.
+type ItemValidationFailure struct {
+	ItemID  string           `json:"itemID"`
+	Trashed bool             `json:"trashed,omitempty"`
+	Errors  ValidationErrors `json:"errors"`
+}
+
+// MarshalJSON encodes each ResultError as its field, type and description,
+// since gojsonschema keeps them in unexported fields.
+func (v ValidationErrors) MarshalJSON() ([]byte, error) {
+	type resultError struct {
+		Field       string `json:"field"`
+		Type        string `json:"type"`
+		Description string `json:"description"`
+	}
+	out := make([]resultError, 0, len(v))
+	for _, vv := range v {
+		out = append(out, resultError{Field: vv.Field(), Type: vv.Type(), Description: vv.Description()})
+	}
+	return json.Marshal(out)
+}
+
+// IncompatibleSchemaError is returned by UpdateCollection when stored items
+// would fail validation against the new schema and force wasn't set.
+type IncompatibleSchemaError struct {
+	Report *SchemaChangeReport
+}
+
+func (e *IncompatibleSchemaError) Error() string {
+	return fmt.Sprintf("CORE: %d of %d items fail validation against the new schema", len(e.Report.Failures), e.Report.ItemsChecked)
+}
+
+// CheckSchemaChange validates every item stored in collectionName, and every
+// item in its trash, against schema without changing anything. Items are
+// first upgraded with the migrations in metadata, or the stored ones if
+// metadata is nil, as they would be once schema becomes the next schema
+// version.
+func (cf *Config) CheckSchemaChange(ctx context.Context, collectionName string, schema, metadata map[string]interface{}) (*SchemaChangeReport, error) {
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	if metadata == nil {
+		metadata = collection.Meta
+	}
+	migrations, err := migrationsFromMeta(metadata)
+	if err != nil {
+		return nil, err
+	}
+	compiled, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	report := &SchemaChangeReport{Failures: []ItemValidationFailure{}}
+	check := func(trashed bool) func(item map[string]interface{}) error {
+		return func(item map[string]interface{}) error {
+			report.ItemsChecked++
+			migrations.Upgrade(item, collection.Version+1)
+			errs, err := validate(compiled, item)
+			if err != nil {
+				return err
+			}
+			if errs != nil {
+				report.Failures = append(report.Failures, ItemValidationFailure{ItemID: fmt.Sprint(item["_id"]), Trashed: trashed, Errors: errs})
+			}
+			return nil
+		}
+	}
+	if err = cf.eachItem(ctx, collectionName, check(false)); err != nil {
+		return nil, err
+	}
+	if err = cf.eachTrashedItem(ctx, collectionName, check(true)); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// eachItem calls f with every item stored in collectionName. Items are loaded
+// a page at a time, so large collections are never held in memory at once.
+func (cf *Config) eachItem(ctx context.Context, collectionName string, f func(item map[string]interface{}) error) error {
+	return eachPage(func(queryMeta datalayer.QueryMeta) ([]map[string]interface{}, datalayer.ItemsResponseInfo, error) {
+		return cf.datastore.GetItems(ctx, collectionName, queryMeta)
+	}, f)
+}
+
+// eachTrashedItem calls f with every item in the trash of collectionName, a
+// page at a time.
+func (cf *Config) eachTrashedItem(ctx context.Context, collectionName string, f func(item map[string]interface{}) error) error {
+	return eachPage(func(queryMeta datalayer.QueryMeta) ([]map[string]interface{}, datalayer.ItemsResponseInfo, error) {
+		return cf.datastore.GetTrashedItems(ctx, collectionName, queryMeta)
+	}, f)
+}
+
+// eachPage calls f with every item list returns, asking for a page at a time.
+func eachPage(list func(datalayer.QueryMeta) ([]map[string]interface{}, datalayer.ItemsResponseInfo, error), f func(item map[string]interface{}) error) error {
+	queryMeta := datalayer.QueryMeta{Page: 1, Count: itemsPageSize}
+	for {
+		items, respInfo, err := list(queryMeta)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			if err = f(item); err != nil {
+				return err
+			}
+		}
+		if len(items) < queryMeta.Count || queryMeta.Page >= respInfo.PagesCount {
+			return nil
+		}
+		queryMeta.Page++
+	}
+}
+
+// managedFields are the fields ninja and its drivers stamp on items. Schemas
+// don't declare them, so they are left out of validation.
+var managedFields = map[string]bool{
+	SchemaVersionField:       true,
+	datalayer.VersionField:   true,
+	datalayer.SlugField:      true,
+	datalayer.DeletedAtField: true,
+}
+
+// validate checks item against schema, ignoring managedFields. It returns nil
+// ValidationErrors for a valid item.
+func validate(schema *gojsonschema.Schema, item map[string]interface{}) (ValidationErrors, error) {
+	doc := make(map[string]interface{}, len(item))
+	for k, v := range item {
+		if !managedFields[k] {
+			doc[k] = v
+		}
+	}
+	result, err := schema.Validate(gojsonschema.NewGoLoader(doc))
+	if err != nil {
+		return nil, err
+	}
+	if !result.Valid() {
+		return ValidationErrors(result.Errors()), nil
+	}
+	return nil, nil
+}
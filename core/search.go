@@ -0,0 +1,239 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/tonyalaribe/ninja/datalayer/search"
+)
+
+// SearchFieldsKey is the collection metadata entry listing the item fields
+// that full-text search indexes, as dot-separated paths, e.g.
+// ["title", "author.name"]. Each must hold a string or an array of strings.
+const SearchFieldsKey = "search_fields"
+
+// InvalidSearchFieldsError is returned when collection metadata lists search
+// fields that can't be indexed.
+type InvalidSearchFieldsError struct {
+	Err error
+}
+
+func (e *InvalidSearchFieldsError) Error() string {
+	return fmt.Sprintf("CORE: invalid search fields: %v", e.Err)
+}
+
+// SearchHit is an item matching a search, with its relevance score and an
+// excerpt of each matching field, its matched words wrapped in <mark> tags.
+type SearchHit struct {
+	Item       map[string]interface{} `json:"item"`
+	Score      float64                `json:"score"`
+	Highlights map[string]string      `json:"highlights"`
+}
+
+// searchFieldsFromMeta decodes the search fields in collection metadata and
+// checks them against schema.
+func searchFieldsFromMeta(schema, meta map[string]interface{}) ([][]string, error) {
+	raw, ok := meta[SearchFieldsKey]
+	if !ok || raw == nil {
+		return nil, nil
+	}
+	var list []interface{}
+	switch v := raw.(type) {
+	case []interface{}:
+		list = v
+	case []string:
+		for _, field := range v {
+			list = append(list, field)
+		}
+	default:
+		return nil, &InvalidSearchFieldsError{Err: errors.New("expected a list of fields")}
+	}
+	fields := make([][]string, 0, len(list))
+	for _, v := range list {
+		field, _ := v.(string)
+		path := strings.Split(field, ".")
+		for _, p := range path {
+			if p == "" {
+				return nil, &InvalidSearchFieldsError{Err: fmt.Errorf("invalid field %q", v)}
+			}
+		}
+		fieldType, _, err := queryFieldType(schema, path)
+		if err != nil {
+			return nil, &InvalidSearchFieldsError{Err: err}
+		}
+		if fieldType != "" && fieldType != "string" {
+			return nil, &InvalidSearchFieldsError{Err: fmt.Errorf("%s holds %s values, not text", field, fieldType)}
+		}
+		fields = append(fields, path)
+	}
+	return fields, nil
+}
+
+// indexItem replaces the indexed text of an item of collection. Collections
+// without search fields aren't indexed.
+func (cf *Config) indexItem(collection datalayer.CollectionVM, itemID string, item map[string]interface{}) error {
+	if cf.index == nil {
+		return nil
+	}
+	fields, err := searchFieldsFromMeta(collection.Schema, collection.Meta)
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+
+	text := map[string]string{}
+	for _, path := range fields {
+		value, _ := datalayer.Lookup(item, path)
+		switch v := value.(type) {
+		case string:
+			text[strings.Join(path, ".")] = v
+		case []interface{}:
+			parts := []string{}
+			for _, e := range v {
+				if s, ok := e.(string); ok {
+					parts = append(parts, s)
+				}
+			}
+			text[strings.Join(path, ".")] = strings.Join(parts, "\n")
+		}
+	}
+	return cf.index.Index(collection.Name, itemID, text)
+}
+
+// unindexItem removes an item from the search index.
+func (cf *Config) unindexItem(collectionName, itemID string) error {
+	if cf.index == nil {
+		return nil
+	}
+	return cf.index.Delete(collectionName, itemID)
+}
+
+// Search returns the items of a collection whose search fields match text,
+// most relevant first, paged like GetItems. Words within a typo or two of an
+// indexed word match too. If fields are listed, only those of each item are
+// returned.
+func (cf *Config) Search(ctx context.Context, collectionName, text string, queryMeta datalayer.QueryMeta) (hits []SearchHit, respInfo datalayer.ItemsResponseInfo, err error) {
+	if cf.index == nil {
+		return nil, respInfo, &datalayer.UnsupportedError{Capability: datalayer.FullTextSearch, Feature: "search"}
+	}
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, respInfo, err
+	}
+	if err = checkSearchable(collection, text); err != nil {
+		return nil, respInfo, err
+	}
+	if err = checkProjection(collection, queryMeta.Fields); err != nil {
+		return nil, respInfo, err
+	}
+
+	found, total, err := cf.index.Search(collectionName, text, queryMeta.Skip(), queryMeta.Count)
+	if err != nil {
+		return nil, respInfo, err
+	}
+	hits = make([]SearchHit, 0, len(found))
+	for _, hit := range found {
+		item, err := cf.datastore.GetItem(ctx, collectionName, hit.ID)
+		// The item was deleted since it was indexed, outside of ninja.
+		if errors.Cause(err) == datalayer.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, respInfo, err
+		}
+		if err = upgradeItems(collection, item); err != nil {
+			return nil, respInfo, err
+		}
+		hits = append(hits, SearchHit{Item: queryMeta.Fields.Apply(item), Score: hit.Score, Highlights: hit.Highlights})
+	}
+	return hits, queryMeta.ResponseInfo(total, len(hits)), nil
+}
+
+// checkSearchable checks that collection has search fields and text has
+// something to search for.
+func checkSearchable(collection datalayer.CollectionVM, text string) error {
+	fields, err := searchFieldsFromMeta(collection.Schema, collection.Meta)
+	if err != nil {
+		return err
+	}
+	if len(fields) == 0 {
+		return &datalayer.InvalidQueryError{Param: "q", Reason: fmt.Sprintf("%s has no %s", collection.Name, SearchFieldsKey)}
+	}
+	if strings.TrimSpace(text) == "" {
+		return &datalayer.InvalidQueryError{Param: "q", Reason: "empty query"}
+	}
+	return nil
+}
+
+// matchingIDs returns the IDs of every item of collection matching text, for
+// GetItems to narrow a listing to.
+func (cf *Config) matchingIDs(collection datalayer.CollectionVM, text string) ([]interface{}, error) {
+	if err := checkSearchable(collection, text); err != nil {
+		return nil, err
+	}
+	hits, _, err := cf.index.Search(collection.Name, text, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]interface{}, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	return ids, nil
+}
+
+// Reindex rebuilds the search index of a collection from its stored items and
+// returns how many items it indexed.
+func (cf *Config) Reindex(ctx context.Context, collectionName string) (int, error) {
+	if cf.index == nil {
+		return 0, &datalayer.UnsupportedError{Capability: datalayer.FullTextSearch, Feature: "search"}
+	}
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = searchFieldsFromMeta(collection.Schema, collection.Meta); err != nil {
+		return 0, err
+	}
+	if err = cf.index.Drop(collectionName); err != nil {
+		return 0, err
+	}
+
+	indexed := 0
+	err = cf.eachItem(ctx, collectionName, func(item map[string]interface{}) error {
+		if err := upgradeItems(collection, item); err != nil {
+			return err
+		}
+		indexed++
+		return cf.indexItem(collection, fmt.Sprint(item["_id"]), item)
+	})
+	return indexed, err
+}
+
+// reindexOnChange rebuilds the search index of a collection whose search
+// fields changed from before.
+func (cf *Config) reindexOnChange(ctx context.Context, before datalayer.CollectionVM) error {
+	if cf.index == nil {
+		return nil
+	}
+	after, err := cf.datastore.GetCollection(ctx, before.Name)
+	if err != nil {
+		return err
+	}
+	if reflect.DeepEqual(before.Meta[SearchFieldsKey], after.Meta[SearchFieldsKey]) {
+		return nil
+	}
+	_, err = cf.Reindex(ctx, after.Name)
+	return err
+}
+
+// UseSearchIndex makes core keep ix in sync with every write and serve
+// searches from it.
+func UseSearchIndex(ix *search.Index) configFunc {
+	return func(cf *Config) {
+		cf.index = ix
+	}
+}
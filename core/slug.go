@@ -0,0 +1,156 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// SlugFieldKey is the collection metadata entry naming the item field that
+// slugs are made from, e.g. "title". Collections without it have no slugs.
+const SlugFieldKey = "slug_field"
+
+// slugAttempts bounds how often a write is retried when a concurrent write
+// takes the slug it picked.
+const slugAttempts = 10
+
+// transliterations spells out letters that don't decompose into an ASCII
+// letter and accents.
+var transliterations = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d", 'ð': "d", 'þ': "th", 'ı': "i",
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh", 'з': "z",
+	'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o", 'п': "p", 'р': "r",
+	'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+}
+
+// Slugify turns s into a lower-case, URL-safe slug. Accents are dropped,
+// other non-ASCII letters are transliterated where possible, and every run of
+// remaining characters becomes a single hyphen.
+func Slugify(s string) string {
+	s, _, _ = transform.String(transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), strings.ToLower(s))
+
+	slug := strings.Builder{}
+	hyphen := false
+	write := func(r rune) {
+		if hyphen && slug.Len() > 0 {
+			slug.WriteByte('-')
+		}
+		hyphen = false
+		slug.WriteRune(r)
+	}
+	for _, r := range s {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			write(r)
+		case transliterations[r] != "":
+			for _, t := range transliterations[r] {
+				write(t)
+			}
+		default:
+			if _, silent := transliterations[r]; !silent {
+				hyphen = true
+			}
+		}
+	}
+	return slug.String()
+}
+
+// setSlug gives item a unique slug if its collection has slugs. A slug the
+// item carries is normalized and kept. When item replaces a stored item, the
+// stored item's slug is kept too, so URLs stay stable when the source field
+// changes.
+func (cf *Config) setSlug(ctx context.Context, collection datalayer.CollectionVM, itemID string, item map[string]interface{}, replacing bool) error {
+	field, _ := collection.Meta[SlugFieldKey].(string)
+	if field == "" {
+		return nil
+	}
+
+	source, _ := item[datalayer.SlugField].(string)
+	if source == "" && replacing {
+		stored, err := cf.datastore.GetItem(ctx, collection.Name, itemID)
+		if err != nil && errors.Cause(err) != datalayer.ErrNotFound {
+			return err
+		}
+		source, _ = stored[datalayer.SlugField].(string)
+	}
+	if source == "" {
+		source, _ = item[field].(string)
+	}
+	base := Slugify(source)
+	if base == "" {
+		base = Slugify(itemID)
+	}
+
+	slug, err := cf.uniqueSlug(ctx, collection.Name, itemID, base)
+	if err != nil {
+		return err
+	}
+	item[datalayer.SlugField] = slug
+	return nil
+}
+
+// writeWithSlug gives item a unique slug with setSlug and stores it with
+// write. Drivers reject slugs another item has, so if a concurrent write took
+// the slug first, the next free one is picked and the write retried.
+func (cf *Config) writeWithSlug(ctx context.Context, collection datalayer.CollectionVM, itemID string, item map[string]interface{}, replacing bool, write func() error) error {
+	for attempt := 1; ; attempt++ {
+		if err := cf.setSlug(ctx, collection, itemID, item, replacing); err != nil {
+			return err
+		}
+		err := write()
+		if errors.Cause(err) != datalayer.ErrConflict || attempt == slugAttempts || !cf.slugTaken(ctx, collection.Name, itemID, item) {
+			return err
+		}
+	}
+}
+
+// slugTaken reports whether an item other than itemID has the slug of item.
+func (cf *Config) slugTaken(ctx context.Context, collectionName, itemID string, item map[string]interface{}) bool {
+	slug := datalayer.SlugOf(item)
+	if slug == "" {
+		return false
+	}
+	stored, err := cf.datastore.GetItemBySlug(ctx, collectionName, slug)
+	return err == nil && stored["_id"] != itemID
+}
+
+// uniqueSlug returns base, or base with the first numeric suffix from 2 up
+// that no item other than itemID has taken.
+func (cf *Config) uniqueSlug(ctx context.Context, collectionName, itemID, base string) (string, error) {
+	slug := base
+	for n := 2; ; n++ {
+		item, err := cf.datastore.GetItemBySlug(ctx, collectionName, slug)
+		if errors.Cause(err) == datalayer.ErrNotFound {
+			return slug, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if item["_id"] == itemID {
+			return slug, nil
+		}
+		slug = fmt.Sprintf("%s-%d", base, n)
+	}
+}
+
+// GetItemBySlug returns the item with the given slug, upgraded to the current
+// schema version like GetItem.
+func (cf *Config) GetItemBySlug(ctx context.Context, collectionName, slug string) (map[string]interface{}, error) {
+	collection, err := cf.datastore.GetCollection(ctx, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	item, err := cf.datastore.GetItemBySlug(ctx, collectionName, slug)
+	if err != nil {
+		return nil, err
+	}
+	return item, upgradeItems(collection, item)
+}
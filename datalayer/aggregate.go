@@ -0,0 +1,247 @@
+package datalayer
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// MetricOp is the summary a Metric computes.
+type MetricOp string
+
+const (
+	Count MetricOp = "count"
+	Sum   MetricOp = "sum"
+	Min   MetricOp = "min"
+	Max   MetricOp = "max"
+	Avg   MetricOp = "avg"
+)
+
+// Metric summarises the items of a group. Count counts them and has no
+// Path. Sum and Avg add up the numbers at Path, and Min and Max pick the
+// lowest and highest value there, in the order of Sort. Items missing the
+// field, or holding null, are left out of all but Count.
+type Metric struct {
+	Op   MetricOp
+	Path []string
+}
+
+// Field returns the dot-separated Path of m.
+func (m Metric) Field() string {
+	return strings.Join(m.Path, ".")
+}
+
+// Name returns m as it is written in the `metrics` query parameter, e.g.
+// `sum(price)`. Results are keyed by it.
+func (m Metric) Name() string {
+	if m.Op == Count {
+		return string(Count)
+	}
+	return fmt.Sprintf("%s(%s)", m.Op, m.Field())
+}
+
+// Aggregation summarises the items of a collection that match Filter, with
+// one Group per distinct combination of values at the GroupBy paths, or a
+// single Group of every item if GroupBy is empty.
+type Aggregation struct {
+	Filter  Filter
+	GroupBy [][]string
+	Metrics []Metric
+}
+
+// Group is a result of an Aggregation. Key maps each dot-separated GroupBy
+// field to the group's value, nil for items missing the field, and Metrics
+// maps each metric's Name to its value: an int for Count, a float64 for Sum
+// and Avg, and a stored value for Min and Max. Avg, Min and Max are nil when
+// no item of the group has the field.
+type Group struct {
+	Key     map[string]interface{} `json:"key"`
+	Metrics map[string]interface{} `json:"metrics"`
+}
+
+var metricParam = regexp.MustCompile(`^(sum|min|max|avg)\(([^()]+)\)$`)
+
+// ParseAggregation reads the query parameters of an aggregation: `groupBy`, a
+// comma-separated list of dot-separated fields; `metrics`, a comma-separated
+// list of `count`, `sum(field)`, `min(field)`, `max(field)` and `avg(field)`,
+// which defaults to `count`; and the filter parameters of item listings.
+func ParseAggregation(params url.Values) (a Aggregation, err error) {
+	if a.Filter, err = ParseFilter(params); err != nil {
+		return a, err
+	}
+
+	seen := map[string]bool{}
+	if groupBy := params.Get("groupBy"); groupBy != "" {
+		for _, field := range strings.Split(groupBy, ",") {
+			path, ok := parsePath(field)
+			if !ok {
+				return a, &InvalidQueryError{Param: "groupBy", Reason: fmt.Sprintf("invalid field %q", field)}
+			}
+			if seen[field] {
+				return a, &InvalidQueryError{Param: "groupBy", Reason: fmt.Sprintf("%q is repeated", field)}
+			}
+			seen[field] = true
+			a.GroupBy = append(a.GroupBy, path)
+		}
+	}
+
+	metrics := params.Get("metrics")
+	if metrics == "" {
+		metrics = string(Count)
+	}
+	seen = map[string]bool{}
+	for _, name := range strings.Split(metrics, ",") {
+		m := Metric{Op: Count}
+		if name != string(Count) {
+			match := metricParam.FindStringSubmatch(name)
+			if match == nil {
+				return a, &InvalidQueryError{Param: "metrics", Reason: fmt.Sprintf("unknown metric %q", name)}
+			}
+			path, ok := parsePath(match[2])
+			if !ok {
+				return a, &InvalidQueryError{Param: "metrics", Reason: fmt.Sprintf("invalid field %q", match[2])}
+			}
+			m = Metric{Op: MetricOp(match[1]), Path: path}
+		}
+		if seen[m.Name()] {
+			return a, &InvalidQueryError{Param: "metrics", Reason: fmt.Sprintf("%q is repeated", name)}
+		}
+		seen[m.Name()] = true
+		a.Metrics = append(a.Metrics, m)
+	}
+	return a, nil
+}
+
+// Aggregator computes an Aggregation one item at a time, so drivers that
+// can't aggregate natively can stream their items through it instead of
+// loading them all.
+type Aggregator struct {
+	aggregation Aggregation
+	groups      map[string]*accumulator
+}
+
+type accumulator struct {
+	key   []interface{}
+	count int
+	// sums and numbers hold the sum and count of the numbers at each metric's
+	// path, and extremes the lowest or highest value there.
+	sums     []float64
+	numbers  []int
+	extremes []interface{}
+}
+
+// NewAggregator returns an Aggregator of a.
+func NewAggregator(a Aggregation) *Aggregator {
+	ag := &Aggregator{aggregation: a, groups: map[string]*accumulator{}}
+	if len(a.GroupBy) == 0 {
+		// The single group exists even if no item matches.
+		ag.group([]interface{}{})
+	}
+	return ag
+}
+
+func (ag *Aggregator) group(key []interface{}) *accumulator {
+	id, _ := json.Marshal(key)
+	acc, ok := ag.groups[string(id)]
+	if !ok {
+		n := len(ag.aggregation.Metrics)
+		acc = &accumulator{key: key, sums: make([]float64, n), numbers: make([]int, n), extremes: make([]interface{}, n)}
+		ag.groups[string(id)] = acc
+	}
+	return acc
+}
+
+// Add counts item in its group if it matches the aggregation's filter.
+func (ag *Aggregator) Add(item map[string]interface{}) {
+	if !ag.aggregation.Filter.Matches(item) {
+		return
+	}
+	key := make([]interface{}, len(ag.aggregation.GroupBy))
+	for i, path := range ag.aggregation.GroupBy {
+		value, _ := Lookup(item, path)
+		key[i] = normalizeNumber(value)
+	}
+	acc := ag.group(key)
+	acc.count++
+
+	for i, m := range ag.aggregation.Metrics {
+		if m.Op == Count {
+			continue
+		}
+		value, _ := Lookup(item, m.Path)
+		if value == nil {
+			continue
+		}
+		switch m.Op {
+		case Sum, Avg:
+			if n, ok := number(value); ok {
+				acc.sums[i] += n
+				acc.numbers[i]++
+			}
+		case Min, Max:
+			value = normalizeNumber(value)
+			cmp := sortCompare(value, acc.extremes[i])
+			if acc.extremes[i] == nil || (m.Op == Min && cmp < 0) || (m.Op == Max && cmp > 0) {
+				acc.extremes[i] = value
+			}
+		}
+	}
+}
+
+// Groups returns the results of the items added so far, sorted by key.
+func (ag *Aggregator) Groups() []Group {
+	groups := make([]Group, 0, len(ag.groups))
+	for _, acc := range ag.groups {
+		g := Group{Key: map[string]interface{}{}, Metrics: map[string]interface{}{}}
+		for i, path := range ag.aggregation.GroupBy {
+			g.Key[strings.Join(path, ".")] = acc.key[i]
+		}
+		for i, m := range ag.aggregation.Metrics {
+			switch m.Op {
+			case Count:
+				g.Metrics[m.Name()] = acc.count
+			case Sum:
+				g.Metrics[m.Name()] = acc.sums[i]
+			case Avg:
+				g.Metrics[m.Name()] = nil
+				if acc.numbers[i] > 0 {
+					g.Metrics[m.Name()] = acc.sums[i] / float64(acc.numbers[i])
+				}
+			default:
+				g.Metrics[m.Name()] = acc.extremes[i]
+			}
+		}
+		groups = append(groups, g)
+	}
+	ag.aggregation.SortGroups(groups)
+	return groups
+}
+
+// SortGroups orders groups by the values of their keys, in the order of
+// GroupBy, with the value order of Sort.
+func (a Aggregation) SortGroups(groups []Group) {
+	fields := make([]string, len(a.GroupBy))
+	for i, path := range a.GroupBy {
+		fields[i] = strings.Join(path, ".")
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		for _, field := range fields {
+			if cmp := sortCompare(groups[i].Key[field], groups[j].Key[field]); cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return false
+	})
+}
+
+// normalizeNumber returns numbers of any type as float64, so drivers that
+// decode numbers differently give the same results.
+func normalizeNumber(v interface{}) interface{} {
+	if n, ok := number(v); ok {
+		return n
+	}
+	return v
+}
@@ -0,0 +1,989 @@
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Datastore keeps collections in a single bbolt file. The schema collection is
+// one bucket keyed by collection name, and every collection's items live in a
+// bucket of their own keyed by item ID. Trashed items live in a bucket per
+// collection nested under the <schema collection>_trash bucket. Revisions
+// live under <schema collection>_revisions, in a bucket per collection and
+// item keyed by revision number. The slug of every stored item maps to its ID
+// in a bucket per collection under <schema collection>_slugs.
+type Datastore struct {
+	DB               *bolt.DB
+	SchemaCollection string
+}
+
+const (
+	DriverName = "bolt"
+
+	defaultSchemaCollection = "schema_collection"
+	trashSuffix             = "_trash"
+	revisionsSuffix         = "_revisions"
+	slugsSuffix             = "_slugs"
+)
+
+func init() {
+	datalayer.Register(DriverName, &Datastore{})
+}
+
+func NewDatastore(config datalayer.DBConfig) (*Datastore, error) {
+	ds := Datastore{}
+	db, err := bolt.Open(config.ConnectionString, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "bolt: unable to open database")
+	}
+	ds.DB = db
+	ds.SchemaCollection = config.SchemaCollectionName
+	if ds.SchemaCollection == "" {
+		ds.SchemaCollection = defaultSchemaCollection
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists([]byte(ds.SchemaCollection)); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(ds.trashBucket())); err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists([]byte(ds.revisionsBucket())); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists([]byte(ds.slugsBucket()))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "bolt: unable to create schema bucket")
+	}
+	if err = db.Update(ds.indexAllSlugs); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "bolt: unable to index slugs")
+	}
+	return &ds, nil
+}
+
+func (ds *Datastore) Connect(config datalayer.DBConfig) (datalayer.DataStore, error) {
+	return NewDatastore(config)
+}
+
+func (ds *Datastore) Capabilities() datalayer.Capabilities {
+	return datalayer.Capabilities{datalayer.Transactions}
+}
+
+// Close releases the lock on the database file.
+func (ds *Datastore) Close() error {
+	return errors.Wrap(ds.DB.Close(), "bolt: unable to close database")
+}
+
+type collectionData struct {
+	Name     string                   `json:"_id"`
+	Schema   map[string]interface{}   `json:"schema"` // latest version
+	MetaData map[string]interface{}   `json:"metadata"`
+	Versions []map[string]interface{} `json:"versions,omitempty"`
+}
+
+// versions returns every schema version, oldest first. Collections created
+// before schemas were versioned only have their current schema.
+func (data collectionData) versions() []map[string]interface{} {
+	if len(data.Versions) == 0 {
+		return []map[string]interface{}{data.Schema}
+	}
+	return data.Versions
+}
+
+func (data collectionData) vm() datalayer.CollectionVM {
+	return datalayer.CollectionVM{
+		Name:    data.Name,
+		Schema:  data.Schema,
+		Meta:    data.MetaData,
+		Version: len(data.versions()),
+	}
+}
+
+// collection reads the schema document of a collection.
+func (ds *Datastore) collection(tx *bolt.Tx, name string) (data collectionData, err error) {
+	value := tx.Bucket([]byte(ds.SchemaCollection)).Get([]byte(name))
+	if value == nil {
+		return data, datalayer.ErrNotFound
+	}
+	err = json.Unmarshal(value, &data)
+	return data, err
+}
+
+func (ds *Datastore) CreateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ds.reserved(name) {
+		return errors.Errorf("bolt: unable to create collection: %s is reserved", name)
+	}
+
+	data := collectionData{}
+	data.Name = name
+	data.Schema = schema
+	data.MetaData = metadata
+	data.Versions = []map[string]interface{}{schema}
+	value, err := json.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "bolt: unable to create collection")
+	}
+
+	err = ds.DB.Update(func(tx *bolt.Tx) error {
+		schemas := tx.Bucket([]byte(ds.SchemaCollection))
+		if schemas.Get([]byte(name)) != nil {
+			return errors.Wrap(datalayer.ErrConflict, name)
+		}
+		if _, err := tx.CreateBucket([]byte(name)); err != nil {
+			return err
+		}
+		if _, err := tx.Bucket([]byte(ds.slugsBucket())).CreateBucket([]byte(name)); err != nil {
+			return err
+		}
+		return schemas.Put([]byte(name), value)
+	})
+	return errors.Wrap(err, "bolt: unable to create collection")
+}
+
+func (ds *Datastore) GetCollections(ctx context.Context) (collections []datalayer.CollectionVM, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(ds.SchemaCollection)).ForEach(func(k, v []byte) error {
+			data := collectionData{}
+			if err := json.Unmarshal(v, &data); err != nil {
+				return err
+			}
+			collections = append(collections, data.vm())
+			return nil
+		})
+	})
+	return collections, errors.Wrap(err, "bolt: unable to get collections")
+}
+
+func (ds *Datastore) GetCollection(ctx context.Context, name string) (collection datalayer.CollectionVM, err error) {
+	if err := ctx.Err(); err != nil {
+		return collection, err
+	}
+
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		data, err := ds.collection(tx, name)
+		collection = data.vm()
+		return err
+	})
+	return collection, errors.Wrap(err, "bolt: unable to get collection")
+}
+
+func (ds *Datastore) GetSchemaVersions(ctx context.Context, name string) (versions []datalayer.SchemaVersion, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		data, err := ds.collection(tx, name)
+		if err != nil {
+			return err
+		}
+		for i, schema := range data.versions() {
+			versions = append(versions, datalayer.SchemaVersion{Version: i + 1, Schema: schema})
+		}
+		return nil
+	})
+	return versions, errors.Wrap(err, "bolt: unable to get schema versions")
+}
+
+// reserved reports whether name clashes with the buckets the datastore keeps
+// for itself.
+func (ds *Datastore) reserved(name string) bool {
+	return name == ds.SchemaCollection || name == ds.trashBucket() || name == ds.revisionsBucket() || name == ds.slugsBucket()
+}
+
+func (ds *Datastore) UpdateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := ds.DB.Update(func(tx *bolt.Tx) error {
+		schemas := tx.Bucket([]byte(ds.SchemaCollection))
+		data, err := ds.collection(tx, name)
+		if err != nil {
+			return err
+		}
+		if schema != nil {
+			data.Versions = append(data.versions(), schema)
+			data.Schema = schema
+		}
+		if metadata != nil {
+			data.MetaData = metadata
+		}
+		value, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		return schemas.Put([]byte(name), value)
+	})
+	return errors.Wrap(err, "bolt: unable to update collection")
+}
+
+func (ds *Datastore) RenameCollection(ctx context.Context, name, newName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if ds.reserved(newName) {
+		return errors.Errorf("bolt: unable to rename collection: %s is reserved", newName)
+	}
+
+	err := ds.DB.Update(func(tx *bolt.Tx) error {
+		schemas := tx.Bucket([]byte(ds.SchemaCollection))
+		value := schemas.Get([]byte(name))
+		if value == nil {
+			return datalayer.ErrNotFound
+		}
+		if schemas.Get([]byte(newName)) != nil {
+			return errors.Wrap(datalayer.ErrConflict, newName)
+		}
+		data := collectionData{}
+		if err := json.Unmarshal(value, &data); err != nil {
+			return err
+		}
+		data.Name = newName
+		value, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+
+		// bbolt can't rename buckets, so items are copied into a new one.
+		if err := copyBucket(tx.Bucket([]byte(name)), tx, []byte(newName)); err != nil {
+			return err
+		}
+		if err := tx.DeleteBucket([]byte(name)); err != nil {
+			return err
+		}
+		for _, rootName := range []string{ds.trashBucket(), ds.revisionsBucket(), ds.slugsBucket()} {
+			root := tx.Bucket([]byte(rootName))
+			if src := root.Bucket([]byte(name)); src != nil {
+				if err := copyBucket(src, root, []byte(newName)); err != nil {
+					return err
+				}
+				if err := root.DeleteBucket([]byte(name)); err != nil {
+					return err
+				}
+			}
+		}
+		if err := schemas.Delete([]byte(name)); err != nil {
+			return err
+		}
+		return schemas.Put([]byte(newName), value)
+	})
+	return errors.Wrap(err, "bolt: unable to rename collection")
+}
+
+// bucketCreator is satisfied by both *bolt.Tx and *bolt.Bucket.
+type bucketCreator interface {
+	CreateBucket(name []byte) (*bolt.Bucket, error)
+}
+
+// copyBucket copies src, with its nested buckets and sequence, into a new
+// bucket named name under parent.
+func copyBucket(src *bolt.Bucket, parent bucketCreator, name []byte) error {
+	dst, err := parent.CreateBucket(name)
+	if err != nil {
+		return err
+	}
+	if err = dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+	return src.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return copyBucket(src.Bucket(k), dst, k)
+		}
+		return dst.Put(k, v)
+	})
+}
+
+func (ds *Datastore) DeleteCollection(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := ds.DB.Update(func(tx *bolt.Tx) error {
+		schemas := tx.Bucket([]byte(ds.SchemaCollection))
+		if schemas.Get([]byte(name)) == nil {
+			return datalayer.ErrNotFound
+		}
+		if err := tx.DeleteBucket([]byte(name)); err != nil {
+			return err
+		}
+		for _, rootName := range []string{ds.trashBucket(), ds.revisionsBucket(), ds.slugsBucket()} {
+			root := tx.Bucket([]byte(rootName))
+			if root.Bucket([]byte(name)) != nil {
+				if err := root.DeleteBucket([]byte(name)); err != nil {
+					return err
+				}
+			}
+		}
+		return schemas.Delete([]byte(name))
+	})
+	return errors.Wrap(err, "bolt: unable to delete collection")
+}
+
+func (ds *Datastore) GetSchema(ctx context.Context, collectionName string) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	result := collectionData{}
+	err := ds.DB.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket([]byte(ds.SchemaCollection)).Get([]byte(collectionName))
+		if value == nil {
+			return datalayer.ErrNotFound
+		}
+		return json.Unmarshal(value, &result)
+	})
+	return result.Schema, errors.Wrap(err, "bolt: unable to get schema")
+}
+
+// items returns the item bucket of a collection, or ErrNotFound if the
+// collection was never created.
+func (ds *Datastore) items(tx *bolt.Tx, collectionName string) (*bolt.Bucket, error) {
+	if ds.reserved(collectionName) {
+		return nil, datalayer.ErrNotFound
+	}
+	bucket := tx.Bucket([]byte(collectionName))
+	if bucket == nil {
+		return nil, datalayer.ErrNotFound
+	}
+	return bucket, nil
+}
+
+func (ds *Datastore) SaveItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	item["_id"] = itemID
+	item[datalayer.VersionField] = 1
+	value, err := json.Marshal(item)
+	if err != nil {
+		return errors.Wrap(err, "bolt: unable to save item")
+	}
+
+	err = ds.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+		if bucket.Get([]byte(itemID)) != nil {
+			return errors.Wrap(datalayer.ErrConflict, itemID)
+		}
+		if err := ds.checkTrashed(tx, collectionName, itemID); err != nil {
+			return err
+		}
+		if err := ds.indexSlug(tx, collectionName, itemID, nil, item); err != nil {
+			return err
+		}
+		return bucket.Put([]byte(itemID), value)
+	})
+	return errors.Wrap(err, "bolt: unable to save item")
+}
+
+func (ds *Datastore) UpdateItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}, ifVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	item["_id"] = itemID
+	err := ds.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+		stored, err := storedItem(bucket, itemID, ifVersion)
+		if err != nil {
+			return err
+		}
+		if err := ds.indexSlug(tx, collectionName, itemID, stored, item); err != nil {
+			return err
+		}
+		item[datalayer.VersionField] = datalayer.VersionOf(stored) + 1
+		value, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(itemID), value)
+	})
+	return errors.Wrap(err, "bolt: unable to update item")
+}
+
+func (ds *Datastore) UpsertItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) (created bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	item["_id"] = itemID
+	err = ds.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+		stored, err := storedItem(bucket, itemID, 0)
+		switch {
+		case err == datalayer.ErrNotFound:
+			if err := ds.checkTrashed(tx, collectionName, itemID); err != nil {
+				return err
+			}
+			created = true
+			item[datalayer.VersionField] = 1
+		case err != nil:
+			return err
+		default:
+			item[datalayer.VersionField] = datalayer.VersionOf(stored) + 1
+		}
+		if err := ds.indexSlug(tx, collectionName, itemID, stored, item); err != nil {
+			return err
+		}
+		value, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(itemID), value)
+	})
+	return created, errors.Wrap(err, "bolt: unable to upsert item")
+}
+
+func (ds *Datastore) slugsBucket() string {
+	return ds.SchemaCollection + slugsSuffix
+}
+
+// indexSlug moves itemID in the slug index of a collection from the slug of
+// stored to the slug of item. Either may be nil, for an item that is new or
+// gone. It returns ErrConflict if another item has the slug of item.
+func (ds *Datastore) indexSlug(tx *bolt.Tx, collectionName, itemID string, stored, item map[string]interface{}) error {
+	slugs := tx.Bucket([]byte(ds.slugsBucket())).Bucket([]byte(collectionName))
+	slug, oldSlug := datalayer.SlugOf(item), datalayer.SlugOf(stored)
+	if slug != "" {
+		if id := slugs.Get([]byte(slug)); id != nil && string(id) != itemID {
+			return errors.Wrapf(datalayer.ErrConflict, "slug %s", slug)
+		}
+	}
+	if oldSlug != "" && oldSlug != slug {
+		if err := slugs.Delete([]byte(oldSlug)); err != nil {
+			return err
+		}
+	}
+	if slug == "" {
+		return nil
+	}
+	return slugs.Put([]byte(slug), []byte(itemID))
+}
+
+// indexAllSlugs builds the slug index of collections created before slugs
+// were indexed. It fails if two items of a collection share a slug.
+func (ds *Datastore) indexAllSlugs(tx *bolt.Tx) error {
+	root := tx.Bucket([]byte(ds.slugsBucket()))
+	names := [][]byte{}
+	err := tx.Bucket([]byte(ds.SchemaCollection)).ForEach(func(k, v []byte) error {
+		if root.Bucket(k) == nil {
+			names = append(names, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if _, err := root.CreateBucket(name); err != nil {
+			return err
+		}
+		bucket := tx.Bucket(name)
+		if bucket == nil {
+			continue
+		}
+		err := bucket.ForEach(func(k, v []byte) error {
+			item := map[string]interface{}{}
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			return ds.indexSlug(tx, string(name), string(k), nil, item)
+		})
+		if err != nil {
+			return errors.Wrap(err, string(name))
+		}
+	}
+	return nil
+}
+
+// storedItem reads an item from bucket, checking that it is at ifVersion.
+func storedItem(bucket *bolt.Bucket, itemID string, ifVersion int) (item map[string]interface{}, err error) {
+	value := bucket.Get([]byte(itemID))
+	if value == nil {
+		return nil, datalayer.ErrNotFound
+	}
+	if err = json.Unmarshal(value, &item); err != nil {
+		return nil, err
+	}
+	return item, datalayer.CheckVersion(item, ifVersion)
+}
+
+func (ds *Datastore) GetItem(ctx context.Context, collectionName, itemID string) (item map[string]interface{}, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+		value := bucket.Get([]byte(itemID))
+		if value == nil {
+			return datalayer.ErrNotFound
+		}
+		return json.Unmarshal(value, &item)
+	})
+	return item, errors.Wrap(err, "bolt: unable to get item")
+}
+
+func (ds *Datastore) GetItemBySlug(ctx context.Context, collectionName, slug string) (item map[string]interface{}, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+		itemID := tx.Bucket([]byte(ds.slugsBucket())).Bucket([]byte(collectionName)).Get([]byte(slug))
+		if itemID == nil {
+			return datalayer.ErrNotFound
+		}
+		value := bucket.Get(itemID)
+		if value == nil {
+			return datalayer.ErrNotFound
+		}
+		return json.Unmarshal(value, &item)
+	})
+	return item, errors.Wrap(err, "bolt: unable to get item by slug")
+}
+
+func (ds *Datastore) GetItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, respInfo, err
+	}
+
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+
+		if len(queryMeta.Filter) > 0 || len(queryMeta.Sort) > 0 {
+			// Every item has to be read to count the matches and sort them.
+			matches := []map[string]interface{}{}
+			err := bucket.ForEach(func(k, v []byte) error {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				item := map[string]interface{}{}
+				if err := json.Unmarshal(v, &item); err != nil {
+					return err
+				}
+				if queryMeta.Filter.Matches(item) {
+					matches = append(matches, item)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			queryMeta.Sort.Apply(matches)
+			page := queryMeta.Resume(matches)
+			start, end := queryMeta.Window(len(page))
+			items = page[start:end]
+			respInfo = queryMeta.ResponseInfo(len(matches), len(items))
+			return nil
+		}
+
+		// Keys are item IDs, in _id order, so a cursor page seeks right past
+		// the cursor's item.
+		total := bucket.Stats().KeyN
+		start, end := queryMeta.Window(total)
+		items = make([]map[string]interface{}, 0, end-start)
+		cursor := bucket.Cursor()
+		k, v := cursor.First()
+		if queryMeta.Cursor != nil {
+			id := []byte(queryMeta.Cursor.ID)
+			if k, v = cursor.Seek(id); bytes.Equal(k, id) {
+				k, v = cursor.Next()
+			}
+		}
+		i := 0
+		for ; k != nil && i < end; k, v = cursor.Next() {
+			if i >= start {
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				item := map[string]interface{}{}
+				if err := json.Unmarshal(v, &item); err != nil {
+					return err
+				}
+				items = append(items, item)
+			}
+			i++
+		}
+		respInfo = queryMeta.ResponseInfo(total, len(items))
+		return nil
+	})
+	for i := range items {
+		items[i] = queryMeta.Fields.Apply(items[i])
+	}
+	return items, respInfo, errors.Wrap(err, "bolt: unable to get items")
+}
+
+func (ds *Datastore) Aggregate(ctx context.Context, collectionName string, aggregation datalayer.Aggregation) (groups []datalayer.Group, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	aggregator := datalayer.NewAggregator(aggregation)
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			item := map[string]interface{}{}
+			if err := json.Unmarshal(v, &item); err != nil {
+				return err
+			}
+			aggregator.Add(item)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "bolt: unable to aggregate items")
+	}
+	return aggregator.Groups(), nil
+}
+
+func (ds *Datastore) trashBucket() string {
+	return ds.SchemaCollection + trashSuffix
+}
+
+// trash returns the trash bucket of a collection, creating it if create is
+// set. A collection with nothing trashed yet has a nil trash bucket.
+func (ds *Datastore) trash(tx *bolt.Tx, collectionName string, create bool) (*bolt.Bucket, error) {
+	if _, err := ds.items(tx, collectionName); err != nil {
+		return nil, err
+	}
+	root := tx.Bucket([]byte(ds.trashBucket()))
+	if create {
+		return root.CreateBucketIfNotExists([]byte(collectionName))
+	}
+	return root.Bucket([]byte(collectionName)), nil
+}
+
+// checkTrashed returns ErrConflict if itemID is in the trash of a collection,
+// so a new item can't take over the revisions of a trashed one.
+func (ds *Datastore) checkTrashed(tx *bolt.Tx, collectionName, itemID string) error {
+	trash, err := ds.trash(tx, collectionName, false)
+	if err != nil {
+		return err
+	}
+	if trash != nil && trash.Get([]byte(itemID)) != nil {
+		return errors.Wrapf(datalayer.ErrConflict, "%s is in the trash", itemID)
+	}
+	return nil
+}
+
+func (ds *Datastore) DeleteItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := ds.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+		stored, err := storedItem(bucket, itemID, ifVersion)
+		if err != nil {
+			return err
+		}
+		if err := ds.indexSlug(tx, collectionName, itemID, stored, nil); err != nil {
+			return err
+		}
+		if err := ds.deleteRevisions(tx, collectionName, itemID); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(itemID))
+	})
+	return errors.Wrap(err, "bolt: unable to delete item")
+}
+
+func (ds *Datastore) TrashItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := ds.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+		trash, err := ds.trash(tx, collectionName, true)
+		if err != nil {
+			return err
+		}
+		item, err := storedItem(bucket, itemID, ifVersion)
+		if err != nil {
+			return err
+		}
+		if err := ds.indexSlug(tx, collectionName, itemID, item, nil); err != nil {
+			return err
+		}
+		item[datalayer.DeletedAtField] = datalayer.DeletedAt()
+		value, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if err := trash.Put([]byte(itemID), value); err != nil {
+			return err
+		}
+		return bucket.Delete([]byte(itemID))
+	})
+	return errors.Wrap(err, "bolt: unable to trash item")
+}
+
+func (ds *Datastore) RestoreItem(ctx context.Context, collectionName, itemID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := ds.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := ds.items(tx, collectionName)
+		if err != nil {
+			return err
+		}
+		trash, err := ds.trash(tx, collectionName, false)
+		if err != nil {
+			return err
+		}
+		if trash == nil || trash.Get([]byte(itemID)) == nil {
+			return datalayer.ErrNotFound
+		}
+		if bucket.Get([]byte(itemID)) != nil {
+			return errors.Wrap(datalayer.ErrConflict, itemID)
+		}
+
+		item := map[string]interface{}{}
+		if err := json.Unmarshal(trash.Get([]byte(itemID)), &item); err != nil {
+			return err
+		}
+		if err := ds.indexSlug(tx, collectionName, itemID, nil, item); err != nil {
+			return err
+		}
+		delete(item, datalayer.DeletedAtField)
+		value, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if err := bucket.Put([]byte(itemID), value); err != nil {
+			return err
+		}
+		return trash.Delete([]byte(itemID))
+	})
+	return errors.Wrap(err, "bolt: unable to restore item")
+}
+
+func (ds *Datastore) PurgeItem(ctx context.Context, collectionName, itemID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := ds.DB.Update(func(tx *bolt.Tx) error {
+		trash, err := ds.trash(tx, collectionName, false)
+		if err != nil {
+			return err
+		}
+		if trash == nil || trash.Get([]byte(itemID)) == nil {
+			return datalayer.ErrNotFound
+		}
+		if err := ds.deleteRevisions(tx, collectionName, itemID); err != nil {
+			return err
+		}
+		return trash.Delete([]byte(itemID))
+	})
+	return errors.Wrap(err, "bolt: unable to purge item")
+}
+
+func (ds *Datastore) GetTrashedItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, respInfo, err
+	}
+
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		trash, err := ds.trash(tx, collectionName, false)
+		if err != nil {
+			return err
+		}
+		items = []map[string]interface{}{}
+		if trash == nil {
+			respInfo = queryMeta.ResponseInfo(0, 0)
+			return nil
+		}
+
+		total := trash.Stats().KeyN
+		start, end := queryMeta.Window(total)
+		cursor := trash.Cursor()
+		i := 0
+		for k, v := cursor.First(); k != nil && i < end; k, v = cursor.Next() {
+			if i >= start {
+				item := map[string]interface{}{}
+				if err := json.Unmarshal(v, &item); err != nil {
+					return err
+				}
+				items = append(items, item)
+			}
+			i++
+		}
+		respInfo = queryMeta.ResponseInfo(total, len(items))
+		return nil
+	})
+	return items, respInfo, errors.Wrap(err, "bolt: unable to get trashed items")
+}
+
+func (ds *Datastore) revisionsBucket() string {
+	return ds.SchemaCollection + revisionsSuffix
+}
+
+// revisions returns the revision bucket of an item, creating it if create is
+// set. An item with no revisions yet has a nil revision bucket.
+func (ds *Datastore) revisions(tx *bolt.Tx, collectionName, itemID string, create bool) (*bolt.Bucket, error) {
+	if _, err := ds.items(tx, collectionName); err != nil {
+		return nil, err
+	}
+	root := tx.Bucket([]byte(ds.revisionsBucket()))
+	if !create {
+		if collection := root.Bucket([]byte(collectionName)); collection != nil {
+			return collection.Bucket([]byte(itemID)), nil
+		}
+		return nil, nil
+	}
+	collection, err := root.CreateBucketIfNotExists([]byte(collectionName))
+	if err != nil {
+		return nil, err
+	}
+	return collection.CreateBucketIfNotExists([]byte(itemID))
+}
+
+func (ds *Datastore) deleteRevisions(tx *bolt.Tx, collectionName, itemID string) error {
+	collection := tx.Bucket([]byte(ds.revisionsBucket())).Bucket([]byte(collectionName))
+	if collection == nil || collection.Bucket([]byte(itemID)) == nil {
+		return nil
+	}
+	return collection.DeleteBucket([]byte(itemID))
+}
+
+// revisionKey encodes a revision number so keys sort in revision order.
+func revisionKey(number int) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(number))
+	return key
+}
+
+func (ds *Datastore) SaveRevision(ctx context.Context, collectionName, itemID string, revision datalayer.Revision) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(revision)
+	if err != nil {
+		return errors.Wrap(err, "bolt: unable to save revision")
+	}
+	err = ds.DB.Update(func(tx *bolt.Tx) error {
+		bucket, err := ds.revisions(tx, collectionName, itemID, true)
+		if err != nil {
+			return err
+		}
+		if bucket.Get(revisionKey(revision.Revision)) != nil {
+			return errors.Wrapf(datalayer.ErrConflict, "revision %d", revision.Revision)
+		}
+		return bucket.Put(revisionKey(revision.Revision), value)
+	})
+	return errors.Wrap(err, "bolt: unable to save revision")
+}
+
+func (ds *Datastore) GetRevisions(ctx context.Context, collectionName, itemID string, queryMeta datalayer.QueryMeta) (revisions []datalayer.Revision, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, respInfo, err
+	}
+
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		bucket, err := ds.revisions(tx, collectionName, itemID, false)
+		if err != nil {
+			return err
+		}
+		revisions = []datalayer.Revision{}
+		if bucket == nil {
+			respInfo = queryMeta.ResponseInfo(0, 0)
+			return nil
+		}
+
+		total := bucket.Stats().KeyN
+		start, end := queryMeta.Window(total)
+		cursor := bucket.Cursor()
+		i := 0
+		for k, v := cursor.First(); k != nil && i < end; k, v = cursor.Next() {
+			if i >= start {
+				revision := datalayer.Revision{}
+				if err := json.Unmarshal(v, &revision); err != nil {
+					return err
+				}
+				revisions = append(revisions, revision)
+			}
+			i++
+		}
+		respInfo = queryMeta.ResponseInfo(total, len(revisions))
+		return nil
+	})
+	return revisions, respInfo, errors.Wrap(err, "bolt: unable to get revisions")
+}
+
+func (ds *Datastore) GetRevision(ctx context.Context, collectionName, itemID string, number int) (revision datalayer.Revision, err error) {
+	if err := ctx.Err(); err != nil {
+		return revision, err
+	}
+
+	err = ds.DB.View(func(tx *bolt.Tx) error {
+		bucket, err := ds.revisions(tx, collectionName, itemID, false)
+		if err != nil {
+			return err
+		}
+		if bucket == nil || number < 1 {
+			return datalayer.ErrNotFound
+		}
+		value := bucket.Get(revisionKey(number))
+		if value == nil {
+			return datalayer.ErrNotFound
+		}
+		return json.Unmarshal(value, &revision)
+	})
+	return revision, errors.Wrap(err, "bolt: unable to get revision")
+}
@@ -0,0 +1,75 @@
+package bolt
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+
+	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/tonyalaribe/ninja/datalayer/datastoretest"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestConformance(t *testing.T) {
+	datastoretest.RunConformance(t, func(t *testing.T) datalayer.DataStore {
+		dir, err := ioutil.TempDir("", "ninja-bolt")
+		if err != nil {
+			t.Fatalf("unable to create temp dir: %v", err)
+		}
+		ds, err := NewDatastore(datalayer.DBConfig{ConnectionString: filepath.Join(dir, "ninja.db")})
+		if err != nil {
+			t.Fatalf("unable to create datastore: %v", err)
+		}
+		t.Cleanup(func() {
+			ds.DB.Close()
+			os.RemoveAll(dir)
+		})
+		return ds
+	})
+}
+
+func TestSlugIndexMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ninja-bolt")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	config := datalayer.DBConfig{ConnectionString: filepath.Join(dir, "ninja.db")}
+	ctx := context.Background()
+
+	// A collection from before slugs were indexed.
+	ds, err := NewDatastore(config)
+	if err != nil {
+		t.Fatalf("unable to create datastore: %v", err)
+	}
+	err = ds.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, map[string]interface{}{})
+	if err == nil {
+		err = ds.SaveItem(ctx, "posts", "a", map[string]interface{}{datalayer.SlugField: "hello"})
+	}
+	if err == nil {
+		err = ds.DB.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket([]byte(ds.slugsBucket())).DeleteBucket([]byte("posts"))
+		})
+	}
+	ds.Close()
+	if err != nil {
+		t.Fatalf("unable to create old collection: %v", err)
+	}
+
+	if ds, err = NewDatastore(config); err != nil {
+		t.Fatalf("unable to create datastore: %v", err)
+	}
+	defer ds.Close()
+	item, err := ds.GetItemBySlug(ctx, "posts", "hello")
+	if err != nil || item["_id"] != "a" {
+		t.Errorf("got item %v with error %v, want a", item, err)
+	}
+	err = ds.SaveItem(ctx, "posts", "b", map[string]interface{}{datalayer.SlugField: "hello"})
+	if errors.Cause(err) != datalayer.ErrConflict {
+		t.Errorf("saving a taken slug returned %v, want ErrConflict", err)
+	}
+}
@@ -0,0 +1,76 @@
+package datalayer
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Capability names an optional feature that some drivers support.
+type Capability string
+
+const (
+	Transactions        Capability = "transactions"
+	FullTextSearch      Capability = "full_text_search"
+	RegexFilters        Capability = "regex_filters"
+	ChangeNotifications Capability = "change_notifications"
+	SecondaryIndexes    Capability = "secondary_indexes"
+)
+
+// CapabilityReporter is implemented by drivers that support optional
+// features. Drivers that don't implement it are assumed to support none.
+type CapabilityReporter interface {
+	Capabilities() Capabilities
+}
+
+// Capabilities is the set of optional features a driver supports.
+type Capabilities []Capability
+
+// Has reports whether c contains capability.
+func (c Capabilities) Has(capability Capability) bool {
+	for _, cc := range c {
+		if cc == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// Require returns an *UnsupportedError naming feature unless c contains
+// capability.
+func (c Capabilities) Require(capability Capability, feature string) error {
+	if c.Has(capability) {
+		return nil
+	}
+	return &UnsupportedError{Capability: capability, Feature: feature}
+}
+
+// CapabilitiesOf returns the capabilities of a connected datastore.
+func CapabilitiesOf(ds DataStore) Capabilities {
+	if reporter, ok := ds.(CapabilityReporter); ok {
+		return reporter.Capabilities()
+	}
+	return Capabilities{}
+}
+
+// DriverCapabilities returns the capabilities of a registered driver without
+// connecting to it.
+func DriverCapabilities(name string) (Capabilities, error) {
+	driversMu.RLock()
+	driver := drivers[name]
+	driversMu.RUnlock()
+	if driver == nil {
+		return nil, errors.New("datalayer: No such driver available")
+	}
+	return CapabilitiesOf(driver), nil
+}
+
+// UnsupportedError is returned when a request needs a capability the
+// configured driver lacks.
+type UnsupportedError struct {
+	Capability Capability
+	Feature    string
+}
+
+func (e *UnsupportedError) Error() string {
+	return fmt.Sprintf("datalayer: %s is not supported by this datastore (requires %s)", e.Feature, e.Capability)
+}
@@ -0,0 +1,104 @@
+package datalayer
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// Cursor marks the last item of a page, so the next page can resume right
+// after it without skipping rows. It holds the item's values for each field
+// of the listing's Sort, and its `_id`, which breaks ties.
+type Cursor struct {
+	Sort   string        `json:"s,omitempty"`
+	Values []interface{} `json:"v"`
+	ID     string        `json:"id"`
+}
+
+// NewCursor returns the cursor of item in a listing ordered by s.
+func NewCursor(s Sort, item map[string]interface{}) *Cursor {
+	c := &Cursor{Sort: s.String(), Values: make([]interface{}, len(s))}
+	for i, f := range s {
+		c.Values[i], _ = Lookup(item, f.Path)
+	}
+	c.ID, _ = item["_id"].(string)
+	return c
+}
+
+// Encode returns c as an opaque, URL-safe string.
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseCursor decodes the `cursor` query parameter of an item listing. The
+// cursor must come from a listing with the same sort.
+func ParseCursor(value string, s Sort) (*Cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	invalid := &InvalidQueryError{Param: "cursor", Reason: "malformed cursor"}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, invalid
+	}
+	c := &Cursor{}
+	if err = json.Unmarshal(data, c); err != nil || len(c.Values) != len(s) {
+		return nil, invalid
+	}
+	if c.Sort != s.String() {
+		return nil, &InvalidQueryError{Param: "cursor", Reason: "the cursor is for another sort"}
+	}
+	return c, nil
+}
+
+// String returns s in the form ParseSort reads.
+func (s Sort) String() string {
+	fields := make([]string, len(s))
+	for i, f := range s {
+		fields[i] = f.Field()
+		if f.Desc {
+			fields[i] = "-" + fields[i]
+		}
+	}
+	return strings.Join(fields, ",")
+}
+
+// After reports whether item comes after the item c was made from, in the
+// order of s.
+func (s Sort) After(item map[string]interface{}, c *Cursor) bool {
+	for i, f := range s {
+		value, _ := Lookup(item, f.Path)
+		if cmp := sortCompare(value, c.Values[i]); cmp != 0 {
+			return (cmp > 0) != f.Desc
+		}
+	}
+	id, _ := item["_id"].(string)
+	return id > c.ID
+}
+
+// Resume returns the items, sorted by q.Sort, that come after q.Cursor, or
+// all of them if there is no cursor.
+func (q QueryMeta) Resume(items []map[string]interface{}) []map[string]interface{} {
+	if q.Cursor == nil {
+		return items
+	}
+	start := sort.Search(len(items), func(i int) bool {
+		return q.Sort.After(items[i], q.Cursor)
+	})
+	return items[start:]
+}
+
+// ResumeIDs returns the IDs, sorted, that come after q.Cursor in a listing
+// without a sort, or all of them if there is no cursor. Drivers that keep
+// sorted IDs use it to seek to the cursor without reading the items before it.
+func (q QueryMeta) ResumeIDs(ids []string) []string {
+	if q.Cursor == nil {
+		return ids
+	}
+	start := sort.Search(len(ids), func(i int) bool {
+		return ids[i] > q.Cursor.ID
+	})
+	return ids[start:]
+}
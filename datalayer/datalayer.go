@@ -5,6 +5,7 @@ import (
 	"errors"
 	"log"
 	"sync"
+	"time"
 )
 
 var (
@@ -12,6 +13,18 @@ var (
 	drivers   = make(map[string]DataStore)
 )
 
+// ErrNotFound is returned (possibly wrapped) by drivers when a collection or
+// item does not exist.
+var ErrNotFound = errors.New("datalayer: not found")
+
+// ErrVersionMismatch is returned (possibly wrapped) by drivers when a write
+// names a version the stored item is no longer at.
+var ErrVersionMismatch = errors.New("datalayer: version mismatch")
+
+// ErrConflict is returned (possibly wrapped) by drivers when a collection or
+// item with the same name or ID already exists.
+var ErrConflict = errors.New("datalayer: already exists")
+
 // Register makes a database driver available by the provided name.
 // If Register is called twice with the same name or if driver is nil,
 // it panics.
@@ -27,8 +40,12 @@ func Register(name string, driver DataStore) {
 	drivers[name] = driver
 }
 
+// Connect opens a datastore with the named driver. The capabilities of the
+// returned datastore are available through CapabilitiesOf.
 func Connect(name string, dbConfig DBConfig) (DataStore, error) {
+	driversMu.RLock()
 	driver := drivers[name]
+	driversMu.RUnlock()
 	if driver == nil {
 		return nil, errors.New("datalayer: No such driver available")
 	}
@@ -40,29 +57,222 @@ type DBConfig struct {
 	ConnectionString     string `mapstructure:"connection_string"`
 	DatabaseName         string `mapstructure:"database_name"`
 	SchemaCollectionName string `mapstructure:"schema_collection_name"` // where schemas will be stored.
+
+	// Connection pool and timeout settings, for drivers that support them.
+	// Zero values keep the driver's defaults.
+	MaxPoolSize      uint64        `mapstructure:"max_pool_size"`
+	MinPoolSize      uint64        `mapstructure:"min_pool_size"`
+	ConnectTimeout   time.Duration `mapstructure:"connect_timeout"`   // eg 10s
+	OperationTimeout time.Duration `mapstructure:"operation_timeout"` // upper bound for a single operation
+
+	ReadConcern  string `mapstructure:"read_concern"`  // eg local, majority
+	WriteConcern string `mapstructure:"write_concern"` // majority or a number of nodes
 }
 
+// CollectionVM describes a collection. Schema is the latest of its schema
+// versions, numbered by Version.
 type CollectionVM struct {
-	Name   string
-	Schema map[string]interface{}
-	Meta   map[string]interface{}
+	Name    string
+	Schema  map[string]interface{}
+	Meta    map[string]interface{}
+	Version int
 }
 
+// SchemaVersion is one of the schemas a collection has had. CreateCollection
+// stores version 1 and every UpdateCollection with a schema adds the next.
+type SchemaVersion struct {
+	Version int                    `json:"version"`
+	Schema  map[string]interface{} `json:"schema"`
+}
+
+// Revision is an immutable copy of an item, stored each time the item is
+// written. Revisions are numbered by the VersionField the write gave the
+// item, so they follow the order of the writes even when writers race.
+type Revision struct {
+	Revision      int                    `json:"revision"`
+	Item          map[string]interface{} `json:"item"`
+	CreatedAt     time.Time              `json:"createdAt"`
+	Actor         string                 `json:"actor,omitempty"`
+	SchemaVersion int                    `json:"schemaVersion"`
+}
+
+// DataStore is implemented by every database driver. Drivers that hold
+// connections or file handles also implement io.Closer, which ninja calls
+// on shutdown.
 //go:generate mockgen -destination=./mock/mock_datastore.go -package=mock github.com/tonyalaribe/ninja/datalayer DataStore
 type DataStore interface {
 	Connect(dbConfig DBConfig) (datastore DataStore, err error)
 	CreateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error
 	GetCollections(ctx context.Context) (collections []CollectionVM, err error)
+	GetCollection(ctx context.Context, name string) (collection CollectionVM, err error)
+	GetSchemaVersions(ctx context.Context, name string) (versions []SchemaVersion, err error)
+	// UpdateCollection stores schema as the collection's next schema version
+	// and replaces its metadata. A nil schema or metadata keeps the stored one.
+	UpdateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error
+	// RenameCollection moves a collection, with its items and trash, to
+	// newName. It fails if newName is already taken.
+	RenameCollection(ctx context.Context, name, newName string) error
+	// DeleteCollection drops a collection's schema, items and trash.
+	DeleteCollection(ctx context.Context, name string) error
 	GetSchema(ctx context.Context, collectionName string) (map[string]interface{}, error)
+
+	// SaveItem stores a new item at version 1, and fails with ErrConflict if
+	// itemID is taken, by a stored item or a trashed one, whose revisions a
+	// new item must not take over. UpdateItem replaces an item and moves it to the next
+	// version. UpsertItem does whichever applies in one atomic step and
+	// reports whether it created the item, failing like SaveItem if it would
+	// create one. All three set VersionField on item.
+	//
+	// UpdateItem, DeleteItem and TrashItem only go ahead if the stored item
+	// is at ifVersion, and fail with ErrVersionMismatch otherwise. The check
+	// and the write are atomic. An ifVersion of 0 skips the check.
+	// SaveItem, UpdateItem, UpsertItem and RestoreItem fail with ErrConflict
+	// if the item has a SlugField another stored item already has.
 	SaveItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) error
+	UpdateItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}, ifVersion int) error
+	UpsertItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) (created bool, err error)
 	GetItem(ctx context.Context, collectionName, itemID string) (item map[string]interface{}, err error)
+	// GetItemBySlug returns the item whose SlugField is slug.
+	GetItemBySlug(ctx context.Context, collectionName, slug string) (item map[string]interface{}, err error)
 	GetItems(ctx context.Context, collectionName string, queryMeta QueryMeta) (items []map[string]interface{}, respInfo ItemsResponseInfo, err error)
+	// Aggregate summarises the items of a collection, sorted by group key.
+	// Drivers that can't aggregate natively feed their items to an
+	// Aggregator.
+	Aggregate(ctx context.Context, collectionName string, aggregation Aggregation) (groups []Group, err error)
+	DeleteItem(ctx context.Context, collectionName, itemID string, ifVersion int) error
+
+	// TrashItem moves an item into its collection's trash, stamping it with
+	// DeletedAtField. RestoreItem moves it back and PurgeItem deletes it
+	// for good.
+	TrashItem(ctx context.Context, collectionName, itemID string, ifVersion int) error
+	RestoreItem(ctx context.Context, collectionName, itemID string) error
+	PurgeItem(ctx context.Context, collectionName, itemID string) error
+	GetTrashedItems(ctx context.Context, collectionName string, queryMeta QueryMeta) (items []map[string]interface{}, respInfo ItemsResponseInfo, err error)
+
+	// SaveRevision stores revision under its Revision number, and fails with
+	// ErrConflict if the item already has a revision of that number, since
+	// revisions never change once saved. Revisions are kept while the
+	// item is trashed and dropped with DeleteItem, PurgeItem and
+	// DeleteCollection.
+	SaveRevision(ctx context.Context, collectionName, itemID string, revision Revision) error
+	// GetRevisions returns an item's revisions, oldest first.
+	GetRevisions(ctx context.Context, collectionName, itemID string, queryMeta QueryMeta) (revisions []Revision, respInfo ItemsResponseInfo, err error)
+	GetRevision(ctx context.Context, collectionName, itemID string, number int) (revision Revision, err error)
+}
+
+// VersionField holds the version of an item, counting the writes to it.
+const VersionField = "_version"
+
+// VersionOf returns the version of item. Items stored before versions were
+// tracked are at version 1.
+func VersionOf(item map[string]interface{}) int {
+	switch v := item[VersionField].(type) {
+	case int:
+		return v
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 1
 }
 
+// CheckVersion returns ErrVersionMismatch if ifVersion is set and stored is
+// at a different version.
+func CheckVersion(stored map[string]interface{}, ifVersion int) error {
+	if ifVersion != 0 && VersionOf(stored) != ifVersion {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// SlugField holds the URL slug of an item, in collections that have slugs.
+const SlugField = "_slug"
+
+// SlugOf returns the slug of item, or "" if it has none.
+func SlugOf(item map[string]interface{}) string {
+	slug, _ := item[SlugField].(string)
+	return slug
+}
+
+// DeletedAtField holds the RFC 3339 time at which a trashed item was deleted.
+const DeletedAtField = "_deleted_at"
+
+// DeletedAt returns the value drivers store in DeletedAtField.
+func DeletedAt() string {
+	return time.Now().UTC().Format(time.RFC3339)
+}
+
+// QueryMeta describes which slice of a collection GetItems should return.
+// Page is 1-based and Count is the number of items per page. A Count of zero
+// or less returns every item. Only items matching Filter are counted and
+// returned, in the order given by Sort followed by `_id`, which drivers use
+// even if Sort is empty. Drivers return only the Fields of each item, if any
+// are listed.
+//
+// A Cursor replaces Page: the page starts right after the cursor's item.
 type QueryMeta struct {
 	Page        int
 	Count       int
 	QueryString string
+	Filter      Filter
+	Sort        Sort
+	Fields      Projection
+	Cursor      *Cursor
 }
 
-type ItemsResponseInfo struct{}
+// Skip returns how many items precede the requested page.
+func (q QueryMeta) Skip() int {
+	if q.Count <= 0 || q.Page <= 1 || q.Cursor != nil {
+		return 0
+	}
+	return (q.Page - 1) * q.Count
+}
+
+// Window returns the [start, end) bounds of the requested page within a
+// result set of total items.
+func (q QueryMeta) Window(total int) (start, end int) {
+	if q.Count <= 0 {
+		return 0, total
+	}
+	start = q.Skip()
+	if start > total {
+		start = total
+	}
+	end = start + q.Count
+	if end > total {
+		end = total
+	}
+	return start, end
+}
+
+// ItemsResponseInfo describes where a page returned by GetItems sits within
+// the whole collection.
+type ItemsResponseInfo struct {
+	Count        int    `json:"count"`        // items in this page
+	PerPage      int    `json:"perPage"`      // requested page size
+	ItemsSkipped int    `json:"itemsSkipped"` // items before this page
+	PagesCount   int    `json:"pagesCount"`
+	TotalCount   int    `json:"totalCount"`
+	NextCursor   string `json:"nextCursor,omitempty"` // resumes after this page
+}
+
+// ResponseInfo builds the ItemsResponseInfo for a page of count items taken
+// from a result set of total items.
+func (q QueryMeta) ResponseInfo(total, count int) ItemsResponseInfo {
+	info := ItemsResponseInfo{
+		Count:        count,
+		PerPage:      q.Count,
+		ItemsSkipped: q.Skip(),
+		TotalCount:   total,
+	}
+	if q.Count <= 0 {
+		info.PerPage = total
+	}
+	if info.PerPage > 0 {
+		info.PagesCount = (total + info.PerPage - 1) / info.PerPage
+	}
+	return info
+}
@@ -0,0 +1,1699 @@
+// Package datastoretest provides a conformance suite that every
+// datalayer.DataStore driver, including third-party ones, can run to prove it
+// honours the same contract as the drivers shipped with ninja.
+package datastoretest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// Factory returns a new, empty DataStore. It is called once per subtest, so
+// drivers can register cleanup on t.
+type Factory func(t *testing.T) datalayer.DataStore
+
+// RunConformance exercises every DataStore method against stores built by
+// factory. Drivers call it from their own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		datastoretest.RunConformance(t, func(t *testing.T) datalayer.DataStore {
+//			ds, err := NewDatastore(config)
+//			...
+//			return ds
+//		})
+//	}
+func RunConformance(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, ds datalayer.DataStore)
+	}{
+		{"CreateCollection", testCreateCollection},
+		{"DuplicateCollection", testDuplicateCollection},
+		{"GetCollections", testGetCollections},
+		{"GetCollection", testGetCollection},
+		{"UpdateCollection", testUpdateCollection},
+		{"SchemaVersions", testSchemaVersions},
+		{"RenameCollection", testRenameCollection},
+		{"DeleteCollection", testDeleteCollection},
+		{"SaveAndGetItem", testSaveAndGetItem},
+		{"DuplicateItemID", testDuplicateItemID},
+		{"GetItemBySlug", testGetItemBySlug},
+		{"DuplicateSlug", testDuplicateSlug},
+		{"UpdateItem", testUpdateItem},
+		{"UpsertItem", testUpsertItem},
+		{"DeleteItem", testDeleteItem},
+		{"TrashAndRestoreItem", testTrashAndRestoreItem},
+		{"PurgeItem", testPurgeItem},
+		{"ReuseTrashedID", testReuseTrashedID},
+		{"Versions", testVersions},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"ConcurrentUpserts", testConcurrentUpserts},
+		{"Revisions", testRevisions},
+		{"RevisionsFollowCollection", testRevisionsFollowCollection},
+		{"MissingItem", testMissingItem},
+		{"MissingCollection", testMissingCollection},
+		{"GetItems", testGetItems},
+		{"Paging", testPaging},
+		{"Filter", testFilter},
+		{"Sort", testSort},
+		{"Projection", testProjection},
+		{"Cursor", testCursor},
+		{"Aggregate", testAggregate},
+		{"ReturnedItemsAreCopies", testReturnedItemsAreCopies},
+		{"ContextCancellation", testContextCancellation},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+const testCollection = "conformance_posts"
+
+func testSchema() map[string]interface{} {
+	return map[string]interface{}{
+		"title":    "Post",
+		"type":     "object",
+		"required": []interface{}{"title"},
+		"properties": map[string]interface{}{
+			"title": map[string]interface{}{"type": "string"},
+			"views": map[string]interface{}{"type": "number"},
+		},
+	}
+}
+
+func testMeta() map[string]interface{} {
+	return map[string]interface{}{"description": "conformance collection"}
+}
+
+func testItem(title string, views float64) map[string]interface{} {
+	return map[string]interface{}{
+		"title": title,
+		"views": views,
+		"tags":  []interface{}{"a", "b"},
+		"author": map[string]interface{}{
+			"name": "Ninja",
+		},
+	}
+}
+
+// normalize round-trips v through JSON so values produced by different
+// drivers (eg bson.M vs map[string]interface{}) compare equal.
+func normalize(t *testing.T, v interface{}) interface{} {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatalf("unable to marshal %v: %v", v, err)
+	}
+	var out interface{}
+	if err = json.Unmarshal(data, &out); err != nil {
+		t.Fatalf("unable to unmarshal %s: %v", data, err)
+	}
+	return out
+}
+
+func assertJSONEqual(t *testing.T, got, want interface{}) {
+	t.Helper()
+	if g, w := normalize(t, got), normalize(t, want); !reflect.DeepEqual(g, w) {
+		t.Errorf("got %v, want %v", g, w)
+	}
+}
+
+func assertConflict(t *testing.T, err error) {
+	t.Helper()
+	if errors.Cause(err) != datalayer.ErrConflict {
+		t.Errorf("got error %v, want datalayer.ErrConflict", err)
+	}
+}
+
+func assertNotFound(t *testing.T, err error) {
+	t.Helper()
+	if errors.Cause(err) != datalayer.ErrNotFound {
+		t.Errorf("got error %v, want datalayer.ErrNotFound", err)
+	}
+}
+
+func mustCreateCollection(t *testing.T, ds datalayer.DataStore, name string) {
+	t.Helper()
+	if err := ds.CreateCollection(context.Background(), name, testSchema(), testMeta()); err != nil {
+		t.Fatalf("CreateCollection(%q) failed: %v", name, err)
+	}
+}
+
+func mustSaveItem(t *testing.T, ds datalayer.DataStore, collectionName, itemID string, item map[string]interface{}) {
+	t.Helper()
+	if err := ds.SaveItem(context.Background(), collectionName, itemID, item); err != nil {
+		t.Fatalf("SaveItem(%q, %q) failed: %v", collectionName, itemID, err)
+	}
+}
+
+func testCreateCollection(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+
+	schema, err := ds.GetSchema(context.Background(), testCollection)
+	if err != nil {
+		t.Fatalf("GetSchema failed: %v", err)
+	}
+	assertJSONEqual(t, schema, testSchema())
+}
+
+func testDuplicateCollection(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+
+	err := ds.CreateCollection(context.Background(), testCollection, testSchema(), testMeta())
+	assertConflict(t, err)
+}
+
+func testGetCollections(t *testing.T, ds datalayer.DataStore) {
+	collections, err := ds.GetCollections(context.Background())
+	if err != nil {
+		t.Fatalf("GetCollections failed: %v", err)
+	}
+	if len(collections) != 0 {
+		t.Fatalf("got %d collections from an empty store, want 0", len(collections))
+	}
+
+	names := []string{testCollection, testCollection + "_2"}
+	for _, name := range names {
+		mustCreateCollection(t, ds, name)
+	}
+
+	collections, err = ds.GetCollections(context.Background())
+	if err != nil {
+		t.Fatalf("GetCollections failed: %v", err)
+	}
+	if len(collections) != len(names) {
+		t.Fatalf("got %d collections, want %d", len(collections), len(names))
+	}
+	for _, collection := range collections {
+		if collection.Name != names[0] && collection.Name != names[1] {
+			t.Errorf("got unexpected collection %q", collection.Name)
+		}
+		assertJSONEqual(t, collection.Schema, testSchema())
+		assertJSONEqual(t, collection.Meta, testMeta())
+	}
+}
+
+func getCollection(t *testing.T, ds datalayer.DataStore, name string) (datalayer.CollectionVM, bool) {
+	t.Helper()
+	collections, err := ds.GetCollections(context.Background())
+	if err != nil {
+		t.Fatalf("GetCollections failed: %v", err)
+	}
+	for _, collection := range collections {
+		if collection.Name == name {
+			return collection, true
+		}
+	}
+	return datalayer.CollectionVM{}, false
+}
+
+func testGetCollection(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+
+	collection, err := ds.GetCollection(context.Background(), testCollection)
+	if err != nil {
+		t.Fatalf("GetCollection failed: %v", err)
+	}
+	if collection.Name != testCollection || collection.Version != 1 {
+		t.Errorf("got collection %q at version %d, want %q at version 1", collection.Name, collection.Version, testCollection)
+	}
+	assertJSONEqual(t, collection.Schema, testSchema())
+	assertJSONEqual(t, collection.Meta, testMeta())
+}
+
+func testSchemaVersions(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+
+	schema := testSchema()
+	schema["title"] = "Article"
+	if err := ds.UpdateCollection(ctx, testCollection, schema, nil); err != nil {
+		t.Fatalf("UpdateCollection failed: %v", err)
+	}
+	// Metadata changes don't add a schema version.
+	if err := ds.UpdateCollection(ctx, testCollection, nil, map[string]interface{}{"description": "updated"}); err != nil {
+		t.Fatalf("UpdateCollection failed: %v", err)
+	}
+	newName := testCollection + "_renamed"
+	if err := ds.RenameCollection(ctx, testCollection, newName); err != nil {
+		t.Fatalf("RenameCollection failed: %v", err)
+	}
+
+	collection, err := ds.GetCollection(ctx, newName)
+	if err != nil {
+		t.Fatalf("GetCollection failed: %v", err)
+	}
+	if collection.Version != 2 {
+		t.Errorf("got version %d, want 2", collection.Version)
+	}
+	assertJSONEqual(t, collection.Schema, schema)
+
+	versions, err := ds.GetSchemaVersions(ctx, newName)
+	if err != nil {
+		t.Fatalf("GetSchemaVersions failed: %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("got %d schema versions, want 2", len(versions))
+	}
+	for i, want := range []map[string]interface{}{testSchema(), schema} {
+		if versions[i].Version != i+1 {
+			t.Errorf("versions[%d] is numbered %d, want %d", i, versions[i].Version, i+1)
+		}
+		assertJSONEqual(t, versions[i].Schema, want)
+	}
+}
+
+func testUpdateCollection(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+
+	schema := testSchema()
+	schema["title"] = "Article"
+	if err := ds.UpdateCollection(ctx, testCollection, schema, nil); err != nil {
+		t.Fatalf("UpdateCollection(schema) failed: %v", err)
+	}
+	collection, _ := getCollection(t, ds, testCollection)
+	assertJSONEqual(t, collection.Schema, schema)
+	assertJSONEqual(t, collection.Meta, testMeta())
+
+	meta := map[string]interface{}{"description": "updated"}
+	if err := ds.UpdateCollection(ctx, testCollection, nil, meta); err != nil {
+		t.Fatalf("UpdateCollection(metadata) failed: %v", err)
+	}
+	collection, _ = getCollection(t, ds, testCollection)
+	assertJSONEqual(t, collection.Schema, schema)
+	assertJSONEqual(t, collection.Meta, meta)
+
+	got, err := ds.GetSchema(ctx, testCollection)
+	if err != nil {
+		t.Fatalf("GetSchema failed: %v", err)
+	}
+	assertJSONEqual(t, got, schema)
+}
+
+func testRenameCollection(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	newName := testCollection + "_renamed"
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+	mustSaveItem(t, ds, testCollection, "item-2", testItem("Second", 2))
+	if err := ds.TrashItem(ctx, testCollection, "item-2", 0); err != nil {
+		t.Fatalf("TrashItem failed: %v", err)
+	}
+
+	if err := ds.RenameCollection(ctx, testCollection, newName); err != nil {
+		t.Fatalf("RenameCollection failed: %v", err)
+	}
+	if _, ok := getCollection(t, ds, testCollection); ok {
+		t.Errorf("collection is still listed under its old name")
+	}
+	collection, ok := getCollection(t, ds, newName)
+	if !ok {
+		t.Fatalf("collection is not listed under its new name")
+	}
+	assertJSONEqual(t, collection.Schema, testSchema())
+	assertJSONEqual(t, collection.Meta, testMeta())
+
+	_, err := ds.GetSchema(ctx, testCollection)
+	assertNotFound(t, err)
+	if _, err = ds.GetItem(ctx, newName, "item-1"); err != nil {
+		t.Errorf("GetItem after rename failed: %v", err)
+	}
+	trashed, _, err := ds.GetTrashedItems(ctx, newName, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetTrashedItems after rename failed: %v", err)
+	}
+	if len(trashed) != 1 {
+		t.Errorf("got %d trashed items after rename, want 1", len(trashed))
+	}
+
+	// Names can be reused once freed, and renaming onto a taken name fails.
+	mustCreateCollection(t, ds, testCollection)
+	items, _, err := ds.GetItems(ctx, testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if len(items) != 0 {
+		t.Errorf("got %d items in a recreated collection, want 0", len(items))
+	}
+	err = ds.RenameCollection(ctx, testCollection, newName)
+	assertConflict(t, err)
+	if _, err = ds.GetItem(ctx, newName, "item-1"); err != nil {
+		t.Errorf("failed rename clobbered the existing collection: %v", err)
+	}
+}
+
+func testDeleteCollection(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+	mustSaveItem(t, ds, testCollection, "item-2", testItem("Second", 2))
+	if err := ds.TrashItem(ctx, testCollection, "item-2", 0); err != nil {
+		t.Fatalf("TrashItem failed: %v", err)
+	}
+
+	if err := ds.DeleteCollection(ctx, testCollection); err != nil {
+		t.Fatalf("DeleteCollection failed: %v", err)
+	}
+	if _, ok := getCollection(t, ds, testCollection); ok {
+		t.Errorf("deleted collection is still listed")
+	}
+	_, err := ds.GetSchema(ctx, testCollection)
+	assertNotFound(t, err)
+	_, err = ds.GetItem(ctx, testCollection, "item-1")
+	assertNotFound(t, err)
+
+	// A collection created under the same name starts out empty.
+	mustCreateCollection(t, ds, testCollection)
+	items, _, err := ds.GetItems(ctx, testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	trashed, _, err := ds.GetTrashedItems(ctx, testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetTrashedItems failed: %v", err)
+	}
+	if len(items) != 0 || len(trashed) != 0 {
+		t.Errorf("recreated collection has %d items and %d trashed items, want none", len(items), len(trashed))
+	}
+}
+
+func testSaveAndGetItem(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("Hello", 10))
+
+	item, err := ds.GetItem(context.Background(), testCollection, "item-1")
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if item["_id"] != "item-1" {
+		t.Errorf("got _id %v, want %q", item["_id"], "item-1")
+	}
+	want := testItem("Hello", 10)
+	want["_id"] = "item-1"
+	want[datalayer.VersionField] = 1
+	assertJSONEqual(t, item, want)
+}
+
+func testDuplicateItemID(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+
+	err := ds.SaveItem(context.Background(), testCollection, "item-1", testItem("Second", 2))
+	assertConflict(t, err)
+
+	item, err := ds.GetItem(context.Background(), testCollection, "item-1")
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if item["title"] != "First" {
+		t.Errorf("duplicate save overwrote the item: got title %v, want %q", item["title"], "First")
+	}
+}
+
+func testDuplicateSlug(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	withSlug := func(title, slug string) map[string]interface{} {
+		item := testItem(title, 1)
+		item[datalayer.SlugField] = slug
+		return item
+	}
+	mustSaveItem(t, ds, testCollection, "item-1", withSlug("First", "first"))
+	mustSaveItem(t, ds, testCollection, "item-2", withSlug("Second", "second"))
+	mustSaveItem(t, ds, testCollection, "item-3", testItem("No slug", 3))
+	mustSaveItem(t, ds, testCollection, "item-4", testItem("No slug either", 4))
+
+	err := ds.SaveItem(ctx, testCollection, "item-5", withSlug("Fifth", "first"))
+	assertConflict(t, err)
+	err = ds.UpdateItem(ctx, testCollection, "item-2", withSlug("Second", "first"), 0)
+	assertConflict(t, err)
+	_, err = ds.UpsertItem(ctx, testCollection, "item-5", withSlug("Fifth", "first"))
+	assertConflict(t, err)
+	_, err = ds.UpsertItem(ctx, testCollection, "item-2", withSlug("Second", "first"))
+	assertConflict(t, err)
+
+	// An item keeps its own slug, and a trashed item's slug is only taken
+	// back if it is still free on restore.
+	if err = ds.UpdateItem(ctx, testCollection, "item-1", withSlug("First again", "first"), 0); err != nil {
+		t.Errorf("UpdateItem keeping the slug failed: %v", err)
+	}
+	if err = ds.TrashItem(ctx, testCollection, "item-2", 0); err != nil {
+		t.Fatalf("TrashItem failed: %v", err)
+	}
+	mustSaveItem(t, ds, testCollection, "item-6", withSlug("Sixth", "second"))
+	assertConflict(t, ds.RestoreItem(ctx, testCollection, "item-2"))
+
+	item, err := ds.GetItemBySlug(ctx, testCollection, "first")
+	if err != nil {
+		t.Fatalf("GetItemBySlug failed: %v", err)
+	}
+	if item["_id"] != "item-1" {
+		t.Errorf("got item %v for slug %q, want item-1", item["_id"], "first")
+	}
+
+	// Deleting an item frees its slug.
+	if err = ds.DeleteItem(ctx, testCollection, "item-6", 0); err != nil {
+		t.Fatalf("DeleteItem failed: %v", err)
+	}
+	if err = ds.RestoreItem(ctx, testCollection, "item-2"); err != nil {
+		t.Fatalf("RestoreItem after the slug was freed failed: %v", err)
+	}
+	if item, err = ds.GetItemBySlug(ctx, testCollection, "second"); err != nil || item["_id"] != "item-2" {
+		t.Errorf("got item %v with error %v for slug %q, want item-2", item["_id"], err, "second")
+	}
+}
+
+func testGetItemBySlug(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-0", testItem("No slug", 0))
+	for i, slug := range []string{"hello-world", "hello-world-2"} {
+		item := testItem("Hello World", float64(i))
+		item[datalayer.SlugField] = slug
+		mustSaveItem(t, ds, testCollection, fmt.Sprint("item-", i+1), item)
+	}
+
+	item, err := ds.GetItemBySlug(ctx, testCollection, "hello-world-2")
+	if err != nil {
+		t.Fatalf("GetItemBySlug failed: %v", err)
+	}
+	if item["_id"] != "item-2" {
+		t.Errorf("got item %v for slug %q, want item-2", item["_id"], "hello-world-2")
+	}
+
+	// Slugs follow updates.
+	replacement := testItem("Renamed", 1)
+	replacement[datalayer.SlugField] = "renamed"
+	if err = ds.UpdateItem(ctx, testCollection, "item-1", replacement, 0); err != nil {
+		t.Fatalf("UpdateItem failed: %v", err)
+	}
+	if item, err = ds.GetItemBySlug(ctx, testCollection, "renamed"); err != nil {
+		t.Errorf("GetItemBySlug after an update failed: %v", err)
+	} else if item["_id"] != "item-1" {
+		t.Errorf("got item %v for slug %q, want item-1", item["_id"], "renamed")
+	}
+	_, err = ds.GetItemBySlug(ctx, testCollection, "hello-world")
+	assertNotFound(t, err)
+
+	_, err = ds.GetItemBySlug(ctx, testCollection, "")
+	assertNotFound(t, err)
+	_, err = ds.GetItemBySlug(ctx, "missing", "hello-world-2")
+	assertNotFound(t, err)
+}
+
+func testUpdateItem(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+	mustSaveItem(t, ds, testCollection, "item-2", testItem("Second", 2))
+
+	replacement := map[string]interface{}{"title": "Replaced"}
+	if err := ds.UpdateItem(context.Background(), testCollection, "item-1", replacement, 0); err != nil {
+		t.Fatalf("UpdateItem failed: %v", err)
+	}
+
+	item, err := ds.GetItem(context.Background(), testCollection, "item-1")
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	assertJSONEqual(t, item, map[string]interface{}{"_id": "item-1", "title": "Replaced", datalayer.VersionField: 2})
+
+	items, _, err := ds.GetItems(context.Background(), testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if len(items) != 2 {
+		t.Errorf("got %d items after an update, want 2", len(items))
+	}
+
+	err = ds.UpdateItem(context.Background(), testCollection, "missing", replacement, 0)
+	assertNotFound(t, err)
+}
+
+func testUpsertItem(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+
+	item := testItem("First", 1)
+	created, err := ds.UpsertItem(ctx, testCollection, "item-1", item)
+	if err != nil {
+		t.Fatalf("UpsertItem of a new item failed: %v", err)
+	}
+	if !created {
+		t.Errorf("UpsertItem of a new item reported a replace")
+	}
+	want := testItem("First", 1)
+	want["_id"] = "item-1"
+	want[datalayer.VersionField] = 1
+	assertJSONEqual(t, item, want)
+
+	created, err = ds.UpsertItem(ctx, testCollection, "item-1", map[string]interface{}{"title": "Replaced"})
+	if err != nil {
+		t.Fatalf("UpsertItem of an existing item failed: %v", err)
+	}
+	if created {
+		t.Errorf("UpsertItem of an existing item reported a create")
+	}
+	got, err := ds.GetItem(ctx, testCollection, "item-1")
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	assertJSONEqual(t, got, map[string]interface{}{"_id": "item-1", "title": "Replaced", datalayer.VersionField: 2})
+
+	items, respInfo, err := ds.GetItems(ctx, testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if len(items) != 1 || respInfo.TotalCount != 1 {
+		t.Errorf("got %d items (total %d) after two upserts of one ID, want 1", len(items), respInfo.TotalCount)
+	}
+
+	_, err = ds.UpsertItem(ctx, "missing", "item-1", testItem("Lost", 0))
+	assertNotFound(t, err)
+}
+
+func testDeleteItem(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+	mustSaveItem(t, ds, testCollection, "item-2", testItem("Second", 2))
+
+	if err := ds.DeleteItem(context.Background(), testCollection, "item-1", 0); err != nil {
+		t.Fatalf("DeleteItem failed: %v", err)
+	}
+	_, err := ds.GetItem(context.Background(), testCollection, "item-1")
+	assertNotFound(t, err)
+
+	items, respInfo, err := ds.GetItems(context.Background(), testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if len(items) != 1 || respInfo.TotalCount != 1 {
+		t.Errorf("got %d items (total %d) after a delete, want 1", len(items), respInfo.TotalCount)
+	}
+
+	trashed, _, err := ds.GetTrashedItems(context.Background(), testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetTrashedItems failed: %v", err)
+	}
+	if len(trashed) != 0 {
+		t.Errorf("DeleteItem moved the item to the trash, want it gone for good")
+	}
+
+	err = ds.DeleteItem(context.Background(), testCollection, "item-1", 0)
+	assertNotFound(t, err)
+}
+
+func testTrashAndRestoreItem(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+	mustSaveItem(t, ds, testCollection, "item-2", testItem("Second", 2))
+
+	if err := ds.TrashItem(ctx, testCollection, "item-1", 0); err != nil {
+		t.Fatalf("TrashItem failed: %v", err)
+	}
+	_, err := ds.GetItem(ctx, testCollection, "item-1")
+	assertNotFound(t, err)
+
+	items, _, err := ds.GetItems(ctx, testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if len(items) != 1 {
+		t.Errorf("got %d items after trashing one, want 1", len(items))
+	}
+
+	trashed, respInfo, err := ds.GetTrashedItems(ctx, testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetTrashedItems failed: %v", err)
+	}
+	if len(trashed) != 1 || respInfo.TotalCount != 1 {
+		t.Fatalf("got %d trashed items (total %d), want 1", len(trashed), respInfo.TotalCount)
+	}
+	if trashed[0]["_id"] != "item-1" || trashed[0]["title"] != "First" {
+		t.Errorf("got trashed item %v, want item-1", trashed[0])
+	}
+	if deletedAt, _ := trashed[0][datalayer.DeletedAtField].(string); deletedAt == "" {
+		t.Errorf("trashed item has no %s", datalayer.DeletedAtField)
+	}
+
+	if err = ds.RestoreItem(ctx, testCollection, "item-1"); err != nil {
+		t.Fatalf("RestoreItem failed: %v", err)
+	}
+	item, err := ds.GetItem(ctx, testCollection, "item-1")
+	if err != nil {
+		t.Fatalf("GetItem after restore failed: %v", err)
+	}
+	want := testItem("First", 1)
+	want["_id"] = "item-1"
+	want[datalayer.VersionField] = 1
+	assertJSONEqual(t, item, want)
+
+	trashed, _, err = ds.GetTrashedItems(ctx, testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetTrashedItems failed: %v", err)
+	}
+	if len(trashed) != 0 {
+		t.Errorf("got %d trashed items after a restore, want 0", len(trashed))
+	}
+
+	err = ds.RestoreItem(ctx, testCollection, "item-1")
+	assertNotFound(t, err)
+	err = ds.TrashItem(ctx, testCollection, "missing", 0)
+	assertNotFound(t, err)
+
+	// The ID of a trashed item stays taken until it is restored or purged.
+	if err = ds.TrashItem(ctx, testCollection, "item-2", 0); err != nil {
+		t.Fatalf("TrashItem failed: %v", err)
+	}
+	err = ds.SaveItem(ctx, testCollection, "item-2", testItem("Replacement", 3))
+	assertConflict(t, err)
+	created, err := ds.UpsertItem(ctx, testCollection, "item-2", testItem("Replacement", 3))
+	assertConflict(t, err)
+	if created {
+		t.Errorf("UpsertItem reported creating an item under a trashed ID")
+	}
+	if err = ds.RestoreItem(ctx, testCollection, "item-2"); err != nil {
+		t.Fatalf("RestoreItem failed: %v", err)
+	}
+}
+
+// testReuseTrashedID checks that a new item can't take over the history of a
+// trashed item with the same ID, and that purging the trashed item leaves the
+// ID free for one with a history of its own.
+func testReuseTrashedID(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+	mustSaveRevision(t, ds, testCollection, "item-1", testRevision(1))
+	mustSaveRevision(t, ds, testCollection, "item-1", testRevision(2))
+	if err := ds.TrashItem(ctx, testCollection, "item-1", 0); err != nil {
+		t.Fatalf("TrashItem failed: %v", err)
+	}
+
+	err := ds.SaveItem(ctx, testCollection, "item-1", testItem("Second", 2))
+	assertConflict(t, err)
+	_, err = ds.UpsertItem(ctx, testCollection, "item-1", testItem("Second", 2))
+	assertConflict(t, err)
+	err = ds.SaveRevision(ctx, testCollection, "item-1", testRevision(1))
+	assertConflict(t, err)
+	revision, err := ds.GetRevision(ctx, testCollection, "item-1", 1)
+	if err != nil {
+		t.Fatalf("GetRevision failed: %v", err)
+	}
+	if revision.Item["title"] != "Revised" {
+		t.Errorf("got revision %v, want the trashed item's revision kept", revision.Item)
+	}
+
+	if err = ds.PurgeItem(ctx, testCollection, "item-1"); err != nil {
+		t.Fatalf("PurgeItem failed: %v", err)
+	}
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("Second", 2))
+	_, err = ds.GetRevision(ctx, testCollection, "item-1", 2)
+	assertNotFound(t, err)
+	mustSaveRevision(t, ds, testCollection, "item-1", testRevision(1))
+	revisions, _, err := ds.GetRevisions(ctx, testCollection, "item-1", datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetRevisions failed: %v", err)
+	}
+	if len(revisions) != 1 || revisions[0].Revision != 1 {
+		t.Errorf("got revisions %v, want only the new item's revision 1", revisions)
+	}
+}
+
+func testPurgeItem(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+
+	err := ds.PurgeItem(ctx, testCollection, "item-1")
+	assertNotFound(t, err)
+
+	if err = ds.TrashItem(ctx, testCollection, "item-1", 0); err != nil {
+		t.Fatalf("TrashItem failed: %v", err)
+	}
+	if err = ds.PurgeItem(ctx, testCollection, "item-1"); err != nil {
+		t.Fatalf("PurgeItem failed: %v", err)
+	}
+
+	trashed, _, err := ds.GetTrashedItems(ctx, testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetTrashedItems failed: %v", err)
+	}
+	if len(trashed) != 0 {
+		t.Errorf("got %d trashed items after a purge, want 0", len(trashed))
+	}
+	err = ds.RestoreItem(ctx, testCollection, "item-1")
+	assertNotFound(t, err)
+}
+
+func assertVersion(t *testing.T, ds datalayer.DataStore, itemID string, want int) {
+	t.Helper()
+	item, err := ds.GetItem(context.Background(), testCollection, itemID)
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if got := datalayer.VersionOf(item); got != want {
+		t.Errorf("got version %d for %s, want %d", got, itemID, want)
+	}
+}
+
+func assertVersionMismatch(t *testing.T, err error) {
+	t.Helper()
+	if errors.Cause(err) != datalayer.ErrVersionMismatch {
+		t.Errorf("got error %v, want ErrVersionMismatch", err)
+	}
+}
+
+func testVersions(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	item := testItem("First", 1)
+	mustSaveItem(t, ds, testCollection, "item-1", item)
+	if got := datalayer.VersionOf(item); got != 1 {
+		t.Errorf("SaveItem set version %d on the item, want 1", got)
+	}
+	assertVersion(t, ds, "item-1", 1)
+
+	update := testItem("Second", 2)
+	if err := ds.UpdateItem(ctx, testCollection, "item-1", update, 1); err != nil {
+		t.Fatalf("UpdateItem at the current version failed: %v", err)
+	}
+	if got := datalayer.VersionOf(update); got != 2 {
+		t.Errorf("UpdateItem set version %d on the item, want 2", got)
+	}
+	if err := ds.UpdateItem(ctx, testCollection, "item-1", testItem("Third", 3), 0); err != nil {
+		t.Fatalf("unconditional UpdateItem failed: %v", err)
+	}
+	assertVersion(t, ds, "item-1", 3)
+
+	err := ds.UpdateItem(ctx, testCollection, "item-1", testItem("Stale", 4), 2)
+	assertVersionMismatch(t, err)
+	err = ds.DeleteItem(ctx, testCollection, "item-1", 2)
+	assertVersionMismatch(t, err)
+	err = ds.TrashItem(ctx, testCollection, "item-1", 2)
+	assertVersionMismatch(t, err)
+
+	got, err := ds.GetItem(ctx, testCollection, "item-1")
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if got["title"] != "Third" {
+		t.Errorf("a stale write changed the item: got title %v, want %q", got["title"], "Third")
+	}
+	trashed, _, err := ds.GetTrashedItems(ctx, testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetTrashedItems failed: %v", err)
+	}
+	if len(trashed) != 0 {
+		t.Errorf("a stale trash left %d items in the trash, want none", len(trashed))
+	}
+
+	if err = ds.TrashItem(ctx, testCollection, "item-1", 3); err != nil {
+		t.Fatalf("TrashItem at the current version failed: %v", err)
+	}
+	if err = ds.RestoreItem(ctx, testCollection, "item-1"); err != nil {
+		t.Fatalf("RestoreItem failed: %v", err)
+	}
+	assertVersion(t, ds, "item-1", 3)
+	if err = ds.DeleteItem(ctx, testCollection, "item-1", 3); err != nil {
+		t.Fatalf("DeleteItem at the current version failed: %v", err)
+	}
+
+	err = ds.UpdateItem(ctx, testCollection, "item-1", testItem("Gone", 5), 3)
+	assertNotFound(t, err)
+	err = ds.DeleteItem(ctx, testCollection, "item-1", 3)
+	assertNotFound(t, err)
+	err = ds.TrashItem(ctx, testCollection, "item-1", 3)
+	assertNotFound(t, err)
+}
+
+func testConcurrentUpdates(t *testing.T, ds datalayer.DataStore) {
+	const writers = 8
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+
+	// Unconditional updates must all land, each on its own version.
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- ds.UpdateItem(ctx, testCollection, "item-1", testItem("Unconditional", float64(i)), 0)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("unconditional UpdateItem failed: %v", err)
+		}
+	}
+	assertVersion(t, ds, "item-1", writers+1)
+
+	// Of the updates made from the same version, only one may win.
+	errs = make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- ds.UpdateItem(ctx, testCollection, "item-1", testItem("Conditional", float64(i)), writers+1)
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	won := 0
+	for err := range errs {
+		switch errors.Cause(err) {
+		case nil:
+			won++
+		case datalayer.ErrVersionMismatch:
+		default:
+			t.Errorf("conditional UpdateItem failed: %v", err)
+		}
+	}
+	if won != 1 {
+		t.Errorf("%d conditional updates from the same version succeeded, want 1", won)
+	}
+	assertVersion(t, ds, "item-1", writers+2)
+}
+
+func testConcurrentUpserts(t *testing.T, ds datalayer.DataStore) {
+	const writers = 8
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+
+	// Exactly one upsert creates the item and the others replace it in turn.
+	var wg sync.WaitGroup
+	created := make(chan bool, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := ds.UpsertItem(ctx, testCollection, "item-1", testItem("Upserted", float64(i)))
+			if err != nil {
+				t.Errorf("UpsertItem failed: %v", err)
+			}
+			created <- ok
+		}(i)
+	}
+	wg.Wait()
+	close(created)
+	creates := 0
+	for ok := range created {
+		if ok {
+			creates++
+		}
+	}
+	if creates != 1 {
+		t.Errorf("%d concurrent upserts of a new item created it, want 1", creates)
+	}
+	assertVersion(t, ds, "item-1", writers)
+}
+
+// testRevision returns a revision numbered number.
+func testRevision(number int) datalayer.Revision {
+	return datalayer.Revision{
+		Revision:      number,
+		Item:          testItem("Revised", float64(number)),
+		CreatedAt:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		Actor:         "editor",
+		SchemaVersion: 1,
+	}
+}
+
+func mustSaveRevision(t *testing.T, ds datalayer.DataStore, collectionName, itemID string, revision datalayer.Revision) {
+	t.Helper()
+	if err := ds.SaveRevision(context.Background(), collectionName, itemID, revision); err != nil {
+		t.Fatalf("SaveRevision(%q, %q) failed: %v", collectionName, itemID, err)
+	}
+}
+
+func testRevisions(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+
+	revisions, respInfo, err := ds.GetRevisions(ctx, testCollection, "item-1", datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetRevisions failed: %v", err)
+	}
+	if len(revisions) != 0 || respInfo.TotalCount != 0 {
+		t.Errorf("got %d revisions before any were saved, want 0", len(revisions))
+	}
+
+	// Racing writers may save revisions out of order.
+	for _, number := range []int{1, 3, 2} {
+		mustSaveRevision(t, ds, testCollection, "item-1", testRevision(number))
+	}
+
+	revision, err := ds.GetRevision(ctx, testCollection, "item-1", 2)
+	if err != nil {
+		t.Fatalf("GetRevision failed: %v", err)
+	}
+	want := testRevision(2)
+	if !revision.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("got CreatedAt %v, want %v", revision.CreatedAt, want.CreatedAt)
+	}
+	revision.CreatedAt = want.CreatedAt
+	assertJSONEqual(t, revision, want)
+
+	revisions, respInfo, err = ds.GetRevisions(ctx, testCollection, "item-1", datalayer.QueryMeta{Page: 2, Count: 2})
+	if err != nil {
+		t.Fatalf("GetRevisions failed: %v", err)
+	}
+	if len(revisions) != 1 || revisions[0].Revision != 3 {
+		t.Errorf("got %v on the second page, want revision 3", revisions)
+	}
+	if respInfo.TotalCount != 3 || respInfo.PagesCount != 2 {
+		t.Errorf("got %+v, want 3 revisions over 2 pages", respInfo)
+	}
+
+	_, err = ds.GetRevision(ctx, testCollection, "item-1", 4)
+	assertNotFound(t, err)
+
+	// Revisions never change, so a taken number can't be saved again.
+	replacement := testRevision(3)
+	replacement.Actor = "reviewer"
+	err = ds.SaveRevision(ctx, testCollection, "item-1", replacement)
+	assertConflict(t, err)
+	if revision, err = ds.GetRevision(ctx, testCollection, "item-1", 3); err != nil {
+		t.Fatalf("GetRevision failed: %v", err)
+	}
+	if revision.Actor != "editor" {
+		t.Errorf("got actor %q for a revision saved twice, want the first %q", revision.Actor, "editor")
+	}
+	if _, respInfo, _ = ds.GetRevisions(ctx, testCollection, "item-1", datalayer.QueryMeta{}); respInfo.TotalCount != 3 {
+		t.Errorf("got %d revisions after saving one twice, want 3", respInfo.TotalCount)
+	}
+	_, err = ds.GetRevision(ctx, testCollection, "item-2", 1)
+	assertNotFound(t, err)
+
+	// Revisions survive the trash but not a purge.
+	if err = ds.TrashItem(ctx, testCollection, "item-1", 0); err != nil {
+		t.Fatalf("TrashItem failed: %v", err)
+	}
+	if _, err = ds.GetRevision(ctx, testCollection, "item-1", 1); err != nil {
+		t.Errorf("GetRevision of a trashed item failed: %v", err)
+	}
+	if err = ds.PurgeItem(ctx, testCollection, "item-1"); err != nil {
+		t.Fatalf("PurgeItem failed: %v", err)
+	}
+	_, err = ds.GetRevision(ctx, testCollection, "item-1", 1)
+	assertNotFound(t, err)
+
+	// A deleted item's history goes with it.
+	mustSaveItem(t, ds, testCollection, "item-2", testItem("Second", 2))
+	mustSaveRevision(t, ds, testCollection, "item-2", testRevision(1))
+	mustSaveRevision(t, ds, testCollection, "item-2", testRevision(2))
+	if err = ds.DeleteItem(ctx, testCollection, "item-2", 0); err != nil {
+		t.Fatalf("DeleteItem failed: %v", err)
+	}
+	_, err = ds.GetRevision(ctx, testCollection, "item-2", 2)
+	assertNotFound(t, err)
+}
+
+func testRevisionsFollowCollection(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	newName := testCollection + "_renamed"
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("First", 1))
+	mustSaveRevision(t, ds, testCollection, "item-1", testRevision(1))
+	mustSaveRevision(t, ds, testCollection, "item-1", testRevision(2))
+
+	if err := ds.RenameCollection(ctx, testCollection, newName); err != nil {
+		t.Fatalf("RenameCollection failed: %v", err)
+	}
+	revisions, _, err := ds.GetRevisions(ctx, newName, "item-1", datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetRevisions after rename failed: %v", err)
+	}
+	if len(revisions) != 2 {
+		t.Errorf("got %d revisions after rename, want 2", len(revisions))
+	}
+	mustSaveRevision(t, ds, newName, "item-1", testRevision(3))
+	if _, err = ds.GetRevision(ctx, newName, "item-1", 3); err != nil {
+		t.Errorf("GetRevision after rename failed: %v", err)
+	}
+
+	if err = ds.DeleteCollection(ctx, newName); err != nil {
+		t.Fatalf("DeleteCollection failed: %v", err)
+	}
+	mustCreateCollection(t, ds, newName)
+	revisions, _, err = ds.GetRevisions(ctx, newName, "item-1", datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetRevisions failed: %v", err)
+	}
+	if len(revisions) != 0 {
+		t.Errorf("recreated collection has %d revisions, want 0", len(revisions))
+	}
+}
+
+func testMissingItem(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+
+	_, err := ds.GetItem(context.Background(), testCollection, "missing")
+	assertNotFound(t, err)
+}
+
+func testMissingCollection(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+
+	_, err := ds.GetSchema(ctx, "missing")
+	assertNotFound(t, err)
+
+	_, err = ds.GetCollection(ctx, "missing")
+	assertNotFound(t, err)
+
+	_, err = ds.GetSchemaVersions(ctx, "missing")
+	assertNotFound(t, err)
+
+	err = ds.UpdateCollection(ctx, "missing", testSchema(), testMeta())
+	assertNotFound(t, err)
+
+	err = ds.RenameCollection(ctx, "missing", "missing_renamed")
+	assertNotFound(t, err)
+
+	err = ds.DeleteCollection(ctx, "missing")
+	assertNotFound(t, err)
+
+	_, err = ds.GetItem(ctx, "missing", "item-1")
+	assertNotFound(t, err)
+
+	_, _, err = ds.GetItems(ctx, "missing", datalayer.QueryMeta{})
+	assertNotFound(t, err)
+
+	_, err = ds.Aggregate(ctx, "missing", datalayer.Aggregation{Metrics: []datalayer.Metric{{Op: datalayer.Count}}})
+	assertNotFound(t, err)
+
+	err = ds.SaveItem(ctx, "missing", "item-1", testItem("Lost", 0))
+	assertNotFound(t, err)
+
+	err = ds.UpdateItem(ctx, "missing", "item-1", testItem("Lost", 0), 0)
+	assertNotFound(t, err)
+
+	err = ds.DeleteItem(ctx, "missing", "item-1", 0)
+	assertNotFound(t, err)
+
+	err = ds.TrashItem(ctx, "missing", "item-1", 0)
+	assertNotFound(t, err)
+
+	err = ds.RestoreItem(ctx, "missing", "item-1")
+	assertNotFound(t, err)
+
+	err = ds.PurgeItem(ctx, "missing", "item-1")
+	assertNotFound(t, err)
+
+	_, _, err = ds.GetTrashedItems(ctx, "missing", datalayer.QueryMeta{})
+	assertNotFound(t, err)
+
+	err = ds.SaveRevision(ctx, "missing", "item-1", testRevision(1))
+	assertNotFound(t, err)
+
+	_, _, err = ds.GetRevisions(ctx, "missing", "item-1", datalayer.QueryMeta{})
+	assertNotFound(t, err)
+
+	_, err = ds.GetRevision(ctx, "missing", "item-1", 1)
+	assertNotFound(t, err)
+}
+
+func testGetItems(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+
+	items, _, err := ds.GetItems(context.Background(), testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if len(items) != 0 {
+		t.Fatalf("got %d items from an empty collection, want 0", len(items))
+	}
+
+	for i := 0; i < 3; i++ {
+		mustSaveItem(t, ds, testCollection, fmt.Sprintf("item-%d", i), testItem(fmt.Sprintf("Post %d", i), float64(i)))
+	}
+
+	items, respInfo, err := ds.GetItems(context.Background(), testCollection, datalayer.QueryMeta{})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("got %d items, want 3", len(items))
+	}
+	wantInfo := datalayer.ItemsResponseInfo{Count: 3, PerPage: 3, PagesCount: 1, TotalCount: 3}
+	if respInfo != wantInfo {
+		t.Errorf("got response info %+v, want %+v", respInfo, wantInfo)
+	}
+	for _, item := range items {
+		id, _ := item["_id"].(string)
+		stored, err := ds.GetItem(context.Background(), testCollection, id)
+		if err != nil {
+			t.Fatalf("GetItem(%q) failed: %v", id, err)
+		}
+		assertJSONEqual(t, item, stored)
+	}
+}
+
+func testPaging(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	const total = 7
+	for i := 0; i < total; i++ {
+		mustSaveItem(t, ds, testCollection, fmt.Sprintf("item-%d", i), testItem(fmt.Sprintf("Post %d", i), float64(i)))
+	}
+
+	seen := map[string]bool{}
+	for page, wantLen := range []int{3, 3, 1, 0} {
+		items, respInfo, err := ds.GetItems(context.Background(), testCollection, datalayer.QueryMeta{Page: page + 1, Count: 3})
+		if err != nil {
+			t.Fatalf("GetItems(page %d) failed: %v", page+1, err)
+		}
+		if len(items) != wantLen {
+			t.Errorf("page %d: got %d items, want %d", page+1, len(items), wantLen)
+		}
+		wantInfo := datalayer.ItemsResponseInfo{
+			Count:        wantLen,
+			PerPage:      3,
+			ItemsSkipped: page * 3,
+			PagesCount:   3,
+			TotalCount:   total,
+		}
+		if respInfo != wantInfo {
+			t.Errorf("page %d: got response info %+v, want %+v", page+1, respInfo, wantInfo)
+		}
+		for _, item := range items {
+			id, _ := item["_id"].(string)
+			if seen[id] {
+				t.Errorf("page %d: item %q was already returned by an earlier page", page+1, id)
+			}
+			seen[id] = true
+		}
+	}
+	if len(seen) != total {
+		t.Errorf("pages returned %d distinct items, want %d", len(seen), total)
+	}
+}
+
+func testFilter(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	items := map[string]map[string]interface{}{
+		"go-basics":   {"title": "Go basics", "views": 1.0, "draft": false, "tags": []interface{}{"go", "intro"}, "author": map[string]interface{}{"name": "Ann"}},
+		"go-advanced": {"title": "Go advanced", "views": 10.0, "tags": []interface{}{"go"}, "author": map[string]interface{}{"name": "Bob"}},
+		"rust-intro":  {"title": "Rust intro", "views": 5.0, "tags": []interface{}{"rust", "intro"}, "author": map[string]interface{}{"name": "Ann"}},
+		"python":      {"title": "Python", "draft": true, "tags": []interface{}{}, "author": map[string]interface{}{"name": "Cy"}},
+	}
+	for id, item := range items {
+		mustSaveItem(t, ds, testCollection, id, item)
+	}
+
+	cond := func(field string, op datalayer.Operator, value interface{}) datalayer.Condition {
+		return datalayer.Condition{Path: strings.Split(field, "."), Op: op, Value: value}
+	}
+	tests := []struct {
+		filter datalayer.Filter
+		want   []string
+	}{
+		{datalayer.Filter{cond("title", datalayer.Eq, "Python")}, []string{"python"}},
+		{datalayer.Filter{cond("views", datalayer.Eq, 10.0)}, []string{"go-advanced"}},
+		{datalayer.Filter{cond("views", datalayer.Ne, 10.0)}, []string{"go-basics", "python", "rust-intro"}},
+		{datalayer.Filter{cond("views", datalayer.Gt, 1.0)}, []string{"go-advanced", "rust-intro"}},
+		{datalayer.Filter{cond("views", datalayer.Gte, 5.0)}, []string{"go-advanced", "rust-intro"}},
+		{datalayer.Filter{cond("views", datalayer.Lt, 5.0)}, []string{"go-basics"}},
+		{datalayer.Filter{cond("views", datalayer.Lte, 5.0)}, []string{"go-basics", "rust-intro"}},
+		{datalayer.Filter{cond("views", datalayer.In, []interface{}{1.0, 5.0})}, []string{"go-basics", "rust-intro"}},
+		{datalayer.Filter{cond("views", datalayer.Nin, []interface{}{1.0, 5.0})}, []string{"go-advanced", "python"}},
+		{datalayer.Filter{cond("views", datalayer.Exists, true)}, []string{"go-advanced", "go-basics", "rust-intro"}},
+		{datalayer.Filter{cond("views", datalayer.Exists, false)}, []string{"python"}},
+		{datalayer.Filter{cond("title", datalayer.Prefix, "Go ")}, []string{"go-advanced", "go-basics"}},
+		{datalayer.Filter{cond("title", datalayer.Prefix, "Go_")}, nil},
+		{datalayer.Filter{cond("title", datalayer.Gt, "Python")}, []string{"rust-intro"}},
+		{datalayer.Filter{cond("draft", datalayer.Eq, true)}, []string{"python"}},
+		{datalayer.Filter{cond("draft", datalayer.Ne, true)}, []string{"go-advanced", "go-basics", "rust-intro"}},
+		{datalayer.Filter{cond("tags", datalayer.Eq, "intro")}, []string{"go-basics", "rust-intro"}},
+		{datalayer.Filter{cond("tags", datalayer.In, []interface{}{"rust", "python"})}, []string{"rust-intro"}},
+		{datalayer.Filter{cond("tags", datalayer.Ne, "go")}, []string{"python", "rust-intro"}},
+		{datalayer.Filter{cond("tags", datalayer.Nin, []interface{}{"go", "rust"})}, []string{"python"}},
+		{datalayer.Filter{cond("author.name", datalayer.Eq, "Ann")}, []string{"go-basics", "rust-intro"}},
+		{datalayer.Filter{cond("author.name", datalayer.Prefix, "B")}, []string{"go-advanced"}},
+		{datalayer.Filter{cond("author.email", datalayer.Exists, false)}, []string{"go-advanced", "go-basics", "python", "rust-intro"}},
+		{datalayer.Filter{cond("tags", datalayer.Eq, "go"), cond("views", datalayer.Gt, 5.0)}, []string{"go-advanced"}},
+	}
+	for _, tt := range tests {
+		got, respInfo, err := ds.GetItems(context.Background(), testCollection, datalayer.QueryMeta{Filter: tt.filter})
+		if err != nil {
+			t.Fatalf("GetItems(%v) failed: %v", tt.filter, err)
+		}
+		ids := []string{}
+		for _, item := range got {
+			id, _ := item["_id"].(string)
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		if want := append([]string{}, tt.want...); !reflect.DeepEqual(ids, want) {
+			t.Errorf("GetItems(%v): got %v, want %v", tt.filter, ids, want)
+		}
+		if respInfo.TotalCount != len(tt.want) {
+			t.Errorf("GetItems(%v): got total count %d, want %d", tt.filter, respInfo.TotalCount, len(tt.want))
+		}
+	}
+
+	filter := datalayer.Filter{cond("tags", datalayer.Eq, "go")}
+	got, respInfo, err := ds.GetItems(context.Background(), testCollection, datalayer.QueryMeta{Page: 2, Count: 1, Filter: filter})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	wantInfo := datalayer.ItemsResponseInfo{Count: 1, PerPage: 1, ItemsSkipped: 1, PagesCount: 2, TotalCount: 2}
+	if len(got) != 1 || respInfo != wantInfo {
+		t.Errorf("got %d items and response info %+v, want 1 item and %+v", len(got), respInfo, wantInfo)
+	}
+}
+
+func testSort(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "d", map[string]interface{}{"title": "Delta", "author": map[string]interface{}{"name": "Bob"}})
+	mustSaveItem(t, ds, testCollection, "b", map[string]interface{}{"title": "Bravo", "views": 10.0, "author": map[string]interface{}{"name": "Ann"}})
+	mustSaveItem(t, ds, testCollection, "c", map[string]interface{}{"title": "Charlie", "views": 5.0, "author": map[string]interface{}{"name": "Ann"}})
+	mustSaveItem(t, ds, testCollection, "a", map[string]interface{}{"title": "Alpha", "views": 5.0, "author": map[string]interface{}{"name": "Cy"}})
+
+	field := func(name string, desc bool) datalayer.SortField {
+		return datalayer.SortField{Path: strings.Split(name, "."), Desc: desc}
+	}
+	tests := []struct {
+		query datalayer.QueryMeta
+		want  []string
+	}{
+		{datalayer.QueryMeta{Sort: datalayer.Sort{field("title", false)}}, []string{"a", "b", "c", "d"}},
+		{datalayer.QueryMeta{Sort: datalayer.Sort{field("title", true)}}, []string{"d", "c", "b", "a"}},
+		{datalayer.QueryMeta{Sort: datalayer.Sort{field("views", false)}}, []string{"d", "a", "c", "b"}},
+		{datalayer.QueryMeta{Sort: datalayer.Sort{field("views", true)}}, []string{"b", "a", "c", "d"}},
+		{datalayer.QueryMeta{Sort: datalayer.Sort{field("views", true), field("title", true)}}, []string{"b", "c", "a", "d"}},
+		{datalayer.QueryMeta{Sort: datalayer.Sort{field("author.name", false), field("views", false)}}, []string{"c", "b", "d", "a"}},
+		{datalayer.QueryMeta{Sort: datalayer.Sort{field("views", true)}, Page: 2, Count: 2}, []string{"c", "d"}},
+		{datalayer.QueryMeta{Sort: datalayer.Sort{field("title", true)}, Filter: datalayer.Filter{{Path: []string{"views"}, Op: datalayer.Eq, Value: 5.0}}}, []string{"c", "a"}},
+	}
+	for _, tt := range tests {
+		items, _, err := ds.GetItems(context.Background(), testCollection, tt.query)
+		if err != nil {
+			t.Fatalf("GetItems(%+v) failed: %v", tt.query, err)
+		}
+		ids := []string{}
+		for _, item := range items {
+			id, _ := item["_id"].(string)
+			ids = append(ids, id)
+		}
+		if !reflect.DeepEqual(ids, tt.want) {
+			t.Errorf("GetItems(%+v): got %v, want %v", tt.query, ids, tt.want)
+		}
+	}
+}
+
+func testProjection(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", map[string]interface{}{
+		"title":    "First",
+		"subtitle": nil,
+		"body":     "A long body",
+		"draft":    true,
+		"views":    1.0,
+		"author":   map[string]interface{}{"name": "Ann", "email": "ann@example.com"},
+	})
+	mustSaveItem(t, ds, testCollection, "item-2", map[string]interface{}{"title": "Second", "body": "Another body"})
+
+	fields, err := datalayer.ParseProjection("title,subtitle,draft,author.name,views")
+	if err != nil {
+		t.Fatalf("ParseProjection failed: %v", err)
+	}
+	items, respInfo, err := ds.GetItems(context.Background(), testCollection, datalayer.QueryMeta{Fields: fields, Sort: datalayer.Sort{{Path: []string{"title"}}}})
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	assertJSONEqual(t, items, []map[string]interface{}{
+		{"_id": "item-1", datalayer.VersionField: 1, "title": "First", "subtitle": nil, "draft": true, "views": 1, "author": map[string]interface{}{"name": "Ann"}},
+		{"_id": "item-2", datalayer.VersionField: 1, "title": "Second"},
+	})
+	if respInfo.TotalCount != 2 {
+		t.Errorf("got total count %d, want 2", respInfo.TotalCount)
+	}
+
+	fields, _ = datalayer.ParseProjection("author")
+	query := datalayer.QueryMeta{Fields: fields, Page: 1, Count: 1, Filter: datalayer.Filter{{Path: []string{"draft"}, Op: datalayer.Eq, Value: true}}}
+	items, _, err = ds.GetItems(context.Background(), testCollection, query)
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	assertJSONEqual(t, items, []map[string]interface{}{
+		{"_id": "item-1", datalayer.VersionField: 1, "author": map[string]interface{}{"name": "Ann", "email": "ann@example.com"}},
+	})
+}
+
+func testCursor(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	for i, views := range []interface{}{5.0, 10.0, 5.0, nil, 1.0, 10.0, "missing", 5.0} {
+		item := map[string]interface{}{"title": fmt.Sprintf("Post %d", i%3)}
+		if views != "missing" {
+			item["views"] = views
+		}
+		mustSaveItem(t, ds, testCollection, fmt.Sprintf("item-%d", i), item)
+	}
+
+	ids := func(items []map[string]interface{}) []string {
+		ids := []string{}
+		for _, item := range items {
+			id, _ := item["_id"].(string)
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	field := func(name string, desc bool) datalayer.SortField {
+		return datalayer.SortField{Path: []string{name}, Desc: desc}
+	}
+	views := datalayer.Filter{{Path: []string{"views"}, Op: datalayer.Exists, Value: true}}
+	tests := []datalayer.QueryMeta{
+		{},
+		{Sort: datalayer.Sort{field("views", false)}},
+		{Sort: datalayer.Sort{field("views", true)}},
+		{Sort: datalayer.Sort{field("views", true), field("title", false)}},
+		{Sort: datalayer.Sort{field("title", true), field("views", false)}},
+		{Sort: datalayer.Sort{field("title", false), field("views", true)}},
+		{Sort: datalayer.Sort{field("views", false)}, Filter: views},
+	}
+	for _, query := range tests {
+		// The listing without a cursor, sorted by _id when the sort is empty.
+		all, _, err := ds.GetItems(ctx, testCollection, datalayer.QueryMeta{Sort: append(query.Sort, field("_id", false)), Filter: query.Filter})
+		if err != nil {
+			t.Fatalf("GetItems(%+v) failed: %v", query, err)
+		}
+
+		got := []string{}
+		query.Count = 3
+		for pages := 0; pages < 5; pages++ {
+			items, respInfo, err := ds.GetItems(ctx, testCollection, query)
+			if err != nil {
+				t.Fatalf("GetItems(%+v) failed: %v", query, err)
+			}
+			if respInfo.TotalCount != len(all) {
+				t.Errorf("sort %s: got total count %d, want %d", query.Sort, respInfo.TotalCount, len(all))
+			}
+			got = append(got, ids(items)...)
+			if len(items) < query.Count {
+				break
+			}
+			query.Cursor = datalayer.NewCursor(query.Sort, items[len(items)-1])
+		}
+		if want := ids(all); !reflect.DeepEqual(got, want) {
+			t.Errorf("sort %s: pages returned %v, want %v", query.Sort, got, want)
+		}
+	}
+
+	// Pages are in _id order even if items weren't saved in that order.
+	fresh := "cursor-order"
+	mustCreateCollection(t, ds, fresh)
+	for _, id := range []string{"c", "a", "d", "b"} {
+		mustSaveItem(t, ds, fresh, id, testItem("Post", 1))
+	}
+	got := []string{}
+	paged := datalayer.QueryMeta{Count: 2}
+	for pages := 0; pages < 5; pages++ {
+		items, _, err := ds.GetItems(ctx, fresh, paged)
+		if err != nil {
+			t.Fatalf("GetItems failed: %v", err)
+		}
+		got = append(got, ids(items)...)
+		if len(items) < paged.Count {
+			break
+		}
+		paged.Cursor = datalayer.NewCursor(nil, items[len(items)-1])
+	}
+	if want := []string{"a", "b", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("pages of items saved out of order returned %v, want %v", got, want)
+	}
+	if err := ds.DeleteItem(ctx, fresh, "b", 0); err != nil {
+		t.Fatalf("DeleteItem failed: %v", err)
+	}
+	paged.Cursor = &datalayer.Cursor{Values: []interface{}{}, ID: "b"}
+	items, respInfo, err := ds.GetItems(ctx, fresh, paged)
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if got, want := ids(items), []string{"c", "d"}; !reflect.DeepEqual(got, want) || respInfo.TotalCount != 3 {
+		t.Errorf("after deleted item b: got %v of %d, want %v of 3", got, respInfo.TotalCount, want)
+	}
+
+	// A cursor still resumes after its item once the item is gone.
+	query := datalayer.QueryMeta{Sort: datalayer.Sort{field("views", true)}, Count: 4}
+	items, _, err = ds.GetItems(ctx, testCollection, query)
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	last := items[len(items)-1]
+	if err = ds.DeleteItem(ctx, testCollection, last["_id"].(string), 0); err != nil {
+		t.Fatalf("DeleteItem failed: %v", err)
+	}
+	query.Cursor = datalayer.NewCursor(query.Sort, last)
+	rest, _, err := ds.GetItems(ctx, testCollection, query)
+	if err != nil {
+		t.Fatalf("GetItems failed: %v", err)
+	}
+	if got, want := ids(rest), []string{"item-7", "item-4", "item-3", "item-6"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after a deleted item: got %v, want %v", got, want)
+	}
+}
+
+func testAggregate(t *testing.T, ds datalayer.DataStore) {
+	ctx := context.Background()
+	mustCreateCollection(t, ds, testCollection)
+	items := map[string]map[string]interface{}{
+		"a": {"title": "A", "status": "published", "price": 10.0, "author": map[string]interface{}{"name": "Ann"}},
+		"b": {"title": "B", "status": "published", "price": 5.5, "author": map[string]interface{}{"name": "Bob"}},
+		"c": {"title": "C", "status": "draft", "price": 2.0, "author": map[string]interface{}{"name": "Ann"}},
+		"d": {"title": "D", "status": "draft", "author": map[string]interface{}{"name": "Ann"}},
+		"e": {"title": "E", "price": 1.0},
+	}
+	for id, item := range items {
+		mustSaveItem(t, ds, testCollection, id, item)
+	}
+
+	path := func(field string) []string { return strings.Split(field, ".") }
+	metric := func(op datalayer.MetricOp, field string) datalayer.Metric {
+		if op == datalayer.Count {
+			return datalayer.Metric{Op: op}
+		}
+		return datalayer.Metric{Op: op, Path: path(field)}
+	}
+	all := []datalayer.Metric{
+		metric(datalayer.Count, ""),
+		metric(datalayer.Sum, "price"),
+		metric(datalayer.Avg, "price"),
+		metric(datalayer.Min, "price"),
+		metric(datalayer.Max, "title"),
+	}
+	group := func(key map[string]interface{}, metrics ...interface{}) datalayer.Group {
+		g := datalayer.Group{Key: key, Metrics: map[string]interface{}{}}
+		for i, m := range all[:len(metrics)] {
+			g.Metrics[m.Name()] = metrics[i]
+		}
+		return g
+	}
+	tests := []struct {
+		name        string
+		aggregation datalayer.Aggregation
+		want        []datalayer.Group
+	}{
+		{
+			"no groups",
+			datalayer.Aggregation{Metrics: all},
+			[]datalayer.Group{group(map[string]interface{}{}, 5, 18.5, 4.625, 1.0, "E")},
+		},
+		{
+			"group by status",
+			datalayer.Aggregation{GroupBy: [][]string{path("status")}, Metrics: all},
+			[]datalayer.Group{
+				group(map[string]interface{}{"status": nil}, 1, 1.0, 1.0, 1.0, "E"),
+				group(map[string]interface{}{"status": "draft"}, 2, 2.0, 2.0, 2.0, "D"),
+				group(map[string]interface{}{"status": "published"}, 2, 15.5, 7.75, 5.5, "B"),
+			},
+		},
+		{
+			"group by two fields",
+			datalayer.Aggregation{GroupBy: [][]string{path("author.name"), path("status")}, Metrics: all[:3]},
+			[]datalayer.Group{
+				group(map[string]interface{}{"author.name": nil, "status": nil}, 1, 1.0, 1.0),
+				group(map[string]interface{}{"author.name": "Ann", "status": "draft"}, 2, 2.0, 2.0),
+				group(map[string]interface{}{"author.name": "Ann", "status": "published"}, 1, 10.0, 10.0),
+				group(map[string]interface{}{"author.name": "Bob", "status": "published"}, 1, 5.5, 5.5),
+			},
+		},
+		{
+			"filtered",
+			datalayer.Aggregation{
+				Filter:  datalayer.Filter{{Path: path("status"), Op: datalayer.Eq, Value: "draft"}},
+				GroupBy: [][]string{path("status")},
+				Metrics: all,
+			},
+			[]datalayer.Group{group(map[string]interface{}{"status": "draft"}, 2, 2.0, 2.0, 2.0, "D")},
+		},
+		{
+			"no matches",
+			datalayer.Aggregation{
+				Filter:  datalayer.Filter{{Path: path("status"), Op: datalayer.Eq, Value: "archived"}},
+				Metrics: all,
+			},
+			[]datalayer.Group{group(map[string]interface{}{}, 0, 0.0, nil, nil, nil)},
+		},
+		{
+			"no matches by group",
+			datalayer.Aggregation{
+				Filter:  datalayer.Filter{{Path: path("status"), Op: datalayer.Eq, Value: "archived"}},
+				GroupBy: [][]string{path("status")},
+				Metrics: all,
+			},
+			[]datalayer.Group{},
+		},
+	}
+	for _, tt := range tests {
+		got, err := ds.Aggregate(ctx, testCollection, tt.aggregation)
+		if err != nil {
+			t.Fatalf("Aggregate(%s) failed: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(normalize(t, got), normalize(t, tt.want)) {
+			t.Errorf("Aggregate(%s): got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func testReturnedItemsAreCopies(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	item := testItem("Original", 1)
+	mustSaveItem(t, ds, testCollection, "item-1", item)
+	item["title"] = "Changed after save"
+
+	got, err := ds.GetItem(context.Background(), testCollection, "item-1")
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	got["title"] = "Changed after get"
+	got["author"].(map[string]interface{})["name"] = "Changed after get"
+
+	got, err = ds.GetItem(context.Background(), testCollection, "item-1")
+	if err != nil {
+		t.Fatalf("GetItem failed: %v", err)
+	}
+	if got["title"] != "Original" {
+		t.Errorf("stored item was mutated: got title %v, want %q", got["title"], "Original")
+	}
+	if name := got["author"].(map[string]interface{})["name"]; name != "Ninja" {
+		t.Errorf("stored item was mutated: got author.name %v, want %q", name, "Ninja")
+	}
+}
+
+func testContextCancellation(t *testing.T, ds datalayer.DataStore) {
+	mustCreateCollection(t, ds, testCollection)
+	mustSaveItem(t, ds, testCollection, "item-1", testItem("Hello", 1))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := map[string]func() error{
+		"CreateCollection": func() error {
+			return ds.CreateCollection(ctx, testCollection+"_cancelled", testSchema(), testMeta())
+		},
+		"GetCollections": func() error {
+			_, err := ds.GetCollections(ctx)
+			return err
+		},
+		"GetSchema": func() error {
+			_, err := ds.GetSchema(ctx, testCollection)
+			return err
+		},
+		"GetCollection": func() error {
+			_, err := ds.GetCollection(ctx, testCollection)
+			return err
+		},
+		"GetSchemaVersions": func() error {
+			_, err := ds.GetSchemaVersions(ctx, testCollection)
+			return err
+		},
+		"UpdateCollection": func() error {
+			return ds.UpdateCollection(ctx, testCollection, testSchema(), testMeta())
+		},
+		"RenameCollection": func() error {
+			return ds.RenameCollection(ctx, testCollection, testCollection+"_renamed")
+		},
+		"DeleteCollection": func() error {
+			return ds.DeleteCollection(ctx, testCollection)
+		},
+		"SaveItem": func() error {
+			return ds.SaveItem(ctx, testCollection, "item-2", testItem("Cancelled", 2))
+		},
+		"UpdateItem": func() error {
+			return ds.UpdateItem(ctx, testCollection, "item-1", testItem("Cancelled", 2), 0)
+		},
+		"UpsertItem": func() error {
+			_, err := ds.UpsertItem(ctx, testCollection, "item-1", testItem("Cancelled", 2))
+			return err
+		},
+		"GetItem": func() error {
+			_, err := ds.GetItem(ctx, testCollection, "item-1")
+			return err
+		},
+		"GetItemBySlug": func() error {
+			_, err := ds.GetItemBySlug(ctx, testCollection, "hello")
+			return err
+		},
+		"GetItems": func() error {
+			_, _, err := ds.GetItems(ctx, testCollection, datalayer.QueryMeta{})
+			return err
+		},
+		"Aggregate": func() error {
+			_, err := ds.Aggregate(ctx, testCollection, datalayer.Aggregation{Metrics: []datalayer.Metric{{Op: datalayer.Count}}})
+			return err
+		},
+		"DeleteItem": func() error {
+			return ds.DeleteItem(ctx, testCollection, "item-1", 0)
+		},
+		"TrashItem": func() error {
+			return ds.TrashItem(ctx, testCollection, "item-1", 0)
+		},
+		"GetTrashedItems": func() error {
+			_, _, err := ds.GetTrashedItems(ctx, testCollection, datalayer.QueryMeta{})
+			return err
+		},
+		"SaveRevision": func() error {
+			return ds.SaveRevision(ctx, testCollection, "item-1", testRevision(1))
+		},
+		"GetRevisions": func() error {
+			_, _, err := ds.GetRevisions(ctx, testCollection, "item-1", datalayer.QueryMeta{})
+			return err
+		},
+		"GetRevision": func() error {
+			_, err := ds.GetRevision(ctx, testCollection, "item-1", 1)
+			return err
+		},
+	}
+	for name, call := range calls {
+		if err := call(); err == nil {
+			t.Errorf("%s succeeded with a cancelled context, want an error", name)
+		}
+	}
+
+	if _, err := ds.GetItem(context.Background(), testCollection, "item-2"); errors.Cause(err) != datalayer.ErrNotFound {
+		t.Errorf("SaveItem with a cancelled context stored the item anyway")
+	}
+	if _, err := ds.GetItem(context.Background(), testCollection, "item-1"); err != nil {
+		t.Errorf("DeleteItem or TrashItem with a cancelled context removed the item anyway")
+	}
+}
@@ -0,0 +1,263 @@
+package datalayer
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Operator is the comparison a Condition makes.
+type Operator string
+
+const (
+	Eq     Operator = "eq"
+	Ne     Operator = "ne"
+	Gt     Operator = "gt"
+	Gte    Operator = "gte"
+	Lt     Operator = "lt"
+	Lte    Operator = "lte"
+	In     Operator = "in"
+	Nin    Operator = "nin"
+	Exists Operator = "exists"
+	Prefix Operator = "prefix"
+)
+
+var operators = map[Operator]bool{Eq: true, Ne: true, Gt: true, Gte: true, Lt: true, Lte: true, In: true, Nin: true, Exists: true, Prefix: true}
+
+// Condition compares the value at Path with Value.
+//
+// Value is a string, float64 or bool, except for In and Nin, where it is a
+// []interface{} of those, and Exists, where it is a bool telling whether the
+// field must be present. When the value at Path is an array, Eq, In, Prefix
+// and the ordering operators match if any element does, and Ne and Nin
+// match if no element does Eq or In. Ne and Nin also match items that lack
+// the field.
+type Condition struct {
+	Path  []string
+	Op    Operator
+	Value interface{}
+}
+
+// Field returns the dot-separated Path of c.
+func (c Condition) Field() string {
+	return strings.Join(c.Path, ".")
+}
+
+// Filter selects the items that match all of its conditions.
+type Filter []Condition
+
+// InvalidQueryError is returned when a listing's filter can't be parsed, or
+// doesn't fit the collection's schema.
+type InvalidQueryError struct {
+	Param  string
+	Reason string
+}
+
+func (e *InvalidQueryError) Error() string {
+	return fmt.Sprintf("datalayer: invalid %s: %s", e.Param, e.Reason)
+}
+
+var filterParam = regexp.MustCompile(`^filter\[([^\[\]]+)\](?:\[([a-z]+)\])?$`)
+
+// ParseFilter reads the `filter[field][op]=value` query parameters of an item
+// listing. Nested fields are dot-separated and op defaults to eq. The values
+// of in and nin are comma-separated lists. Values are left as strings, except
+// those of exists, for the caller to convert to the field's type.
+func ParseFilter(params url.Values) (Filter, error) {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if strings.HasPrefix(key, "filter[") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	filter := Filter{}
+	for _, key := range keys {
+		match := filterParam.FindStringSubmatch(key)
+		if match == nil {
+			return nil, &InvalidQueryError{Param: key, Reason: "expected filter[field] or filter[field][op]"}
+		}
+		path, ok := parsePath(match[1])
+		if !ok {
+			return nil, &InvalidQueryError{Param: key, Reason: fmt.Sprintf("invalid field %q", match[1])}
+		}
+		op := Eq
+		if match[2] != "" {
+			op = Operator(match[2])
+		}
+		if !operators[op] {
+			return nil, &InvalidQueryError{Param: key, Reason: fmt.Sprintf("unknown operator %q", op)}
+		}
+
+		for _, raw := range params[key] {
+			condition := Condition{Path: path, Op: op, Value: raw}
+			switch op {
+			case In, Nin:
+				values := []interface{}{}
+				for _, v := range strings.Split(raw, ",") {
+					values = append(values, v)
+				}
+				condition.Value = values
+			case Exists:
+				exists, err := strconv.ParseBool(raw)
+				if err != nil {
+					return nil, &InvalidQueryError{Param: key, Reason: fmt.Sprintf("expected true or false, got %q", raw)}
+				}
+				condition.Value = exists
+			}
+			filter = append(filter, condition)
+		}
+	}
+	return filter, nil
+}
+
+// parsePath splits a dot-separated field into its path. Segments can't be
+// empty or hold characters that are special to the drivers' query languages.
+func parsePath(field string) ([]string, bool) {
+	path := strings.Split(field, ".")
+	for _, p := range path {
+		if p == "" || strings.ContainsAny(p, `"'$`) {
+			return nil, false
+		}
+	}
+	return path, true
+}
+
+// Matches reports whether item satisfies every condition of f. Drivers that
+// can't query their storage natively filter with it.
+func (f Filter) Matches(item map[string]interface{}) bool {
+	for _, c := range f {
+		if !c.Matches(item) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether item satisfies c.
+func (c Condition) Matches(item map[string]interface{}) bool {
+	value, found := Lookup(item, c.Path)
+	switch c.Op {
+	case Exists:
+		return found == c.Value.(bool)
+	case Ne:
+		return !found || !anyElement(value, func(v interface{}) bool { return compare(v, c.Value) == 0 })
+	case Nin:
+		return !found || !anyElement(value, func(v interface{}) bool { return in(v, c.Value) })
+	}
+	if !found {
+		return false
+	}
+	return anyElement(value, func(v interface{}) bool {
+		switch c.Op {
+		case Eq:
+			return compare(v, c.Value) == 0
+		case In:
+			return in(v, c.Value)
+		case Gt:
+			return compare(v, c.Value) == 1
+		case Gte:
+			cmp := compare(v, c.Value)
+			return cmp == 0 || cmp == 1
+		case Lt:
+			return compare(v, c.Value) == -1
+		case Lte:
+			cmp := compare(v, c.Value)
+			return cmp == 0 || cmp == -1
+		case Prefix:
+			s, ok := v.(string)
+			prefix, _ := c.Value.(string)
+			return ok && strings.HasPrefix(s, prefix)
+		}
+		return false
+	})
+}
+
+// Lookup returns the value at path in item, following nested objects.
+func Lookup(item map[string]interface{}, path []string) (value interface{}, found bool) {
+	value = item
+	for _, p := range path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = object[p]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// anyElement applies match to value, or to each element if value is an array.
+func anyElement(value interface{}, match func(interface{}) bool) bool {
+	if array, ok := value.([]interface{}); ok {
+		for _, v := range array {
+			if match(v) {
+				return true
+			}
+		}
+		return false
+	}
+	return match(value)
+}
+
+func in(value, values interface{}) bool {
+	list, _ := values.([]interface{})
+	for _, v := range list {
+		if compare(value, v) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// compare orders two values of the same kind, returning -1, 0 or 1. Values
+// of different kinds, and booleans that differ, are incomparable and give 2.
+func compare(a, b interface{}) int {
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		switch {
+		case !ok:
+			return 2
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		if !ok {
+			return 2
+		}
+		return strings.Compare(x, y)
+	case bool:
+		if y, ok := b.(bool); ok && x == y {
+			return 0
+		}
+	}
+	return 2
+}
+
+// number converts the numeric types drivers hand back to float64.
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	}
+	return 0, false
+}
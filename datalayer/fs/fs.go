@@ -0,0 +1,980 @@
+package fs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// Datastore keeps every collection as a directory of pretty-printed JSON
+// files under Root, so content can be committed, reviewed and diffed in git:
+//
+//	<root>/<collection>/schema.json
+//	<root>/<collection>/meta.json
+//	<root>/<collection>/<itemID>.json
+//	<root>/<collection>/.schemas/<version>.json
+//	<root>/<collection>/.trash/<itemID>.json
+//	<root>/<collection>/.revisions/<itemID>/<revision>.json
+//
+// schema.json always holds the latest schema version.
+type Datastore struct {
+	Root string
+
+	mu    sync.Mutex
+	locks map[string]*collectionLock
+}
+
+const (
+	DriverName = "fs"
+
+	schemaFile   = "schema.json"
+	metaFile     = "meta.json"
+	trashDir     = ".trash"
+	schemasDir   = ".schemas"
+	revisionsDir = ".revisions"
+	fileExt      = ".json"
+)
+
+func init() {
+	datalayer.Register(DriverName, &Datastore{})
+}
+
+func NewDatastore(config datalayer.DBConfig) (*Datastore, error) {
+	ds := Datastore{}
+	ds.Root = config.ConnectionString
+	ds.locks = make(map[string]*collectionLock)
+	if err := os.MkdirAll(ds.Root, 0755); err != nil {
+		return nil, errors.Wrap(err, "fs: unable to create root directory")
+	}
+	return &ds, nil
+}
+
+func (ds *Datastore) Connect(config datalayer.DBConfig) (datalayer.DataStore, error) {
+	return NewDatastore(config)
+}
+
+// collectionLock guards a single collection directory. refs counts the
+// callers holding or waiting for it, so it can be dropped once unused.
+type collectionLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// acquire returns the lock of a collection directory, counting the caller
+// as a user until release.
+func (ds *Datastore) acquire(collectionName string) *collectionLock {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	l, ok := ds.locks[collectionName]
+	if !ok {
+		l = &collectionLock{}
+		ds.locks[collectionName] = l
+	}
+	l.refs++
+	return l
+}
+
+// release forgets the lock of a collection directory once its last user is
+// done, so locks of deleted, renamed or missing collections don't pile up.
+func (ds *Datastore) release(collectionName string, l *collectionLock) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if l.refs--; l.refs == 0 {
+		delete(ds.locks, collectionName)
+	}
+}
+
+// lock write-locks a collection directory and returns the function unlocking
+// it.
+func (ds *Datastore) lock(collectionName string) (unlock func()) {
+	l := ds.acquire(collectionName)
+	l.Lock()
+	return func() {
+		l.Unlock()
+		ds.release(collectionName, l)
+	}
+}
+
+// rlock read-locks a collection directory and returns the function unlocking
+// it.
+func (ds *Datastore) rlock(collectionName string) (unlock func()) {
+	l := ds.acquire(collectionName)
+	l.RLock()
+	return func() {
+		l.RUnlock()
+		ds.release(collectionName, l)
+	}
+}
+
+// validName rejects names that would escape or clash inside a directory.
+func validName(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") || strings.ContainsAny(name, `/\`) {
+		return errors.Errorf("%q is not a valid name", name)
+	}
+	return nil
+}
+
+func (ds *Datastore) collectionDir(collectionName string) (string, error) {
+	if err := validName(collectionName); err != nil {
+		return "", err
+	}
+	dir := filepath.Join(ds.Root, collectionName)
+	if _, err := os.Stat(filepath.Join(dir, schemaFile)); os.IsNotExist(err) {
+		return "", datalayer.ErrNotFound
+	} else if err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func itemFile(dir, itemID string) (string, error) {
+	if err := validName(itemID); err != nil {
+		return "", err
+	}
+	name := itemID + fileExt
+	if name == schemaFile || name == metaFile {
+		return "", errors.Errorf("%q is a reserved item ID", itemID)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// writeFileAtomic writes v as indented JSON to a temp file next to path and
+// renames it into place, so readers never observe a partially written file.
+func writeFileAtomic(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func readFile(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return datalayer.ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (ds *Datastore) CreateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validName(name); err != nil {
+		return errors.Wrap(err, "fs: unable to create collection")
+	}
+
+	defer ds.lock(name)()
+
+	dir := filepath.Join(ds.Root, name)
+	if _, err := os.Stat(filepath.Join(dir, schemaFile)); err == nil {
+		return errors.Wrapf(datalayer.ErrConflict, "fs: unable to create collection: %s", name)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "fs: unable to create collection")
+	}
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	if err := writeFileAtomic(filepath.Join(dir, metaFile), metadata); err != nil {
+		return errors.Wrap(err, "fs: unable to create collection")
+	}
+	if err := writeSchemaVersion(dir, 1, schema); err != nil {
+		return errors.Wrap(err, "fs: unable to create collection")
+	}
+	// schema.json is written last since its presence marks the collection as created.
+	err := writeFileAtomic(filepath.Join(dir, schemaFile), schema)
+	return errors.Wrap(err, "fs: unable to create collection")
+}
+
+func (ds *Datastore) GetCollections(ctx context.Context) (collections []datalayer.CollectionVM, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(ds.Root)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs: unable to get collections")
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() || validName(entry.Name()) != nil {
+			continue
+		}
+		collection, err := ds.readCollection(entry.Name())
+		if errors.Cause(err) == datalayer.ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "fs: unable to get collections")
+		}
+		collections = append(collections, collection)
+	}
+	return collections, nil
+}
+
+func (ds *Datastore) UpdateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer ds.lock(name)()
+
+	dir, err := ds.collectionDir(name)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to update collection")
+	}
+	if metadata != nil {
+		if err = writeFileAtomic(filepath.Join(dir, metaFile), metadata); err != nil {
+			return errors.Wrap(err, "fs: unable to update collection")
+		}
+	}
+	if schema != nil {
+		versions, err := schemaVersions(dir)
+		if err != nil {
+			return errors.Wrap(err, "fs: unable to update collection")
+		}
+		if _, err := os.Stat(filepath.Join(dir, schemasDir)); os.IsNotExist(err) {
+			// Keep the unversioned schema as version 1.
+			if err = writeSchemaVersion(dir, 1, versions[0]); err != nil {
+				return errors.Wrap(err, "fs: unable to update collection")
+			}
+		}
+		if err = writeSchemaVersion(dir, len(versions)+1, schema); err != nil {
+			return errors.Wrap(err, "fs: unable to update collection")
+		}
+		if err = writeFileAtomic(filepath.Join(dir, schemaFile), schema); err != nil {
+			return errors.Wrap(err, "fs: unable to update collection")
+		}
+	}
+	return nil
+}
+
+func (ds *Datastore) RenameCollection(ctx context.Context, name, newName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validName(newName); err != nil {
+		return errors.Wrap(err, "fs: unable to rename collection")
+	}
+	if newName == name {
+		return errors.Wrapf(datalayer.ErrConflict, "fs: unable to rename collection: %s", newName)
+	}
+
+	// Lock both directories in a fixed order so concurrent renames can't
+	// deadlock.
+	first, second := name, newName
+	if second < first {
+		first, second = second, first
+	}
+	for _, n := range []string{first, second} {
+		defer ds.lock(n)()
+	}
+
+	dir, err := ds.collectionDir(name)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to rename collection")
+	}
+	newDir := filepath.Join(ds.Root, newName)
+	if _, err := os.Stat(newDir); err == nil {
+		return errors.Wrapf(datalayer.ErrConflict, "fs: unable to rename collection: %s", newName)
+	}
+	return errors.Wrap(os.Rename(dir, newDir), "fs: unable to rename collection")
+}
+
+func (ds *Datastore) DeleteCollection(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer ds.lock(name)()
+
+	dir, err := ds.collectionDir(name)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to delete collection")
+	}
+	// schema.json goes first, so an interrupted delete never leaves a
+	// collection with only some of its items.
+	if err = os.Remove(filepath.Join(dir, schemaFile)); err != nil {
+		return errors.Wrap(err, "fs: unable to delete collection")
+	}
+	return errors.Wrap(os.RemoveAll(dir), "fs: unable to delete collection")
+}
+
+func (ds *Datastore) readCollection(name string) (collection datalayer.CollectionVM, err error) {
+	defer ds.rlock(name)()
+
+	dir := filepath.Join(ds.Root, name)
+	collection.Name = name
+	if err = readFile(filepath.Join(dir, schemaFile), &collection.Schema); err != nil {
+		return collection, err
+	}
+	if err = readFile(filepath.Join(dir, metaFile), &collection.Meta); err != nil {
+		return collection, err
+	}
+	versions, err := schemaVersions(dir)
+	collection.Version = len(versions)
+	return collection, err
+}
+
+func writeSchemaVersion(dir string, version int, schema map[string]interface{}) error {
+	if err := os.MkdirAll(filepath.Join(dir, schemasDir), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, schemasDir, strconv.Itoa(version)+fileExt), schema)
+}
+
+// schemaVersions reads every schema version of the collection in dir, oldest
+// first. Collections created before schemas were versioned only have their
+// current schema.
+func schemaVersions(dir string) ([]map[string]interface{}, error) {
+	entries, err := ioutil.ReadDir(filepath.Join(dir, schemasDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	count := 0
+	for _, entry := range entries {
+		if _, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), fileExt)); err == nil {
+			count++
+		}
+	}
+	versions := []map[string]interface{}{}
+	for i := 1; i <= count; i++ {
+		schema := map[string]interface{}{}
+		if err := readFile(filepath.Join(dir, schemasDir, strconv.Itoa(i)+fileExt), &schema); err != nil {
+			return nil, err
+		}
+		versions = append(versions, schema)
+	}
+	if len(versions) == 0 {
+		schema := map[string]interface{}{}
+		if err := readFile(filepath.Join(dir, schemaFile), &schema); err != nil {
+			return nil, err
+		}
+		versions = append(versions, schema)
+	}
+	return versions, nil
+}
+
+func (ds *Datastore) GetCollection(ctx context.Context, name string) (collection datalayer.CollectionVM, err error) {
+	if err := ctx.Err(); err != nil {
+		return collection, err
+	}
+	if err := validName(name); err != nil {
+		return collection, errors.Wrap(datalayer.ErrNotFound, "fs: unable to get collection")
+	}
+	collection, err = ds.readCollection(name)
+	return collection, errors.Wrap(err, "fs: unable to get collection")
+}
+
+func (ds *Datastore) GetSchemaVersions(ctx context.Context, name string) (versions []datalayer.SchemaVersion, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	defer ds.rlock(name)()
+
+	dir, err := ds.collectionDir(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs: unable to get schema versions")
+	}
+	schemas, err := schemaVersions(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs: unable to get schema versions")
+	}
+	for i, schema := range schemas {
+		versions = append(versions, datalayer.SchemaVersion{Version: i + 1, Schema: schema})
+	}
+	return versions, nil
+}
+
+func (ds *Datastore) GetSchema(ctx context.Context, collectionName string) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validName(collectionName); err != nil {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "fs: unable to get schema")
+	}
+
+	defer ds.rlock(collectionName)()
+
+	schema := map[string]interface{}{}
+	err := readFile(filepath.Join(ds.Root, collectionName, schemaFile), &schema)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs: unable to get schema")
+	}
+	return schema, nil
+}
+
+func (ds *Datastore) SaveItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer ds.lock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to save item")
+	}
+	path, err := itemFile(dir, itemID)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to save item")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return errors.Wrapf(datalayer.ErrConflict, "fs: unable to save item: %s", itemID)
+	}
+	if err = checkTrashed(dir, itemID); err != nil {
+		return errors.Wrap(err, "fs: unable to save item")
+	}
+	if err = checkSlug(dir, itemID, item); err != nil {
+		return errors.Wrap(err, "fs: unable to save item")
+	}
+
+	item["_id"] = itemID
+	item[datalayer.VersionField] = 1
+	return errors.Wrap(writeFileAtomic(path, item), "fs: unable to save item")
+}
+
+// checkTrashed returns ErrConflict if itemID is in the trash of the collection
+// in dir, so a new item can't take over the revisions of a trashed one.
+func checkTrashed(dir, itemID string) error {
+	if _, err := os.Stat(filepath.Join(dir, trashDir, itemID+fileExt)); err == nil {
+		return errors.Wrapf(datalayer.ErrConflict, "%s is in the trash", itemID)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (ds *Datastore) UpdateItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}, ifVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer ds.lock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to update item")
+	}
+	path, err := itemFile(dir, itemID)
+	if err != nil {
+		return errors.Wrap(datalayer.ErrNotFound, "fs: unable to update item")
+	}
+	stored := map[string]interface{}{}
+	if err = readFile(path, &stored); err != nil {
+		return errors.Wrap(err, "fs: unable to update item")
+	}
+	if err = datalayer.CheckVersion(stored, ifVersion); err != nil {
+		return errors.Wrap(err, "fs: unable to update item")
+	}
+	if err = checkSlug(dir, itemID, item); err != nil {
+		return errors.Wrap(err, "fs: unable to update item")
+	}
+
+	item["_id"] = itemID
+	item[datalayer.VersionField] = datalayer.VersionOf(stored) + 1
+	return errors.Wrap(writeFileAtomic(path, item), "fs: unable to update item")
+}
+
+func (ds *Datastore) UpsertItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) (created bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	defer ds.lock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return false, errors.Wrap(err, "fs: unable to upsert item")
+	}
+	path, err := itemFile(dir, itemID)
+	if err != nil {
+		return false, errors.Wrap(err, "fs: unable to upsert item")
+	}
+	stored := map[string]interface{}{}
+	err = readFile(path, &stored)
+	switch {
+	case err == datalayer.ErrNotFound:
+		if err = checkTrashed(dir, itemID); err != nil {
+			return false, errors.Wrap(err, "fs: unable to upsert item")
+		}
+		created = true
+		item[datalayer.VersionField] = 1
+	case err != nil:
+		return false, errors.Wrap(err, "fs: unable to upsert item")
+	default:
+		item[datalayer.VersionField] = datalayer.VersionOf(stored) + 1
+	}
+	if err = checkSlug(dir, itemID, item); err != nil {
+		return false, errors.Wrap(err, "fs: unable to upsert item")
+	}
+
+	item["_id"] = itemID
+	return created, errors.Wrap(writeFileAtomic(path, item), "fs: unable to upsert item")
+}
+
+func (ds *Datastore) GetItem(ctx context.Context, collectionName, itemID string) (item map[string]interface{}, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	defer ds.rlock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs: unable to get item")
+	}
+	path, err := itemFile(dir, itemID)
+	if err != nil {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "fs: unable to get item")
+	}
+	err = readFile(path, &item)
+	return item, errors.Wrap(err, "fs: unable to get item")
+}
+
+func (ds *Datastore) GetItemBySlug(ctx context.Context, collectionName, slug string) (item map[string]interface{}, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	defer ds.rlock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs: unable to get item by slug")
+	}
+	ids, err := itemIDs(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs: unable to get item by slug")
+	}
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		item := map[string]interface{}{}
+		if err = readFile(filepath.Join(dir, id+fileExt), &item); err != nil {
+			return nil, errors.Wrap(err, "fs: unable to get item by slug")
+		}
+		if item[datalayer.SlugField] == slug {
+			return item, nil
+		}
+	}
+	return nil, errors.Wrap(datalayer.ErrNotFound, "fs: unable to get item by slug")
+}
+
+// checkSlug returns ErrConflict if an item in dir other than itemID has the
+// slug of item. Callers hold the collection's write lock.
+func checkSlug(dir, itemID string, item map[string]interface{}) error {
+	slug := datalayer.SlugOf(item)
+	if slug == "" {
+		return nil
+	}
+	ids, err := itemIDs(dir)
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if id == itemID {
+			continue
+		}
+		stored := map[string]interface{}{}
+		if err = readFile(filepath.Join(dir, id+fileExt), &stored); err != nil {
+			return err
+		}
+		if datalayer.SlugOf(stored) == slug {
+			return errors.Wrapf(datalayer.ErrConflict, "slug %s", slug)
+		}
+	}
+	return nil
+}
+
+// itemIDs lists the IDs of every item stored in dir, sorted.
+func itemIDs(dir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ids := []string{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == schemaFile || name == metaFile || strings.HasPrefix(name, ".") || !strings.HasSuffix(name, fileExt) {
+			continue
+		}
+		ids = append(ids, strings.TrimSuffix(name, fileExt))
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+func (ds *Datastore) GetItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, respInfo, err
+	}
+
+	defer ds.rlock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "fs: unable to get items")
+	}
+	ids, err := itemIDs(dir)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "fs: unable to get items")
+	}
+
+	if len(queryMeta.Filter) > 0 || len(queryMeta.Sort) > 0 {
+		// Every item has to be read to count the matches and sort them.
+		matches := []map[string]interface{}{}
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return nil, respInfo, err
+			}
+			item := map[string]interface{}{}
+			if err = readFile(filepath.Join(dir, id+fileExt), &item); err != nil {
+				return nil, respInfo, errors.Wrap(err, "fs: unable to get items")
+			}
+			if queryMeta.Filter.Matches(item) {
+				matches = append(matches, item)
+			}
+		}
+		queryMeta.Sort.Apply(matches)
+		page := queryMeta.Resume(matches)
+		start, end := queryMeta.Window(len(page))
+		items = page[start:end]
+		for i := range items {
+			items[i] = queryMeta.Fields.Apply(items[i])
+		}
+		return items, queryMeta.ResponseInfo(len(matches), len(items)), nil
+	}
+
+	// Items come in _id order, so only the page after the cursor is read.
+	page := queryMeta.ResumeIDs(ids)
+	start, end := queryMeta.Window(len(page))
+	items = make([]map[string]interface{}, 0, end-start)
+	for _, id := range page[start:end] {
+		if err := ctx.Err(); err != nil {
+			return nil, respInfo, err
+		}
+		item := map[string]interface{}{}
+		if err = readFile(filepath.Join(dir, id+fileExt), &item); err != nil {
+			return nil, respInfo, errors.Wrap(err, "fs: unable to get items")
+		}
+		items = append(items, queryMeta.Fields.Apply(item))
+	}
+	return items, queryMeta.ResponseInfo(len(ids), len(items)), nil
+}
+
+func (ds *Datastore) Aggregate(ctx context.Context, collectionName string, aggregation datalayer.Aggregation) (groups []datalayer.Group, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	defer ds.rlock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs: unable to aggregate items")
+	}
+	ids, err := itemIDs(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "fs: unable to aggregate items")
+	}
+	aggregator := datalayer.NewAggregator(aggregation)
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		item := map[string]interface{}{}
+		if err = readFile(filepath.Join(dir, id+fileExt), &item); err != nil {
+			return nil, errors.Wrap(err, "fs: unable to aggregate items")
+		}
+		aggregator.Add(item)
+	}
+	return aggregator.Groups(), nil
+}
+
+func (ds *Datastore) DeleteItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer ds.lock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to delete item")
+	}
+	path, err := itemFile(dir, itemID)
+	if err != nil {
+		return errors.Wrap(datalayer.ErrNotFound, "fs: unable to delete item")
+	}
+	if ifVersion != 0 {
+		stored := map[string]interface{}{}
+		if err = readFile(path, &stored); err != nil {
+			return errors.Wrap(err, "fs: unable to delete item")
+		}
+		if err = datalayer.CheckVersion(stored, ifVersion); err != nil {
+			return errors.Wrap(err, "fs: unable to delete item")
+		}
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return errors.Wrap(datalayer.ErrNotFound, "fs: unable to delete item")
+	}
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to delete item")
+	}
+	return errors.Wrap(os.RemoveAll(filepath.Join(dir, revisionsDir, itemID)), "fs: unable to delete item")
+}
+
+func (ds *Datastore) TrashItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer ds.lock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to trash item")
+	}
+	path, err := itemFile(dir, itemID)
+	if err != nil {
+		return errors.Wrap(datalayer.ErrNotFound, "fs: unable to trash item")
+	}
+	item := map[string]interface{}{}
+	if err = readFile(path, &item); err != nil {
+		return errors.Wrap(err, "fs: unable to trash item")
+	}
+	if err = datalayer.CheckVersion(item, ifVersion); err != nil {
+		return errors.Wrap(err, "fs: unable to trash item")
+	}
+
+	trash := filepath.Join(dir, trashDir)
+	if err = os.MkdirAll(trash, 0755); err != nil {
+		return errors.Wrap(err, "fs: unable to trash item")
+	}
+	item[datalayer.DeletedAtField] = datalayer.DeletedAt()
+	if err = writeFileAtomic(filepath.Join(trash, filepath.Base(path)), item); err != nil {
+		return errors.Wrap(err, "fs: unable to trash item")
+	}
+	return errors.Wrap(os.Remove(path), "fs: unable to trash item")
+}
+
+func (ds *Datastore) RestoreItem(ctx context.Context, collectionName, itemID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer ds.lock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to restore item")
+	}
+	path, err := itemFile(dir, itemID)
+	if err != nil {
+		return errors.Wrap(datalayer.ErrNotFound, "fs: unable to restore item")
+	}
+	trashed := filepath.Join(dir, trashDir, filepath.Base(path))
+	item := map[string]interface{}{}
+	if err = readFile(trashed, &item); err != nil {
+		return errors.Wrap(err, "fs: unable to restore item")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return errors.Wrapf(datalayer.ErrConflict, "fs: unable to restore item: %s", itemID)
+	}
+	if err = checkSlug(dir, itemID, item); err != nil {
+		return errors.Wrap(err, "fs: unable to restore item")
+	}
+
+	delete(item, datalayer.DeletedAtField)
+	if err = writeFileAtomic(path, item); err != nil {
+		return errors.Wrap(err, "fs: unable to restore item")
+	}
+	return errors.Wrap(os.Remove(trashed), "fs: unable to restore item")
+}
+
+func (ds *Datastore) PurgeItem(ctx context.Context, collectionName, itemID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer ds.lock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to purge item")
+	}
+	path, err := itemFile(filepath.Join(dir, trashDir), itemID)
+	if err != nil {
+		return errors.Wrap(datalayer.ErrNotFound, "fs: unable to purge item")
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return errors.Wrap(datalayer.ErrNotFound, "fs: unable to purge item")
+	}
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to purge item")
+	}
+	return errors.Wrap(os.RemoveAll(filepath.Join(dir, revisionsDir, itemID)), "fs: unable to purge item")
+}
+
+func (ds *Datastore) GetTrashedItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, respInfo, err
+	}
+
+	defer ds.rlock(collectionName)()
+
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "fs: unable to get trashed items")
+	}
+	trash := filepath.Join(dir, trashDir)
+	ids, err := itemIDs(trash)
+	if os.IsNotExist(err) {
+		ids, err = []string{}, nil
+	}
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "fs: unable to get trashed items")
+	}
+
+	start, end := queryMeta.Window(len(ids))
+	items = make([]map[string]interface{}, 0, end-start)
+	for _, id := range ids[start:end] {
+		item := map[string]interface{}{}
+		if err = readFile(filepath.Join(trash, id+fileExt), &item); err != nil {
+			return nil, respInfo, errors.Wrap(err, "fs: unable to get trashed items")
+		}
+		items = append(items, item)
+	}
+	return items, queryMeta.ResponseInfo(len(ids), len(items)), nil
+}
+
+// revisionNumbers lists the revisions stored in dir, oldest first.
+func revisionNumbers(dir string) ([]int, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return []int{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	numbers := []int{}
+	for _, entry := range entries {
+		if n, err := strconv.Atoi(strings.TrimSuffix(entry.Name(), fileExt)); err == nil {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// revisionsPath returns the directory holding an item's revisions.
+func (ds *Datastore) revisionsPath(collectionName, itemID string) (string, error) {
+	dir, err := ds.collectionDir(collectionName)
+	if err != nil {
+		return "", err
+	}
+	if err = validName(itemID); err != nil {
+		return "", datalayer.ErrNotFound
+	}
+	return filepath.Join(dir, revisionsDir, itemID), nil
+}
+
+func (ds *Datastore) SaveRevision(ctx context.Context, collectionName, itemID string, revision datalayer.Revision) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	defer ds.lock(collectionName)()
+
+	dir, err := ds.revisionsPath(collectionName, itemID)
+	if err != nil {
+		return errors.Wrap(err, "fs: unable to save revision")
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "fs: unable to save revision")
+	}
+	path := filepath.Join(dir, strconv.Itoa(revision.Revision)+fileExt)
+	if _, err := os.Stat(path); err == nil {
+		return errors.Wrapf(datalayer.ErrConflict, "fs: unable to save revision: %d", revision.Revision)
+	}
+	return errors.Wrap(writeFileAtomic(path, revision), "fs: unable to save revision")
+}
+
+func (ds *Datastore) GetRevisions(ctx context.Context, collectionName, itemID string, queryMeta datalayer.QueryMeta) (revisions []datalayer.Revision, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, respInfo, err
+	}
+
+	defer ds.rlock(collectionName)()
+
+	dir, err := ds.revisionsPath(collectionName, itemID)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "fs: unable to get revisions")
+	}
+	numbers, err := revisionNumbers(dir)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "fs: unable to get revisions")
+	}
+
+	start, end := queryMeta.Window(len(numbers))
+	revisions = make([]datalayer.Revision, 0, end-start)
+	for _, n := range numbers[start:end] {
+		revision := datalayer.Revision{}
+		if err = readFile(filepath.Join(dir, strconv.Itoa(n)+fileExt), &revision); err != nil {
+			return nil, respInfo, errors.Wrap(err, "fs: unable to get revisions")
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, queryMeta.ResponseInfo(len(numbers), len(revisions)), nil
+}
+
+func (ds *Datastore) GetRevision(ctx context.Context, collectionName, itemID string, number int) (revision datalayer.Revision, err error) {
+	if err := ctx.Err(); err != nil {
+		return revision, err
+	}
+
+	defer ds.rlock(collectionName)()
+
+	dir, err := ds.revisionsPath(collectionName, itemID)
+	if err != nil {
+		return revision, errors.Wrap(err, "fs: unable to get revision")
+	}
+	err = readFile(filepath.Join(dir, strconv.Itoa(number)+fileExt), &revision)
+	return revision, errors.Wrap(err, "fs: unable to get revision")
+}
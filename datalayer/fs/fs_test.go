@@ -0,0 +1,54 @@
+package fs
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/tonyalaribe/ninja/datalayer/datastoretest"
+)
+
+func newDatastore(t *testing.T) *Datastore {
+	dir, err := ioutil.TempDir("", "ninja-fs")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	ds, err := NewDatastore(datalayer.DBConfig{ConnectionString: dir})
+	if err != nil {
+		t.Fatalf("unable to create datastore: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return ds
+}
+
+func TestConformance(t *testing.T) {
+	datastoretest.RunConformance(t, func(t *testing.T) datalayer.DataStore {
+		return newDatastore(t)
+	})
+}
+
+func TestLocksReleased(t *testing.T) {
+	ds := newDatastore(t)
+	ctx := context.Background()
+	if err := ds.CreateCollection(ctx, "posts", map[string]interface{}{"type": "object"}, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	if err := ds.SaveItem(ctx, "posts", "a", map[string]interface{}{}); err != nil {
+		t.Fatalf("SaveItem failed: %v", err)
+	}
+	for _, name := range []string{"missing", "../escape", ".hidden"} {
+		ds.GetItem(ctx, name, "a")
+		ds.SaveItem(ctx, name, "a", map[string]interface{}{})
+	}
+	if err := ds.RenameCollection(ctx, "posts", "articles"); err != nil {
+		t.Fatalf("RenameCollection failed: %v", err)
+	}
+	if err := ds.DeleteCollection(ctx, "articles"); err != nil {
+		t.Fatalf("DeleteCollection failed: %v", err)
+	}
+	if len(ds.locks) != 0 {
+		t.Errorf("got locks %v, want none once every collection is unlocked", ds.locks)
+	}
+}
@@ -0,0 +1,637 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// Datastore keeps collections and their items in process memory. Nothing is
+// persisted, which makes it suitable for local development, demos and tests.
+type Datastore struct {
+	mu          sync.RWMutex
+	collections map[string]*collection
+	order       []string
+}
+
+type collection struct {
+	schemas  []map[string]interface{} // every schema version, oldest first
+	metadata map[string]interface{}
+	items    map[string]map[string]interface{}
+	order    []string // item IDs, sorted, as listings without a sort need
+
+	trash      map[string]map[string]interface{}
+	trashOrder []string
+
+	revisions map[string][]datalayer.Revision
+}
+
+const DriverName = "memory"
+
+func init() {
+	datalayer.Register(DriverName, &Datastore{})
+}
+
+func NewDatastore(config datalayer.DBConfig) (*Datastore, error) {
+	ds := Datastore{}
+	ds.collections = make(map[string]*collection)
+	return &ds, nil
+}
+
+func (ds *Datastore) Connect(config datalayer.DBConfig) (datalayer.DataStore, error) {
+	return NewDatastore(config)
+}
+
+func (ds *Datastore) CreateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.collections[name]; ok {
+		return errors.Wrapf(datalayer.ErrConflict, "memory: unable to create collection: %s", name)
+	}
+	ds.collections[name] = &collection{
+		schemas:   []map[string]interface{}{copyMap(schema)},
+		metadata:  copyMap(metadata),
+		items:     make(map[string]map[string]interface{}),
+		trash:     make(map[string]map[string]interface{}),
+		revisions: make(map[string][]datalayer.Revision),
+	}
+	ds.order = append(ds.order, name)
+	return nil
+}
+
+func (ds *Datastore) GetCollections(ctx context.Context) (collections []datalayer.CollectionVM, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	for _, name := range ds.order {
+		collections = append(collections, ds.collections[name].vm(name))
+	}
+	return collections, nil
+}
+
+func (c *collection) vm(name string) datalayer.CollectionVM {
+	return datalayer.CollectionVM{
+		Name:    name,
+		Schema:  copyMap(c.schema()),
+		Meta:    copyMap(c.metadata),
+		Version: len(c.schemas),
+	}
+}
+
+func (c *collection) schema() map[string]interface{} {
+	return c.schemas[len(c.schemas)-1]
+}
+
+// slugTaken reports whether an item other than itemID has the slug of item.
+func (c *collection) slugTaken(itemID string, item map[string]interface{}) bool {
+	slug := datalayer.SlugOf(item)
+	if slug == "" {
+		return false
+	}
+	for id, stored := range c.items {
+		if id != itemID && datalayer.SlugOf(stored) == slug {
+			return true
+		}
+	}
+	return false
+}
+
+func (ds *Datastore) GetCollection(ctx context.Context, name string) (collection datalayer.CollectionVM, err error) {
+	if err := ctx.Err(); err != nil {
+		return collection, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[name]
+	if !ok {
+		return collection, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get collection")
+	}
+	return c.vm(name), nil
+}
+
+func (ds *Datastore) GetSchemaVersions(ctx context.Context, name string) (versions []datalayer.SchemaVersion, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[name]
+	if !ok {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get schema versions")
+	}
+	for i, schema := range c.schemas {
+		versions = append(versions, datalayer.SchemaVersion{Version: i + 1, Schema: copyMap(schema)})
+	}
+	return versions, nil
+}
+
+func (ds *Datastore) UpdateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[name]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to update collection")
+	}
+	if schema != nil {
+		c.schemas = append(c.schemas, copyMap(schema))
+	}
+	if metadata != nil {
+		c.metadata = copyMap(metadata)
+	}
+	return nil
+}
+
+func (ds *Datastore) RenameCollection(ctx context.Context, name, newName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[name]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to rename collection")
+	}
+	if _, dup := ds.collections[newName]; dup {
+		return errors.Wrapf(datalayer.ErrConflict, "memory: unable to rename collection: %s", newName)
+	}
+	delete(ds.collections, name)
+	ds.collections[newName] = c
+	for i, n := range ds.order {
+		if n == name {
+			ds.order[i] = newName
+		}
+	}
+	return nil
+}
+
+func (ds *Datastore) DeleteCollection(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if _, ok := ds.collections[name]; !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to delete collection")
+	}
+	delete(ds.collections, name)
+	ds.order = remove(ds.order, name)
+	return nil
+}
+
+func (ds *Datastore) GetSchema(ctx context.Context, collectionName string) (map[string]interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get schema")
+	}
+	return copyMap(c.schema()), nil
+}
+
+func (ds *Datastore) SaveItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to save item")
+	}
+	if _, dup := c.items[itemID]; dup {
+		return errors.Wrapf(datalayer.ErrConflict, "memory: unable to save item: %s", itemID)
+	}
+	if _, trashed := c.trash[itemID]; trashed {
+		return errors.Wrapf(datalayer.ErrConflict, "memory: unable to save item: %s is in the trash", itemID)
+	}
+	if c.slugTaken(itemID, item) {
+		return errors.Wrapf(datalayer.ErrConflict, "memory: unable to save item: slug %s", datalayer.SlugOf(item))
+	}
+	item["_id"] = itemID
+	item[datalayer.VersionField] = 1
+	c.items[itemID] = copyMap(item)
+	c.order = insertSorted(c.order, itemID)
+	return nil
+}
+
+func (ds *Datastore) UpdateItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}, ifVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to update item")
+	}
+	stored, ok := c.items[itemID]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to update item")
+	}
+	if err := datalayer.CheckVersion(stored, ifVersion); err != nil {
+		return errors.Wrap(err, "memory: unable to update item")
+	}
+	if c.slugTaken(itemID, item) {
+		return errors.Wrapf(datalayer.ErrConflict, "memory: unable to update item: slug %s", datalayer.SlugOf(item))
+	}
+	item["_id"] = itemID
+	item[datalayer.VersionField] = datalayer.VersionOf(stored) + 1
+	c.items[itemID] = copyMap(item)
+	return nil
+}
+
+func (ds *Datastore) UpsertItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) (created bool, err error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return false, errors.Wrap(datalayer.ErrNotFound, "memory: unable to upsert item")
+	}
+	if _, trashed := c.trash[itemID]; trashed {
+		return false, errors.Wrapf(datalayer.ErrConflict, "memory: unable to upsert item: %s is in the trash", itemID)
+	}
+	if c.slugTaken(itemID, item) {
+		return false, errors.Wrapf(datalayer.ErrConflict, "memory: unable to upsert item: slug %s", datalayer.SlugOf(item))
+	}
+	item["_id"] = itemID
+	if stored, ok := c.items[itemID]; ok {
+		item[datalayer.VersionField] = datalayer.VersionOf(stored) + 1
+	} else {
+		item[datalayer.VersionField] = 1
+		c.order = insertSorted(c.order, itemID)
+		created = true
+	}
+	c.items[itemID] = copyMap(item)
+	return created, nil
+}
+
+func (ds *Datastore) GetItem(ctx context.Context, collectionName, itemID string) (item map[string]interface{}, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get item")
+	}
+	item, ok = c.items[itemID]
+	if !ok {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get item")
+	}
+	return copyMap(item), nil
+}
+
+func (ds *Datastore) GetItemBySlug(ctx context.Context, collectionName, slug string) (item map[string]interface{}, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get item by slug")
+	}
+	for _, id := range c.order {
+		if item := c.items[id]; item[datalayer.SlugField] == slug {
+			return copyMap(item), nil
+		}
+	}
+	return nil, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get item by slug")
+}
+
+func (ds *Datastore) GetItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, respInfo, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return nil, respInfo, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get items")
+	}
+
+	if len(queryMeta.Filter) == 0 && len(queryMeta.Sort) == 0 {
+		// Items are kept in _id order, so a cursor page seeks right past the
+		// cursor's item.
+		page := queryMeta.ResumeIDs(c.order)
+		start, end := queryMeta.Window(len(page))
+		items = make([]map[string]interface{}, 0, end-start)
+		for _, id := range page[start:end] {
+			items = append(items, copyMap(queryMeta.Fields.Apply(c.items[id])))
+		}
+		return items, queryMeta.ResponseInfo(len(c.order), len(items)), nil
+	}
+
+	// Every item has to be read to count the matches and sort them. They are
+	// read in _id order, which a sort keeps for ties.
+	matches := make([]map[string]interface{}, 0, len(c.order))
+	for _, id := range c.order {
+		if queryMeta.Filter.Matches(c.items[id]) {
+			matches = append(matches, c.items[id])
+		}
+	}
+	queryMeta.Sort.Apply(matches)
+
+	page := queryMeta.Resume(matches)
+	start, end := queryMeta.Window(len(page))
+	items = make([]map[string]interface{}, 0, end-start)
+	for _, item := range page[start:end] {
+		items = append(items, copyMap(queryMeta.Fields.Apply(item)))
+	}
+	return items, queryMeta.ResponseInfo(len(matches), len(items)), nil
+}
+
+func (ds *Datastore) Aggregate(ctx context.Context, collectionName string, aggregation datalayer.Aggregation) (groups []datalayer.Group, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "memory: unable to aggregate items")
+	}
+
+	aggregator := datalayer.NewAggregator(aggregation)
+	for _, id := range c.order {
+		aggregator.Add(c.items[id])
+	}
+	groups = aggregator.Groups()
+	for i := range groups {
+		groups[i].Key = copyMap(groups[i].Key)
+		groups[i].Metrics = copyMap(groups[i].Metrics)
+	}
+	return groups, nil
+}
+
+func (ds *Datastore) DeleteItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to delete item")
+	}
+	stored, ok := c.items[itemID]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to delete item")
+	}
+	if err := datalayer.CheckVersion(stored, ifVersion); err != nil {
+		return errors.Wrap(err, "memory: unable to delete item")
+	}
+	delete(c.items, itemID)
+	c.order = remove(c.order, itemID)
+	delete(c.revisions, itemID)
+	return nil
+}
+
+func (ds *Datastore) TrashItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to trash item")
+	}
+	item, ok := c.items[itemID]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to trash item")
+	}
+	if err := datalayer.CheckVersion(item, ifVersion); err != nil {
+		return errors.Wrap(err, "memory: unable to trash item")
+	}
+	delete(c.items, itemID)
+	c.order = remove(c.order, itemID)
+
+	item[datalayer.DeletedAtField] = datalayer.DeletedAt()
+	if _, ok := c.trash[itemID]; ok {
+		c.trashOrder = remove(c.trashOrder, itemID)
+	}
+	c.trash[itemID] = item
+	c.trashOrder = append(c.trashOrder, itemID)
+	return nil
+}
+
+func (ds *Datastore) RestoreItem(ctx context.Context, collectionName, itemID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to restore item")
+	}
+	item, ok := c.trash[itemID]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to restore item")
+	}
+	if _, dup := c.items[itemID]; dup {
+		return errors.Wrapf(datalayer.ErrConflict, "memory: unable to restore item: %s", itemID)
+	}
+	if c.slugTaken(itemID, item) {
+		return errors.Wrapf(datalayer.ErrConflict, "memory: unable to restore item: slug %s", datalayer.SlugOf(item))
+	}
+	delete(c.trash, itemID)
+	c.trashOrder = remove(c.trashOrder, itemID)
+
+	delete(item, datalayer.DeletedAtField)
+	c.items[itemID] = item
+	c.order = insertSorted(c.order, itemID)
+	return nil
+}
+
+func (ds *Datastore) PurgeItem(ctx context.Context, collectionName, itemID string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to purge item")
+	}
+	if _, ok := c.trash[itemID]; !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to purge item")
+	}
+	delete(c.trash, itemID)
+	c.trashOrder = remove(c.trashOrder, itemID)
+	delete(c.revisions, itemID)
+	return nil
+}
+
+func (ds *Datastore) GetTrashedItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, respInfo, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return nil, respInfo, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get trashed items")
+	}
+
+	start, end := queryMeta.Window(len(c.trashOrder))
+	items = make([]map[string]interface{}, 0, end-start)
+	for _, id := range c.trashOrder[start:end] {
+		items = append(items, copyMap(c.trash[id]))
+	}
+	return items, queryMeta.ResponseInfo(len(c.trashOrder), len(items)), nil
+}
+
+// SaveRevision keeps an item's revisions sorted by number, since writers
+// racing each other may save them out of order.
+func (ds *Datastore) SaveRevision(ctx context.Context, collectionName, itemID string, revision datalayer.Revision) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return errors.Wrap(datalayer.ErrNotFound, "memory: unable to save revision")
+	}
+	revision.Item = copyMap(revision.Item)
+	stored := c.revisions[itemID]
+	i := sort.Search(len(stored), func(i int) bool { return stored[i].Revision >= revision.Revision })
+	if i < len(stored) && stored[i].Revision == revision.Revision {
+		return errors.Wrapf(datalayer.ErrConflict, "memory: unable to save revision: %d", revision.Revision)
+	}
+	stored = append(stored, datalayer.Revision{})
+	copy(stored[i+1:], stored[i:])
+	stored[i] = revision
+	c.revisions[itemID] = stored
+	return nil
+}
+
+func (ds *Datastore) GetRevisions(ctx context.Context, collectionName, itemID string, queryMeta datalayer.QueryMeta) (revisions []datalayer.Revision, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, respInfo, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return nil, respInfo, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get revisions")
+	}
+
+	stored := c.revisions[itemID]
+	start, end := queryMeta.Window(len(stored))
+	revisions = make([]datalayer.Revision, 0, end-start)
+	for _, revision := range stored[start:end] {
+		revision.Item = copyMap(revision.Item)
+		revisions = append(revisions, revision)
+	}
+	return revisions, queryMeta.ResponseInfo(len(stored), len(revisions)), nil
+}
+
+func (ds *Datastore) GetRevision(ctx context.Context, collectionName, itemID string, number int) (revision datalayer.Revision, err error) {
+	if err := ctx.Err(); err != nil {
+		return revision, err
+	}
+
+	ds.mu.RLock()
+	defer ds.mu.RUnlock()
+	c, ok := ds.collections[collectionName]
+	if !ok {
+		return revision, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get revision")
+	}
+	for _, revision := range c.revisions[itemID] {
+		if revision.Revision == number {
+			revision.Item = copyMap(revision.Item)
+			return revision, nil
+		}
+	}
+	return revision, errors.Wrap(datalayer.ErrNotFound, "memory: unable to get revision")
+}
+
+// remove returns ids without id, preserving order.
+// insertSorted adds id to the sorted ids.
+func insertSorted(ids []string, id string) []string {
+	i := sort.SearchStrings(ids, id)
+	ids = append(ids, "")
+	copy(ids[i+1:], ids[i:])
+	ids[i] = id
+	return ids
+}
+
+func remove(ids []string, id string) []string {
+	for i, v := range ids {
+		if v == id {
+			return append(ids[:i:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
+
+// copyMap deep copies JSON-like values so callers can never mutate stored
+// documents through a map they passed in or received.
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = copyValue(v)
+	}
+	return out
+}
+
+func copyValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		return copyMap(vv)
+	case []interface{}:
+		out := make([]interface{}, len(vv))
+		for i, e := range vv {
+			out[i] = copyValue(e)
+		}
+		return out
+	default:
+		return v
+	}
+}
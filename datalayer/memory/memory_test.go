@@ -0,0 +1,18 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/tonyalaribe/ninja/datalayer/datastoretest"
+)
+
+func TestConformance(t *testing.T) {
+	datastoretest.RunConformance(t, func(t *testing.T) datalayer.DataStore {
+		ds, err := NewDatastore(datalayer.DBConfig{})
+		if err != nil {
+			t.Fatalf("unable to create datastore: %v", err)
+		}
+		return ds
+	})
+}
@@ -34,6 +34,19 @@ func (m *MockDataStore) EXPECT() *MockDataStoreMockRecorder {
 	return m.recorder
 }
 
+// Aggregate mocks base method
+func (m *MockDataStore) Aggregate(arg0 context.Context, arg1 string, arg2 datalayer.Aggregation) ([]datalayer.Group, error) {
+	ret := m.ctrl.Call(m, "Aggregate", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]datalayer.Group)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Aggregate indicates an expected call of Aggregate
+func (mr *MockDataStoreMockRecorder) Aggregate(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Aggregate", reflect.TypeOf((*MockDataStore)(nil).Aggregate), arg0, arg1, arg2)
+}
+
 // Connect mocks base method
 func (m *MockDataStore) Connect(arg0 datalayer.DBConfig) (datalayer.DataStore, error) {
 	ret := m.ctrl.Call(m, "Connect", arg0)
@@ -59,6 +72,43 @@ func (mr *MockDataStoreMockRecorder) CreateCollection(arg0, arg1, arg2, arg3 int
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateCollection", reflect.TypeOf((*MockDataStore)(nil).CreateCollection), arg0, arg1, arg2, arg3)
 }
 
+// DeleteCollection mocks base method
+func (m *MockDataStore) DeleteCollection(arg0 context.Context, arg1 string) error {
+	ret := m.ctrl.Call(m, "DeleteCollection", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteCollection indicates an expected call of DeleteCollection
+func (mr *MockDataStoreMockRecorder) DeleteCollection(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteCollection", reflect.TypeOf((*MockDataStore)(nil).DeleteCollection), arg0, arg1)
+}
+
+// DeleteItem mocks base method
+func (m *MockDataStore) DeleteItem(arg0 context.Context, arg1, arg2 string, arg3 int) error {
+	ret := m.ctrl.Call(m, "DeleteItem", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteItem indicates an expected call of DeleteItem
+func (mr *MockDataStoreMockRecorder) DeleteItem(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteItem", reflect.TypeOf((*MockDataStore)(nil).DeleteItem), arg0, arg1, arg2, arg3)
+}
+
+// GetCollection mocks base method
+func (m *MockDataStore) GetCollection(arg0 context.Context, arg1 string) (datalayer.CollectionVM, error) {
+	ret := m.ctrl.Call(m, "GetCollection", arg0, arg1)
+	ret0, _ := ret[0].(datalayer.CollectionVM)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetCollection indicates an expected call of GetCollection
+func (mr *MockDataStoreMockRecorder) GetCollection(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetCollection", reflect.TypeOf((*MockDataStore)(nil).GetCollection), arg0, arg1)
+}
+
 // GetCollections mocks base method
 func (m *MockDataStore) GetCollections(arg0 context.Context) ([]datalayer.CollectionVM, error) {
 	ret := m.ctrl.Call(m, "GetCollections", arg0)
@@ -85,6 +135,19 @@ func (mr *MockDataStoreMockRecorder) GetItem(arg0, arg1, arg2 interface{}) *gomo
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetItem", reflect.TypeOf((*MockDataStore)(nil).GetItem), arg0, arg1, arg2)
 }
 
+// GetItemBySlug mocks base method
+func (m *MockDataStore) GetItemBySlug(arg0 context.Context, arg1, arg2 string) (map[string]interface{}, error) {
+	ret := m.ctrl.Call(m, "GetItemBySlug", arg0, arg1, arg2)
+	ret0, _ := ret[0].(map[string]interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetItemBySlug indicates an expected call of GetItemBySlug
+func (mr *MockDataStoreMockRecorder) GetItemBySlug(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetItemBySlug", reflect.TypeOf((*MockDataStore)(nil).GetItemBySlug), arg0, arg1, arg2)
+}
+
 // GetItems mocks base method
 func (m *MockDataStore) GetItems(arg0 context.Context, arg1 string, arg2 datalayer.QueryMeta) ([]map[string]interface{}, datalayer.ItemsResponseInfo, error) {
 	ret := m.ctrl.Call(m, "GetItems", arg0, arg1, arg2)
@@ -99,6 +162,33 @@ func (mr *MockDataStoreMockRecorder) GetItems(arg0, arg1, arg2 interface{}) *gom
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetItems", reflect.TypeOf((*MockDataStore)(nil).GetItems), arg0, arg1, arg2)
 }
 
+// GetRevision mocks base method
+func (m *MockDataStore) GetRevision(arg0 context.Context, arg1, arg2 string, arg3 int) (datalayer.Revision, error) {
+	ret := m.ctrl.Call(m, "GetRevision", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(datalayer.Revision)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRevision indicates an expected call of GetRevision
+func (mr *MockDataStoreMockRecorder) GetRevision(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRevision", reflect.TypeOf((*MockDataStore)(nil).GetRevision), arg0, arg1, arg2, arg3)
+}
+
+// GetRevisions mocks base method
+func (m *MockDataStore) GetRevisions(arg0 context.Context, arg1, arg2 string, arg3 datalayer.QueryMeta) ([]datalayer.Revision, datalayer.ItemsResponseInfo, error) {
+	ret := m.ctrl.Call(m, "GetRevisions", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]datalayer.Revision)
+	ret1, _ := ret[1].(datalayer.ItemsResponseInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetRevisions indicates an expected call of GetRevisions
+func (mr *MockDataStoreMockRecorder) GetRevisions(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRevisions", reflect.TypeOf((*MockDataStore)(nil).GetRevisions), arg0, arg1, arg2, arg3)
+}
+
 // GetSchema mocks base method
 func (m *MockDataStore) GetSchema(arg0 context.Context, arg1 string) (map[string]interface{}, error) {
 	ret := m.ctrl.Call(m, "GetSchema", arg0, arg1)
@@ -112,6 +202,69 @@ func (mr *MockDataStoreMockRecorder) GetSchema(arg0, arg1 interface{}) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSchema", reflect.TypeOf((*MockDataStore)(nil).GetSchema), arg0, arg1)
 }
 
+// GetSchemaVersions mocks base method
+func (m *MockDataStore) GetSchemaVersions(arg0 context.Context, arg1 string) ([]datalayer.SchemaVersion, error) {
+	ret := m.ctrl.Call(m, "GetSchemaVersions", arg0, arg1)
+	ret0, _ := ret[0].([]datalayer.SchemaVersion)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSchemaVersions indicates an expected call of GetSchemaVersions
+func (mr *MockDataStoreMockRecorder) GetSchemaVersions(arg0, arg1 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSchemaVersions", reflect.TypeOf((*MockDataStore)(nil).GetSchemaVersions), arg0, arg1)
+}
+
+// GetTrashedItems mocks base method
+func (m *MockDataStore) GetTrashedItems(arg0 context.Context, arg1 string, arg2 datalayer.QueryMeta) ([]map[string]interface{}, datalayer.ItemsResponseInfo, error) {
+	ret := m.ctrl.Call(m, "GetTrashedItems", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]map[string]interface{})
+	ret1, _ := ret[1].(datalayer.ItemsResponseInfo)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// GetTrashedItems indicates an expected call of GetTrashedItems
+func (mr *MockDataStoreMockRecorder) GetTrashedItems(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTrashedItems", reflect.TypeOf((*MockDataStore)(nil).GetTrashedItems), arg0, arg1, arg2)
+}
+
+// PurgeItem mocks base method
+func (m *MockDataStore) PurgeItem(arg0 context.Context, arg1, arg2 string) error {
+	ret := m.ctrl.Call(m, "PurgeItem", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// PurgeItem indicates an expected call of PurgeItem
+func (mr *MockDataStoreMockRecorder) PurgeItem(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PurgeItem", reflect.TypeOf((*MockDataStore)(nil).PurgeItem), arg0, arg1, arg2)
+}
+
+// RenameCollection mocks base method
+func (m *MockDataStore) RenameCollection(arg0 context.Context, arg1, arg2 string) error {
+	ret := m.ctrl.Call(m, "RenameCollection", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RenameCollection indicates an expected call of RenameCollection
+func (mr *MockDataStoreMockRecorder) RenameCollection(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RenameCollection", reflect.TypeOf((*MockDataStore)(nil).RenameCollection), arg0, arg1, arg2)
+}
+
+// RestoreItem mocks base method
+func (m *MockDataStore) RestoreItem(arg0 context.Context, arg1, arg2 string) error {
+	ret := m.ctrl.Call(m, "RestoreItem", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RestoreItem indicates an expected call of RestoreItem
+func (mr *MockDataStoreMockRecorder) RestoreItem(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RestoreItem", reflect.TypeOf((*MockDataStore)(nil).RestoreItem), arg0, arg1, arg2)
+}
+
 // SaveItem mocks base method
 func (m *MockDataStore) SaveItem(arg0 context.Context, arg1, arg2 string, arg3 map[string]interface{}) error {
 	ret := m.ctrl.Call(m, "SaveItem", arg0, arg1, arg2, arg3)
@@ -123,3 +276,64 @@ func (m *MockDataStore) SaveItem(arg0 context.Context, arg1, arg2 string, arg3 m
 func (mr *MockDataStoreMockRecorder) SaveItem(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveItem", reflect.TypeOf((*MockDataStore)(nil).SaveItem), arg0, arg1, arg2, arg3)
 }
+
+// SaveRevision mocks base method
+func (m *MockDataStore) SaveRevision(arg0 context.Context, arg1, arg2 string, arg3 datalayer.Revision) error {
+	ret := m.ctrl.Call(m, "SaveRevision", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SaveRevision indicates an expected call of SaveRevision
+func (mr *MockDataStoreMockRecorder) SaveRevision(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveRevision", reflect.TypeOf((*MockDataStore)(nil).SaveRevision), arg0, arg1, arg2, arg3)
+}
+
+// TrashItem mocks base method
+func (m *MockDataStore) TrashItem(arg0 context.Context, arg1, arg2 string, arg3 int) error {
+	ret := m.ctrl.Call(m, "TrashItem", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TrashItem indicates an expected call of TrashItem
+func (mr *MockDataStoreMockRecorder) TrashItem(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TrashItem", reflect.TypeOf((*MockDataStore)(nil).TrashItem), arg0, arg1, arg2, arg3)
+}
+
+// UpdateCollection mocks base method
+func (m *MockDataStore) UpdateCollection(arg0 context.Context, arg1 string, arg2, arg3 map[string]interface{}) error {
+	ret := m.ctrl.Call(m, "UpdateCollection", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateCollection indicates an expected call of UpdateCollection
+func (mr *MockDataStoreMockRecorder) UpdateCollection(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateCollection", reflect.TypeOf((*MockDataStore)(nil).UpdateCollection), arg0, arg1, arg2, arg3)
+}
+
+// UpdateItem mocks base method
+func (m *MockDataStore) UpdateItem(arg0 context.Context, arg1, arg2 string, arg3 map[string]interface{}, arg4 int) error {
+	ret := m.ctrl.Call(m, "UpdateItem", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateItem indicates an expected call of UpdateItem
+func (mr *MockDataStoreMockRecorder) UpdateItem(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateItem", reflect.TypeOf((*MockDataStore)(nil).UpdateItem), arg0, arg1, arg2, arg3, arg4)
+}
+
+// UpsertItem mocks base method
+func (m *MockDataStore) UpsertItem(arg0 context.Context, arg1, arg2 string, arg3 map[string]interface{}) (bool, error) {
+	ret := m.ctrl.Call(m, "UpsertItem", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpsertItem indicates an expected call of UpsertItem
+func (mr *MockDataStoreMockRecorder) UpsertItem(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpsertItem", reflect.TypeOf((*MockDataStore)(nil).UpsertItem), arg0, arg1, arg2, arg3)
+}
@@ -2,18 +2,46 @@ package mongodb
 
 import (
 	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/globalsign/mgo"
 	"github.com/pkg/errors"
 	"github.com/tonyalaribe/ninja/datalayer"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
 )
 
+// Datastore talks to MongoDB through the official driver, so the context
+// passed to every method cancels the operation on the server as well. Every
+// operation checks a connection out of the client's pool, so concurrent
+// requests never share a socket, and the driver's server monitor replaces
+// connections that drop.
 type Datastore struct {
-	DB               *mgo.Database
+	Client           *mongo.Client
+	DB               *mongo.Database
 	SchemaCollection string
 }
 
-const DriverName = "mongodb"
+const (
+	DriverName = "mongodb"
+
+	trashSuffix     = "_trash"
+	revisionsSuffix = "_revisions"
+
+	// namespaceNotFound is the server error code for a missing collection.
+	namespaceNotFound = 26
+
+	defaultConnectTimeout = 30 * time.Second
+	maxConnectBackoff     = 5 * time.Second
+)
 
 func init() {
 	datalayer.Register(DriverName, &Datastore{})
@@ -21,12 +49,34 @@ func init() {
 
 func NewDatastore(config datalayer.DBConfig) (*Datastore, error) {
 	ds := Datastore{}
-	session, err := mgo.Dial(config.ConnectionString)
+	clientOptions, err := clientOptions(config)
 	if err != nil {
 		return nil, err
 	}
-	ds.DB = session.DB(config.DatabaseName)
+
+	connectTimeout := config.ConnectTimeout
+	if connectTimeout <= 0 {
+		connectTimeout = defaultConnectTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), connectTimeout)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, errors.Wrap(err, "mongoDB: unable to connect")
+	}
+	if err = waitForServer(ctx, client); err != nil {
+		client.Disconnect(context.Background())
+		return nil, errors.Wrap(err, "mongoDB: unable to reach server")
+	}
+
+	ds.Client = client
+	ds.DB = client.Database(config.DatabaseName)
 	ds.SchemaCollection = config.SchemaCollectionName
+	if err = ds.indexAllSlugs(ctx); err != nil {
+		client.Disconnect(context.Background())
+		return nil, errors.Wrap(err, "mongoDB: unable to index slugs")
+	}
 	return &ds, nil
 }
 
@@ -34,49 +84,991 @@ func (ds *Datastore) Connect(config datalayer.DBConfig) (datalayer.DataStore, er
 	return NewDatastore(config)
 }
 
+func (ds *Datastore) Capabilities() datalayer.Capabilities {
+	return datalayer.Capabilities{datalayer.RegexFilters, datalayer.SecondaryIndexes}
+}
+
+// waitForServer pings the server until it answers or ctx expires, backing
+// off exponentially between attempts, so ninja can start before MongoDB does.
+func waitForServer(ctx context.Context, client *mongo.Client) error {
+	backoff := 100 * time.Millisecond
+	for {
+		err := client.Ping(ctx, nil)
+		if err == nil {
+			return nil
+		}
+		log.Printf("mongoDB: server not reachable, retrying in %s: %v", backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// Close disconnects the client, waiting for in-flight operations to finish.
+func (ds *Datastore) Close() error {
+	return errors.Wrap(ds.Client.Disconnect(context.Background()), "mongoDB: unable to disconnect")
+}
+
+// clientOptions translates the pool, timeout and concern settings of config
+// into driver options.
+func clientOptions(config datalayer.DBConfig) (*options.ClientOptions, error) {
+	opts := options.Client().ApplyURI(config.ConnectionString)
+	opts.SetRetryReads(true)
+	opts.SetRetryWrites(true)
+	if config.MaxPoolSize > 0 {
+		opts.SetMaxPoolSize(config.MaxPoolSize)
+	}
+	if config.MinPoolSize > 0 {
+		opts.SetMinPoolSize(config.MinPoolSize)
+	}
+	if config.ConnectTimeout > 0 {
+		opts.SetConnectTimeout(config.ConnectTimeout)
+	}
+	if config.OperationTimeout > 0 {
+		opts.SetTimeout(config.OperationTimeout)
+	}
+
+	switch config.ReadConcern {
+	case "":
+	case "local", "available", "majority", "linearizable", "snapshot":
+		opts.SetReadConcern(&readconcern.ReadConcern{Level: config.ReadConcern})
+	default:
+		return nil, errors.Errorf("mongoDB: unknown read concern %q", config.ReadConcern)
+	}
+
+	switch config.WriteConcern {
+	case "":
+	case "majority":
+		opts.SetWriteConcern(writeconcern.Majority())
+	default:
+		w, err := strconv.Atoi(config.WriteConcern)
+		if err != nil {
+			return nil, errors.Errorf("mongoDB: unknown write concern %q", config.WriteConcern)
+		}
+		opts.SetWriteConcern(&writeconcern.WriteConcern{W: w})
+	}
+	return opts, nil
+}
+
 type collectionData struct {
-	Name     string                 `bson:"_id"`
-	Schema   map[string]interface{} `bson:"schema"`
-	MetaData map[string]interface{} `bson:"metadata"`
+	Name     string                   `bson:"_id"`
+	Schema   map[string]interface{}   `bson:"schema"` // latest version
+	MetaData map[string]interface{}   `bson:"metadata"`
+	Versions []map[string]interface{} `bson:"versions,omitempty"`
+	// RenamedFrom is the old name of a collection while it is being renamed.
+	RenamedFrom string `bson:"renamed_from,omitempty"`
+}
+
+// versions returns every schema version, oldest first. Collections created
+// before schemas were versioned only have their current schema.
+func (data collectionData) versions() []map[string]interface{} {
+	if len(data.Versions) == 0 {
+		return []map[string]interface{}{data.Schema}
+	}
+	return data.Versions
+}
+
+func (data collectionData) vm() datalayer.CollectionVM {
+	return datalayer.CollectionVM{
+		Name:    data.Name,
+		Schema:  normalizeMap(data.Schema),
+		Meta:    normalizeMap(data.MetaData),
+		Version: len(data.versions()),
+	}
+}
+
+// collection reads the schema document of a collection.
+func (ds *Datastore) collection(ctx context.Context, name string) (data collectionData, err error) {
+	err = ds.DB.Collection(ds.SchemaCollection).FindOne(ctx, bson.M{"_id": name}).Decode(&data)
+	if err == mongo.ErrNoDocuments {
+		return data, datalayer.ErrNotFound
+	}
+	return data, err
+}
+
+// collectionExists returns ErrNotFound if collectionName has no schema.
+// MongoDB creates collections implicitly, so writes check this first.
+func (ds *Datastore) collectionExists(ctx context.Context, collectionName string) error {
+	n, err := ds.DB.Collection(ds.SchemaCollection).CountDocuments(ctx, bson.M{"_id": collectionName}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return datalayer.ErrNotFound
+	}
+	return nil
+}
+
+// indexSlugs creates the unique index on the slugs of a collection's items.
+// Items without a slug are left out of it.
+func (ds *Datastore) indexSlugs(ctx context.Context, collectionName string) error {
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: datalayer.SlugField, Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{datalayer.SlugField: bson.M{"$type": "string"}}),
+	}
+	_, err := ds.DB.Collection(collectionName).Indexes().CreateOne(ctx, index)
+	return err
+}
+
+// indexAllSlugs indexes the slugs of collections created before slugs were
+// indexed. It fails if two items of a collection share a slug.
+func (ds *Datastore) indexAllSlugs(ctx context.Context) error {
+	names, err := ds.DB.Collection(ds.SchemaCollection).Distinct(ctx, "_id", bson.M{})
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		name, _ := name.(string)
+		if err = ds.indexSlugs(ctx, name); err != nil {
+			return errors.Wrap(err, name)
+		}
+	}
+	return nil
 }
 
 func (ds *Datastore) CreateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	if ds.reserved(name) {
+		return errors.Errorf("mongoDB: unable to create collection: %s is reserved", name)
+	}
+
 	data := collectionData{}
 	data.Name = name
 	data.Schema = schema
 	data.MetaData = metadata
-	err := ds.DB.C(ds.SchemaCollection).Insert(data)
+	data.Versions = []map[string]interface{}{schema}
+	_, err := ds.DB.Collection(ds.SchemaCollection).InsertOne(ctx, data)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.Wrapf(datalayer.ErrConflict, "mongoDB: unable to create collection: %s", name)
+	}
 	if err != nil {
 		return errors.Wrap(err, "mongoDB: unable to create collection")
 	}
+	if err = ds.indexSlugs(ctx, name); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to create collection")
+	}
 
 	// TODO(tonyalaribe): make use of metadata
 	return nil
 }
 
 func (ds *Datastore) GetCollections(ctx context.Context) (collections []datalayer.CollectionVM, err error) {
-	err = ds.DB.C(ds.SchemaCollection).Find(nil).All(&collections)
-	return collections, err
+	cursor, err := ds.DB.Collection(ds.SchemaCollection).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, errors.Wrap(err, "mongoDB: unable to get collections")
+	}
+	results := []collectionData{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, errors.Wrap(err, "mongoDB: unable to get collections")
+	}
+	for _, result := range results {
+		collections = append(collections, result.vm())
+	}
+	return collections, nil
+}
+
+func (ds *Datastore) GetCollection(ctx context.Context, name string) (collection datalayer.CollectionVM, err error) {
+	data, err := ds.collection(ctx, name)
+	if err != nil {
+		return collection, errors.Wrap(err, "mongoDB: unable to get collection")
+	}
+	return data.vm(), nil
+}
+
+func (ds *Datastore) GetSchemaVersions(ctx context.Context, name string) (versions []datalayer.SchemaVersion, err error) {
+	data, err := ds.collection(ctx, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "mongoDB: unable to get schema versions")
+	}
+	for i, schema := range data.versions() {
+		versions = append(versions, datalayer.SchemaVersion{Version: i + 1, Schema: normalizeMap(schema)})
+	}
+	return versions, nil
+}
+
+// reserved reports whether name clashes with the collections the datastore
+// keeps for itself.
+func (ds *Datastore) reserved(name string) bool {
+	return name == ds.SchemaCollection || name == ds.SchemaCollection+trashSuffix || name == ds.SchemaCollection+revisionsSuffix
+}
+
+func (ds *Datastore) UpdateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	set := bson.M{}
+	update := bson.M{"$set": set}
+	if schema != nil {
+		data, err := ds.collection(ctx, name)
+		if err != nil {
+			return errors.Wrap(err, "mongoDB: unable to update collection")
+		}
+		set["schema"] = schema
+		if len(data.Versions) == 0 {
+			set["versions"] = append(data.versions(), schema)
+		} else {
+			update["$push"] = bson.M{"versions": schema}
+		}
+	}
+	if metadata != nil {
+		set["metadata"] = metadata
+	}
+	if len(set) == 0 {
+		return errors.Wrap(ds.collectionExists(ctx, name), "mongoDB: unable to update collection")
+	}
+
+	result, err := ds.DB.Collection(ds.SchemaCollection).UpdateOne(ctx, bson.M{"_id": name}, update)
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to update collection")
+	}
+	if result.MatchedCount == 0 {
+		return errors.Wrap(datalayer.ErrNotFound, "mongoDB: unable to update collection")
+	}
+	return nil
+}
+
+// RenameCollection registers the new name, marked as renamed from the old
+// one, before moving items, trash and revisions. It then forgets the old name
+// and clears the mark. Every step can be repeated, so renaming again finishes
+// an interrupted rename.
+func (ds *Datastore) RenameCollection(ctx context.Context, name, newName string) error {
+	if ds.reserved(newName) {
+		return errors.Errorf("mongoDB: unable to rename collection: %s is reserved", newName)
+	}
+
+	schemas := ds.DB.Collection(ds.SchemaCollection)
+	target, err := ds.collection(ctx, newName)
+	if err != nil && err != datalayer.ErrNotFound {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+	resuming := err == nil && target.RenamedFrom == name
+	if err == nil && !resuming {
+		return errors.Wrapf(datalayer.ErrConflict, "mongoDB: unable to rename collection: %s", newName)
+	}
+
+	data, err := ds.collection(ctx, name)
+	if err == datalayer.ErrNotFound && resuming {
+		// Only the mark of a finished rename is left.
+		return ds.unmarkRenamed(ctx, newName)
+	}
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+	data.Name = newName
+	data.RenamedFrom = name
+	if resuming {
+		// The old schema may have been updated since.
+		_, err = schemas.ReplaceOne(ctx, bson.M{"_id": newName, "renamed_from": name}, data)
+	} else {
+		_, err = schemas.InsertOne(ctx, data)
+	}
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.Wrapf(datalayer.ErrConflict, "mongoDB: unable to rename collection: %s", newName)
+	}
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+
+	command := bson.D{
+		{Key: "renameCollection", Value: ds.DB.Name() + "." + name},
+		{Key: "to", Value: ds.DB.Name() + "." + newName},
+	}
+	err = ds.Client.Database("admin").RunCommand(ctx, command).Err()
+	if cmdErr, ok := err.(mongo.CommandError); ok && cmdErr.Code == namespaceNotFound {
+		// Nothing was ever saved, or an interrupted rename already moved
+		// the items, so there is no collection to move.
+		err = nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+
+	// Trashed items are keyed by collection name, and _id can't be updated.
+	cursor, err := ds.trash().Find(ctx, bson.M{"_id.collection": name})
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+	trashed := []trashedItem{}
+	if err = cursor.All(ctx, &trashed); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+	for _, t := range trashed {
+		t.ID.Collection = newName
+		_, err = ds.trash().ReplaceOne(ctx, bson.M{"_id": t.ID}, t, options.Replace().SetUpsert(true))
+		if err != nil {
+			return errors.Wrap(err, "mongoDB: unable to rename collection")
+		}
+	}
+	if _, err = ds.trash().DeleteMany(ctx, bson.M{"_id.collection": name}); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+
+	cursor, err = ds.revisions().Find(ctx, bson.M{"_id.collection": name})
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+	revisions := []revisionDoc{}
+	if err = cursor.All(ctx, &revisions); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+	for _, r := range revisions {
+		r.ID.Collection = newName
+		_, err = ds.revisions().ReplaceOne(ctx, bson.M{"_id": r.ID}, r, options.Replace().SetUpsert(true))
+		if err != nil {
+			return errors.Wrap(err, "mongoDB: unable to rename collection")
+		}
+	}
+	if _, err = ds.revisions().DeleteMany(ctx, bson.M{"_id.collection": name}); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+
+	if _, err = schemas.DeleteOne(ctx, bson.M{"_id": name}); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to rename collection")
+	}
+	return ds.unmarkRenamed(ctx, newName)
+}
+
+// unmarkRenamed clears the mark RenameCollection leaves on a collection until
+// the rename is done.
+func (ds *Datastore) unmarkRenamed(ctx context.Context, name string) error {
+	update := bson.M{"$unset": bson.M{"renamed_from": ""}}
+	_, err := ds.DB.Collection(ds.SchemaCollection).UpdateOne(ctx, bson.M{"_id": name}, update)
+	return errors.Wrap(err, "mongoDB: unable to rename collection")
+}
+
+func (ds *Datastore) DeleteCollection(ctx context.Context, name string) error {
+	result, err := ds.DB.Collection(ds.SchemaCollection).DeleteOne(ctx, bson.M{"_id": name})
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to delete collection")
+	}
+	if result.DeletedCount == 0 {
+		return errors.Wrap(datalayer.ErrNotFound, "mongoDB: unable to delete collection")
+	}
+	if err = ds.DB.Collection(name).Drop(ctx); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to delete collection")
+	}
+	if _, err = ds.trash().DeleteMany(ctx, bson.M{"_id.collection": name}); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to delete collection")
+	}
+	_, err = ds.revisions().DeleteMany(ctx, bson.M{"_id.collection": name})
+	return errors.Wrap(err, "mongoDB: unable to delete collection")
 }
 
 func (ds *Datastore) GetSchema(ctx context.Context, collectionName string) (map[string]interface{}, error) {
 	result := collectionData{}
-	err := ds.DB.C(ds.SchemaCollection).FindId(collectionName).One(&result)
-	return result.Schema, errors.Wrap(err, "mongoDB: unable to get schema")
+	err := ds.DB.Collection(ds.SchemaCollection).FindOne(ctx, bson.M{"_id": collectionName}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "mongoDB: unable to get schema")
+	}
+	return normalizeMap(result.Schema), errors.Wrap(err, "mongoDB: unable to get schema")
 }
 
 func (ds *Datastore) SaveItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) error {
+	if err := ds.collectionExists(ctx, collectionName); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to save item")
+	}
+
+	if err := ds.checkTrashed(ctx, collectionName, itemID); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to save item")
+	}
+
 	item["_id"] = itemID
-	err := ds.DB.C(collectionName).Insert(item)
+	item[datalayer.VersionField] = 1
+	_, err := ds.DB.Collection(collectionName).InsertOne(ctx, item)
+	if mongo.IsDuplicateKeyError(err) {
+		// Either the ID or the slug is taken.
+		return errors.Wrapf(datalayer.ErrConflict, "mongoDB: unable to save item: %s", itemID)
+	}
 	return errors.Wrap(err, "mongoDB: unable to save item")
 }
 
+// versionFilter matches the item itemID while it is at version. Items saved
+// before versioning have no version field and are at version 1.
+func versionFilter(itemID string, version int) bson.M {
+	if version == 1 {
+		return bson.M{"_id": itemID, "$or": bson.A{
+			bson.M{datalayer.VersionField: 1},
+			bson.M{datalayer.VersionField: bson.M{"$exists": false}},
+		}}
+	}
+	return bson.M{"_id": itemID, datalayer.VersionField: version}
+}
+
+// UpdateItem only replaces the item if it is still at the version that was
+// read, and reads it again if another write got there first.
+func (ds *Datastore) UpdateItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}, ifVersion int) error {
+	item["_id"] = itemID
+	for {
+		stored, err := ds.GetItem(ctx, collectionName, itemID)
+		if err != nil {
+			return errors.Wrap(errors.Cause(err), "mongoDB: unable to update item")
+		}
+		if err = datalayer.CheckVersion(stored, ifVersion); err != nil {
+			return errors.Wrap(err, "mongoDB: unable to update item")
+		}
+		version := datalayer.VersionOf(stored)
+		item[datalayer.VersionField] = version + 1
+
+		result, err := ds.DB.Collection(collectionName).ReplaceOne(ctx, versionFilter(itemID, version), item)
+		if mongo.IsDuplicateKeyError(err) {
+			return errors.Wrapf(datalayer.ErrConflict, "mongoDB: unable to update item: slug %s", datalayer.SlugOf(item))
+		}
+		if err != nil {
+			return errors.Wrap(err, "mongoDB: unable to update item")
+		}
+		if result.MatchedCount == 1 {
+			return nil
+		}
+	}
+}
+
+// UpsertItem inserts the item, or replaces it at the version that was read,
+// and starts over if another write got there first.
+func (ds *Datastore) UpsertItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) (created bool, err error) {
+	if err = ds.collectionExists(ctx, collectionName); err != nil {
+		return false, errors.Wrap(err, "mongoDB: unable to upsert item")
+	}
+
+	item["_id"] = itemID
+	collection := ds.DB.Collection(collectionName)
+	for {
+		stored, err := ds.GetItem(ctx, collectionName, itemID)
+		if errors.Cause(err) == datalayer.ErrNotFound {
+			if err := ds.checkTrashed(ctx, collectionName, itemID); err != nil {
+				return false, errors.Wrap(err, "mongoDB: unable to upsert item")
+			}
+			item[datalayer.VersionField] = 1
+			_, err = collection.InsertOne(ctx, item)
+			if mongo.IsDuplicateKeyError(err) {
+				// Another write inserted the item first, unless its slug
+				// is taken.
+				if _, err := ds.GetItem(ctx, collectionName, itemID); errors.Cause(err) == datalayer.ErrNotFound {
+					return false, errors.Wrapf(datalayer.ErrConflict, "mongoDB: unable to upsert item: slug %s", datalayer.SlugOf(item))
+				}
+				continue
+			}
+			return true, errors.Wrap(err, "mongoDB: unable to upsert item")
+		}
+		if err != nil {
+			return false, errors.Wrap(errors.Cause(err), "mongoDB: unable to upsert item")
+		}
+
+		version := datalayer.VersionOf(stored)
+		item[datalayer.VersionField] = version + 1
+		result, err := collection.ReplaceOne(ctx, versionFilter(itemID, version), item)
+		if mongo.IsDuplicateKeyError(err) {
+			return false, errors.Wrapf(datalayer.ErrConflict, "mongoDB: unable to upsert item: slug %s", datalayer.SlugOf(item))
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "mongoDB: unable to upsert item")
+		}
+		if result.MatchedCount == 1 {
+			return false, nil
+		}
+	}
+}
+
 func (ds *Datastore) GetItem(ctx context.Context, collectionName, itemID string) (item map[string]interface{}, err error) {
-	err = ds.DB.C(collectionName).FindId(itemID).One(&item)
-	return item, errors.Wrap(err, "mongoDB: unable to get item")
+	err = ds.DB.Collection(collectionName).FindOne(ctx, bson.M{"_id": itemID}).Decode(&item)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "mongoDB: unable to get item")
+	}
+	return normalizeMap(item), errors.Wrap(err, "mongoDB: unable to get item")
+}
+
+func (ds *Datastore) GetItemBySlug(ctx context.Context, collectionName, slug string) (item map[string]interface{}, err error) {
+	if err = ds.collectionExists(ctx, collectionName); err != nil {
+		return nil, errors.Wrap(err, "mongoDB: unable to get item by slug")
+	}
+	err = ds.DB.Collection(collectionName).FindOne(ctx, bson.M{datalayer.SlugField: slug}).Decode(&item)
+	if err == mongo.ErrNoDocuments {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "mongoDB: unable to get item by slug")
+	}
+	return normalizeMap(item), errors.Wrap(err, "mongoDB: unable to get item by slug")
+}
+
+// filterDoc translates filter into a query document. MongoDB's own operators
+// already test array values element by element.
+func filterDoc(filter datalayer.Filter) bson.M {
+	if len(filter) == 0 {
+		return bson.M{}
+	}
+	conditions := make(bson.A, 0, len(filter))
+	for _, c := range filter {
+		var condition bson.M
+		switch c.Op {
+		case datalayer.Eq:
+			condition = bson.M{"$eq": c.Value}
+		case datalayer.Prefix:
+			condition = bson.M{"$regex": "^" + regexp.QuoteMeta(c.Value.(string))}
+		default:
+			condition = bson.M{"$" + string(c.Op): c.Value}
+		}
+		conditions = append(conditions, bson.M{c.Field(): condition})
+	}
+	return bson.M{"$and": conditions}
+}
+
+// sortDoc translates s into a sort document. Ties, and listings without a
+// sort, are ordered by _id.
+func sortDoc(s datalayer.Sort) bson.D {
+	doc := make(bson.D, 0, len(s)+1)
+	for _, f := range s {
+		order := 1
+		if f.Desc {
+			order = -1
+		}
+		doc = append(doc, bson.E{Key: f.Field(), Value: order})
+	}
+	return append(doc, bson.E{Key: "_id", Value: 1})
+}
+
+// afterDoc translates cursor into a query selecting the items that follow it
+// in the order of s and then _id. Missing and null values come first, as
+// MongoDB sorts them.
+func afterDoc(s datalayer.Sort, cursor *datalayer.Cursor) bson.M {
+	equal := bson.A{}
+	alternatives := bson.A{}
+	alternative := func(beyond bson.M) {
+		conditions := append(append(bson.A{}, equal...), beyond)
+		alternatives = append(alternatives, bson.M{"$and": conditions})
+	}
+	for i, f := range s {
+		field := f.Field()
+		v := cursor.Values[i]
+		switch {
+		case v == nil && !f.Desc:
+			alternative(bson.M{field: bson.M{"$ne": nil}})
+		case v == nil:
+			// Nothing sorts after null in descending order.
+		case !f.Desc:
+			alternative(bson.M{field: bson.M{"$gt": v}})
+		default:
+			alternative(bson.M{"$or": bson.A{bson.M{field: bson.M{"$lt": v}}, bson.M{field: nil}}})
+		}
+		equal = append(equal, bson.M{field: v})
+	}
+	alternative(bson.M{"_id": bson.M{"$gt": cursor.ID}})
+	return bson.M{"$or": alternatives}
 }
 
 func (ds *Datastore) GetItems(ctx context.Context, collectionName string, queryData datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
-	err = ds.DB.C(collectionName).Find(nil).All(&items)
-	return items, respInfo, errors.Wrap(err, "mongoDB: unable to items")
+	collection := ds.DB.Collection(collectionName)
+	filter := filterDoc(queryData.Filter)
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to count items")
+	}
+	if total == 0 {
+		if err = ds.collectionExists(ctx, collectionName); err != nil {
+			return nil, respInfo, errors.Wrap(err, "mongoDB: unable to get items")
+		}
+	}
+
+	// The cursor narrows the page, not the count.
+	if queryData.Cursor != nil {
+		filter = bson.M{"$and": bson.A{filter, afterDoc(queryData.Sort, queryData.Cursor)}}
+	}
+	findOptions := options.Find().SetSkip(int64(queryData.Skip())).SetSort(sortDoc(queryData.Sort))
+	if len(queryData.Fields) > 0 {
+		projection := bson.M{datalayer.VersionField: 1}
+		for _, field := range queryData.Fields.Fields() {
+			projection[field] = 1
+		}
+		findOptions.SetProjection(projection)
+	}
+	if queryData.Count > 0 {
+		findOptions.SetLimit(int64(queryData.Count))
+	}
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to get items")
+	}
+	items = []map[string]interface{}{}
+	if err = cursor.All(ctx, &items); err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to get items")
+	}
+	for i := range items {
+		items[i] = normalizeMap(items[i])
+	}
+	return items, queryData.ResponseInfo(int(total), len(items)), nil
+}
+
+// Aggregate runs aggregation as a $match and $group pipeline.
+func (ds *Datastore) Aggregate(ctx context.Context, collectionName string, aggregation datalayer.Aggregation) (groups []datalayer.Group, err error) {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filterDoc(aggregation.Filter)}},
+		{{Key: "$group", Value: groupDoc(aggregation)}},
+	}
+	cursor, err := ds.DB.Collection(collectionName).Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, errors.Wrap(err, "mongoDB: unable to aggregate items")
+	}
+	results := []bson.M{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, errors.Wrap(err, "mongoDB: unable to aggregate items")
+	}
+	if len(results) == 0 {
+		if err = ds.collectionExists(ctx, collectionName); err != nil {
+			return nil, errors.Wrap(err, "mongoDB: unable to aggregate items")
+		}
+		// $group returns nothing for no items, where a single group of them
+		// all is still due.
+		return datalayer.NewAggregator(aggregation).Groups(), nil
+	}
+
+	groups = make([]datalayer.Group, 0, len(results))
+	for _, result := range results {
+		result = normalizeMap(result)
+		key, _ := result["_id"].(map[string]interface{})
+		g := datalayer.Group{Key: map[string]interface{}{}, Metrics: map[string]interface{}{}}
+		for i, path := range aggregation.GroupBy {
+			g.Key[strings.Join(path, ".")] = float(key[fmt.Sprintf("g%d", i)])
+		}
+		for i, m := range aggregation.Metrics {
+			value := float(result[fmt.Sprintf("m%d", i)])
+			switch m.Op {
+			case datalayer.Count:
+				if n, ok := value.(float64); ok {
+					value = int(n)
+				}
+			case datalayer.Sum:
+				if value == nil {
+					value = float64(0)
+				}
+			}
+			g.Metrics[m.Name()] = value
+		}
+		groups = append(groups, g)
+	}
+	aggregation.SortGroups(groups)
+	return groups, nil
+}
+
+// groupDoc translates aggregation into a $group stage. Group keys and
+// metrics are named by position, g0 and m0 onwards, since their fields may
+// be nested. Missing group fields are grouped with null, as embedded drivers
+// do.
+func groupDoc(aggregation datalayer.Aggregation) bson.M {
+	var id interface{}
+	if len(aggregation.GroupBy) > 0 {
+		key := bson.M{}
+		for i, path := range aggregation.GroupBy {
+			key[fmt.Sprintf("g%d", i)] = bson.M{"$ifNull": bson.A{"$" + strings.Join(path, "."), nil}}
+		}
+		id = key
+	}
+	group := bson.M{"_id": id}
+	for i, m := range aggregation.Metrics {
+		if m.Op == datalayer.Count {
+			group[fmt.Sprintf("m%d", i)] = bson.M{"$sum": 1}
+			continue
+		}
+		group[fmt.Sprintf("m%d", i)] = bson.M{"$" + string(m.Op): "$" + m.Field()}
+	}
+	return group
+}
+
+// float returns integers decoded from BSON as float64, the type embedded
+// drivers give every number.
+func float(v interface{}) interface{} {
+	switch n := v.(type) {
+	case int32:
+		return float64(n)
+	case int64:
+		return float64(n)
+	}
+	return v
+}
+
+func (ds *Datastore) DeleteItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	filter := bson.M{"_id": itemID}
+	if ifVersion != 0 {
+		filter = versionFilter(itemID, ifVersion)
+	}
+	result, err := ds.DB.Collection(collectionName).DeleteOne(ctx, filter)
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to delete item")
+	}
+	if result.DeletedCount == 0 {
+		err = datalayer.ErrNotFound
+		if ifVersion != 0 {
+			// Tell a missing item from one at another version.
+			if _, getErr := ds.GetItem(ctx, collectionName, itemID); getErr == nil {
+				err = datalayer.ErrVersionMismatch
+			}
+		}
+		return errors.Wrap(err, "mongoDB: unable to delete item")
+	}
+	return errors.Wrap(ds.deleteRevisions(ctx, collectionName, itemID), "mongoDB: unable to delete item")
+}
+
+// trashedItem is a document of the trash collection, which holds the trashed
+// items of every collection.
+type trashedItem struct {
+	ID   trashedItemID          `bson:"_id"`
+	Item map[string]interface{} `bson:"item"`
+}
+
+type trashedItemID struct {
+	Collection string `bson:"collection"`
+	ItemID     string `bson:"id"`
+}
+
+func (ds *Datastore) trash() *mongo.Collection {
+	return ds.DB.Collection(ds.SchemaCollection + trashSuffix)
+}
+
+// checkTrashed returns ErrConflict if itemID is in the trash of a collection,
+// so a new item can't take over the revisions of a trashed one.
+func (ds *Datastore) checkTrashed(ctx context.Context, collectionName, itemID string) error {
+	id := trashedItemID{Collection: collectionName, ItemID: itemID}
+	n, err := ds.trash().CountDocuments(ctx, bson.M{"_id": id}, options.Count().SetLimit(1))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return errors.Wrapf(datalayer.ErrConflict, "%s is in the trash", itemID)
+	}
+	return nil
+}
+
+// TrashItem copies the item into the trash before removing it, so a failure
+// in between leaves it in both places rather than in neither.
+func (ds *Datastore) TrashItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	item, err := ds.GetItem(ctx, collectionName, itemID)
+	if err != nil {
+		return errors.Wrap(errors.Cause(err), "mongoDB: unable to trash item")
+	}
+	if err = datalayer.CheckVersion(item, ifVersion); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to trash item")
+	}
+	version := datalayer.VersionOf(item)
+	item[datalayer.DeletedAtField] = datalayer.DeletedAt()
+
+	trashed := trashedItem{ID: trashedItemID{Collection: collectionName, ItemID: itemID}, Item: item}
+	_, err = ds.trash().ReplaceOne(ctx, bson.M{"_id": trashed.ID}, trashed, options.Replace().SetUpsert(true))
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to trash item")
+	}
+	result, err := ds.DB.Collection(collectionName).DeleteOne(ctx, versionFilter(itemID, version))
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to trash item")
+	}
+	if result.DeletedCount == 0 {
+		// The item was written since it was read; take back the trashed copy.
+		if _, err = ds.trash().DeleteOne(ctx, bson.M{"_id": trashed.ID}); err != nil {
+			return errors.Wrap(err, "mongoDB: unable to trash item")
+		}
+		return errors.Wrap(datalayer.ErrVersionMismatch, "mongoDB: unable to trash item")
+	}
+	return nil
+}
+
+// RestoreItem inserts the item back before removing it from the trash, for
+// the same reason as TrashItem.
+func (ds *Datastore) RestoreItem(ctx context.Context, collectionName, itemID string) error {
+	if err := ds.collectionExists(ctx, collectionName); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to restore item")
+	}
+
+	id := trashedItemID{Collection: collectionName, ItemID: itemID}
+	trashed := trashedItem{}
+	err := ds.trash().FindOne(ctx, bson.M{"_id": id}).Decode(&trashed)
+	if err == mongo.ErrNoDocuments {
+		return errors.Wrap(datalayer.ErrNotFound, "mongoDB: unable to restore item")
+	}
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to restore item")
+	}
+
+	delete(trashed.Item, datalayer.DeletedAtField)
+	_, err = ds.DB.Collection(collectionName).InsertOne(ctx, trashed.Item)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.Wrapf(datalayer.ErrConflict, "mongoDB: unable to restore item: %s", itemID)
+	}
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to restore item")
+	}
+	_, err = ds.trash().DeleteOne(ctx, bson.M{"_id": id})
+	return errors.Wrap(err, "mongoDB: unable to restore item")
+}
+
+func (ds *Datastore) PurgeItem(ctx context.Context, collectionName, itemID string) error {
+	id := trashedItemID{Collection: collectionName, ItemID: itemID}
+	result, err := ds.trash().DeleteOne(ctx, bson.M{"_id": id})
+	if err != nil {
+		return errors.Wrap(err, "mongoDB: unable to purge item")
+	}
+	if result.DeletedCount == 0 {
+		return errors.Wrap(datalayer.ErrNotFound, "mongoDB: unable to purge item")
+	}
+	return errors.Wrap(ds.deleteRevisions(ctx, collectionName, itemID), "mongoDB: unable to purge item")
+}
+
+func (ds *Datastore) GetTrashedItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err = ds.collectionExists(ctx, collectionName); err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to get trashed items")
+	}
+
+	filter := bson.M{"_id.collection": collectionName}
+	total, err := ds.trash().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to count trashed items")
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"_id.id": 1}).SetSkip(int64(queryMeta.Skip()))
+	if queryMeta.Count > 0 {
+		findOptions.SetLimit(int64(queryMeta.Count))
+	}
+	cursor, err := ds.trash().Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to get trashed items")
+	}
+	results := []trashedItem{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to get trashed items")
+	}
+	items = make([]map[string]interface{}, 0, len(results))
+	for _, result := range results {
+		items = append(items, normalizeMap(result.Item))
+	}
+	return items, queryMeta.ResponseInfo(int(total), len(items)), nil
+}
+
+// revisionDoc is a document of the revisions collection, which holds the
+// item revisions of every collection.
+type revisionDoc struct {
+	ID            revisionID             `bson:"_id"`
+	Item          map[string]interface{} `bson:"item"`
+	CreatedAt     time.Time              `bson:"createdAt"`
+	Actor         string                 `bson:"actor,omitempty"`
+	SchemaVersion int                    `bson:"schemaVersion"`
+}
+
+type revisionID struct {
+	Collection string `bson:"collection"`
+	ItemID     string `bson:"id"`
+	Revision   int    `bson:"revision"`
+}
+
+func (r revisionDoc) revision() datalayer.Revision {
+	return datalayer.Revision{
+		Revision:      r.ID.Revision,
+		Item:          normalizeMap(r.Item),
+		CreatedAt:     r.CreatedAt,
+		Actor:         r.Actor,
+		SchemaVersion: r.SchemaVersion,
+	}
+}
+
+func (ds *Datastore) revisions() *mongo.Collection {
+	return ds.DB.Collection(ds.SchemaCollection + revisionsSuffix)
+}
+
+func (ds *Datastore) deleteRevisions(ctx context.Context, collectionName, itemID string) error {
+	_, err := ds.revisions().DeleteMany(ctx, bson.M{"_id.collection": collectionName, "_id.id": itemID})
+	return err
+}
+
+func (ds *Datastore) SaveRevision(ctx context.Context, collectionName, itemID string, revision datalayer.Revision) error {
+	if err := ds.collectionExists(ctx, collectionName); err != nil {
+		return errors.Wrap(err, "mongoDB: unable to save revision")
+	}
+
+	doc := revisionDoc{
+		ID:            revisionID{Collection: collectionName, ItemID: itemID, Revision: revision.Revision},
+		Item:          revision.Item,
+		CreatedAt:     revision.CreatedAt,
+		Actor:         revision.Actor,
+		SchemaVersion: revision.SchemaVersion,
+	}
+	_, err := ds.revisions().InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.Wrapf(datalayer.ErrConflict, "mongoDB: unable to save revision: %d", revision.Revision)
+	}
+	return errors.Wrap(err, "mongoDB: unable to save revision")
+}
+
+func (ds *Datastore) GetRevisions(ctx context.Context, collectionName, itemID string, queryMeta datalayer.QueryMeta) (revisions []datalayer.Revision, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err = ds.collectionExists(ctx, collectionName); err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to get revisions")
+	}
+
+	filter := bson.M{"_id.collection": collectionName, "_id.id": itemID}
+	total, err := ds.revisions().CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to count revisions")
+	}
+
+	findOptions := options.Find().SetSort(bson.M{"_id.revision": 1}).SetSkip(int64(queryMeta.Skip()))
+	if queryMeta.Count > 0 {
+		findOptions.SetLimit(int64(queryMeta.Count))
+	}
+	cursor, err := ds.revisions().Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to get revisions")
+	}
+	results := []revisionDoc{}
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, respInfo, errors.Wrap(err, "mongoDB: unable to get revisions")
+	}
+	revisions = make([]datalayer.Revision, 0, len(results))
+	for _, result := range results {
+		revisions = append(revisions, result.revision())
+	}
+	return revisions, queryMeta.ResponseInfo(int(total), len(revisions)), nil
+}
+
+func (ds *Datastore) GetRevision(ctx context.Context, collectionName, itemID string, number int) (revision datalayer.Revision, err error) {
+	if err = ds.collectionExists(ctx, collectionName); err != nil {
+		return revision, errors.Wrap(err, "mongoDB: unable to get revision")
+	}
+
+	result := revisionDoc{}
+	id := revisionID{Collection: collectionName, ItemID: itemID, Revision: number}
+	err = ds.revisions().FindOne(ctx, bson.M{"_id": id}).Decode(&result)
+	if err == mongo.ErrNoDocuments {
+		return revision, errors.Wrap(datalayer.ErrNotFound, "mongoDB: unable to get revision")
+	}
+	if err != nil {
+		return revision, errors.Wrap(err, "mongoDB: unable to get revision")
+	}
+	return result.revision(), nil
+}
+
+// normalizeMap converts the bson.M and bson.A values the driver decodes
+// nested documents into back to plain maps and slices, so items look the same
+// as those of every other driver.
+func normalizeMap(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	for k, v := range m {
+		m[k] = normalizeValue(v)
+	}
+	return m
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case primitive.M:
+		return normalizeMap(map[string]interface{}(vv))
+	case map[string]interface{}:
+		return normalizeMap(vv)
+	case primitive.D:
+		return normalizeMap(map[string]interface{}(vv.Map()))
+	case primitive.A:
+		return normalizeValue([]interface{}(vv))
+	case []interface{}:
+		for i := range vv {
+			vv[i] = normalizeValue(vv[i])
+		}
+		return vv
+	default:
+		return v
+	}
 }
@@ -0,0 +1,77 @@
+// +build integration
+
+package mongodb
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/tonyalaribe/ninja/datalayer/datastoretest"
+)
+
+func newDatastore(t *testing.T) *Datastore {
+	connectionString := os.Getenv("NINJA_MONGODB_URL")
+	if connectionString == "" {
+		connectionString = "mongodb://localhost:27017"
+	}
+	ds, err := NewDatastore(datalayer.DBConfig{
+		ConnectionString:     connectionString,
+		DatabaseName:         fmt.Sprintf("ninja_conformance_%d", time.Now().UnixNano()),
+		SchemaCollectionName: "schema_collection",
+	})
+	if err != nil {
+		t.Fatalf("unable to create datastore: %v", err)
+	}
+	t.Cleanup(func() {
+		ds.DB.Drop(context.Background())
+		ds.Client.Disconnect(context.Background())
+	})
+	return ds
+}
+
+func TestConformance(t *testing.T) {
+	datastoretest.RunConformance(t, func(t *testing.T) datalayer.DataStore {
+		return newDatastore(t)
+	})
+}
+
+func TestResumeRename(t *testing.T) {
+	ds := newDatastore(t)
+	ctx := context.Background()
+	if err := ds.CreateCollection(ctx, "old", map[string]interface{}{"type": "object"}, nil); err != nil {
+		t.Fatalf("CreateCollection failed: %v", err)
+	}
+	if err := ds.SaveItem(ctx, "old", "a", map[string]interface{}{"title": "A"}); err != nil {
+		t.Fatalf("SaveItem failed: %v", err)
+	}
+
+	// A rename interrupted after registering the new name.
+	data, err := ds.collection(ctx, "old")
+	if err != nil {
+		t.Fatalf("unable to read schema: %v", err)
+	}
+	data.Name, data.RenamedFrom = "new", "old"
+	if _, err = ds.DB.Collection(ds.SchemaCollection).InsertOne(ctx, data); err != nil {
+		t.Fatalf("unable to register new name: %v", err)
+	}
+	if err = ds.RenameCollection(ctx, "other", "new"); errors.Cause(err) != datalayer.ErrConflict {
+		t.Errorf("got %v renaming another collection to new, want ErrConflict", err)
+	}
+	if err = ds.RenameCollection(ctx, "old", "new"); err != nil {
+		t.Fatalf("resumed RenameCollection failed: %v", err)
+	}
+	if _, err = ds.GetItem(ctx, "new", "a"); err != nil {
+		t.Errorf("GetItem after rename failed: %v", err)
+	}
+	if _, err = ds.GetCollection(ctx, "old"); errors.Cause(err) != datalayer.ErrNotFound {
+		t.Errorf("got %v getting the old collection, want ErrNotFound", err)
+	}
+	if data, err = ds.collection(ctx, "new"); err != nil || data.RenamedFrom != "" {
+		t.Errorf("got %+v with error %v, want the rename mark cleared", data, err)
+	}
+}
@@ -0,0 +1,123 @@
+package datalayer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Projection lists the dot-separated paths of the fields to return of each
+// item. `_id` and VersionField are always returned. An empty Projection
+// returns whole items.
+type Projection [][]string
+
+// ParseProjection reads the `fields` query parameter of an item read: a
+// comma-separated list of dot-separated fields, e.g. `title,author.name`.
+// Fields nested in another listed field are dropped, since the outer field
+// already returns them.
+func ParseProjection(value string) (Projection, error) {
+	if value == "" {
+		return nil, nil
+	}
+	fields := strings.Split(value, ",")
+	// Shorter fields come first, so the fields they hold are seen after them.
+	sort.SliceStable(fields, func(i, j int) bool {
+		return strings.Count(fields[i], ".") < strings.Count(fields[j], ".")
+	})
+
+	p := Projection{}
+	for _, field := range fields {
+		path, ok := parsePath(field)
+		if !ok {
+			return nil, &InvalidQueryError{Param: "fields", Reason: fmt.Sprintf("invalid field %q", field)}
+		}
+		if !p.covers(path) {
+			p = append(p, path)
+		}
+	}
+	return p, nil
+}
+
+// covers reports whether p already returns the field at path.
+func (p Projection) covers(path []string) bool {
+	for _, selected := range p {
+		if hasPrefix(path, selected) {
+			return true
+		}
+	}
+	return false
+}
+
+// With returns a copy of p that also returns the fields at paths. Paths p
+// already covers are skipped, and listed fields a path covers are replaced by
+// it, so that no listed field is nested in another.
+func (p Projection) With(paths ...[]string) Projection {
+	with := append(Projection{}, p...)
+	for _, path := range paths {
+		if with.covers(path) {
+			continue
+		}
+		kept := Projection{}
+		for _, selected := range with {
+			if !hasPrefix(selected, path) {
+				kept = append(kept, selected)
+			}
+		}
+		with = append(kept, path)
+	}
+	return with
+}
+
+// Fields returns the dot-separated paths of p.
+func (p Projection) Fields() []string {
+	fields := make([]string, len(p))
+	for i, path := range p {
+		fields[i] = strings.Join(path, ".")
+	}
+	return fields
+}
+
+// Apply returns the fields of item that p selects, or item itself if p is
+// empty. Values are shared with item, not copied.
+func (p Projection) Apply(item map[string]interface{}) map[string]interface{} {
+	if len(p) == 0 || item == nil {
+		return item
+	}
+	out := map[string]interface{}{}
+	for _, field := range []string{"_id", VersionField} {
+		if value, ok := item[field]; ok {
+			out[field] = value
+		}
+	}
+	for _, path := range p {
+		if value, ok := Lookup(item, path); ok {
+			SetPath(out, path, value)
+		}
+	}
+	return out
+}
+
+// SetPath sets the value at path in item, creating the objects on the way.
+func SetPath(item map[string]interface{}, path []string, value interface{}) {
+	for _, p := range path[:len(path)-1] {
+		next, ok := item[p].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			item[p] = next
+		}
+		item = next
+	}
+	item[path[len(path)-1]] = value
+}
+
+func hasPrefix(path, prefix []string) bool {
+	if len(prefix) > len(path) {
+		return false
+	}
+	for i := range prefix {
+		if path[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,482 @@
+// Package search is an embedded full-text index of collection items. It
+// ranks matches with BM25, tolerates typos and highlights the matched words.
+//
+// Each collection's index is kept in memory. An Index opened on a directory
+// also appends every change to a log file per collection there, and replays
+// the logs when it is opened again, so the index survives restarts without
+// touching the datastore. Only one Index may have a directory open at a time,
+// so the index can't be shared by several ninja instances.
+package search
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	logExt   = ".log"
+	lockName = "LOCK"
+
+	// BM25 parameters.
+	k1 = 1.2
+	b  = 0.75
+)
+
+// ErrLocked is returned by Open when another Index, in this process or
+// another, has the directory open.
+var ErrLocked = errors.New("search: index is already open")
+
+// Index holds the full-text indexes of every collection.
+type Index struct {
+	dir         string
+	lock        *os.File
+	mu          sync.RWMutex
+	collections map[string]*collection
+}
+
+// collection is the index of one collection.
+type collection struct {
+	docs map[string]document
+	// postings maps each term to the documents holding it, and to the number
+	// of times it occurs in each of their fields.
+	postings map[string]map[string]map[string]int
+	// lengths is the total number of terms in each field, for BM25's
+	// average field length.
+	lengths map[string]int
+
+	log     *os.File
+	records int
+}
+
+type document struct {
+	fields  map[string]string
+	lengths map[string]int
+}
+
+// record is a line of a collection's log. A record without fields removes
+// the document.
+type record struct {
+	ID     string            `json:"id"`
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Open opens the index stored in dir, creating dir if needed, and locks it
+// until Close. It fails with ErrLocked if dir is already open. An empty dir
+// keeps the index in memory only.
+func Open(dir string) (*Index, error) {
+	ix := &Index{dir: dir, collections: map[string]*collection{}}
+	if dir == "" {
+		return ix, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrap(err, "search: unable to open index")
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, lockName), os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrap(err, "search: unable to open index")
+	}
+	if err = lockFile(lock); err != nil {
+		lock.Close()
+		if err == ErrLocked {
+			return nil, errors.Wrapf(ErrLocked, "search: unable to open %s", dir)
+		}
+		return nil, errors.Wrap(err, "search: unable to lock index")
+	}
+	ix.lock = lock
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		ix.Close()
+		return nil, errors.Wrap(err, "search: unable to open index")
+	}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != logExt {
+			continue
+		}
+		name, err := url.PathUnescape(strings.TrimSuffix(file.Name(), logExt))
+		if err != nil {
+			continue
+		}
+		if _, err = ix.collection(name, true); err != nil {
+			ix.Close()
+			return nil, err
+		}
+	}
+	return ix, nil
+}
+
+func (ix *Index) logPath(collectionName string) string {
+	return filepath.Join(ix.dir, url.PathEscape(collectionName)+logExt)
+}
+
+// collection returns the index of collectionName, loading its log or
+// creating it if create is set. It returns nil if the collection has no
+// index and create isn't set. ix.mu must be held for writing when create is
+// set.
+func (ix *Index) collection(collectionName string, create bool) (*collection, error) {
+	if c, ok := ix.collections[collectionName]; ok || !create {
+		return c, nil
+	}
+	c := &collection{
+		docs:     map[string]document{},
+		postings: map[string]map[string]map[string]int{},
+		lengths:  map[string]int{},
+	}
+	if ix.dir != "" {
+		if err := c.replay(ix.logPath(collectionName)); err != nil {
+			return nil, errors.Wrapf(err, "search: unable to load the index of %s", collectionName)
+		}
+		log, err := os.OpenFile(ix.logPath(collectionName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "search: unable to load the index of %s", collectionName)
+		}
+		c.log = log
+	}
+	ix.collections[collectionName] = c
+	return c, nil
+}
+
+// replay applies the records of the log at path.
+func (c *collection) replay(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		r := record{}
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A write cut short by a crash leaves a partial last line.
+			continue
+		}
+		c.apply(r)
+		c.records++
+	}
+	return scanner.Err()
+}
+
+// apply replaces the document of r in the index.
+func (c *collection) apply(r record) {
+	if old, ok := c.docs[r.ID]; ok {
+		for field, text := range old.fields {
+			for _, t := range tokenize(text) {
+				if docs := c.postings[t.term]; docs != nil {
+					delete(docs, r.ID)
+					if len(docs) == 0 {
+						delete(c.postings, t.term)
+					}
+				}
+			}
+			c.lengths[field] -= old.lengths[field]
+		}
+		delete(c.docs, r.ID)
+	}
+	if len(r.Fields) == 0 {
+		return
+	}
+
+	doc := document{fields: r.Fields, lengths: map[string]int{}}
+	for field, text := range r.Fields {
+		tokens := tokenize(text)
+		for _, t := range tokens {
+			docs := c.postings[t.term]
+			if docs == nil {
+				docs = map[string]map[string]int{}
+				c.postings[t.term] = docs
+			}
+			if docs[r.ID] == nil {
+				docs[r.ID] = map[string]int{}
+			}
+			docs[r.ID][field]++
+		}
+		doc.lengths[field] = len(tokens)
+		c.lengths[field] += len(tokens)
+	}
+	c.docs[r.ID] = doc
+}
+
+// write applies r and appends it to the collection's log, compacting the log
+// once most of its records are outdated.
+func (ix *Index) write(collectionName string, c *collection, r record) error {
+	c.apply(r)
+	if c.log == nil {
+		return nil
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "search: unable to update index")
+	}
+	if _, err = c.log.Write(append(line, '\n')); err != nil {
+		return errors.Wrap(err, "search: unable to update index")
+	}
+	c.records++
+	if c.records > 2*len(c.docs)+100 {
+		return ix.compact(collectionName, c)
+	}
+	return nil
+}
+
+// compact rewrites a collection's log with one record per document.
+func (ix *Index) compact(collectionName string, c *collection) error {
+	path := ix.logPath(collectionName)
+	tmp, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return errors.Wrap(err, "search: unable to compact index")
+	}
+	w := bufio.NewWriter(tmp)
+	for id, doc := range c.docs {
+		line, _ := json.Marshal(record{ID: id, Fields: doc.fields})
+		w.Write(append(line, '\n'))
+	}
+	if err = w.Flush(); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return errors.Wrap(err, "search: unable to compact index")
+	}
+
+	c.log.Close()
+	if c.log, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0600); err != nil {
+		return errors.Wrap(err, "search: unable to compact index")
+	}
+	c.records = len(c.docs)
+	return nil
+}
+
+// Index replaces the indexed text of an item with fields, which map field
+// names to their text. Items without fields are removed from the index.
+func (ix *Index) Index(collectionName, itemID string, fields map[string]string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	c, err := ix.collection(collectionName, true)
+	if err != nil {
+		return err
+	}
+	return ix.write(collectionName, c, record{ID: itemID, Fields: fields})
+}
+
+// Delete removes an item from the index.
+func (ix *Index) Delete(collectionName, itemID string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	c, err := ix.collection(collectionName, false)
+	if err != nil || c == nil {
+		return err
+	}
+	if _, ok := c.docs[itemID]; !ok {
+		return nil
+	}
+	return ix.write(collectionName, c, record{ID: itemID})
+}
+
+// Drop removes the index of a collection.
+func (ix *Index) Drop(collectionName string) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	if c, ok := ix.collections[collectionName]; ok && c.log != nil {
+		c.log.Close()
+	}
+	delete(ix.collections, collectionName)
+	if ix.dir == "" {
+		return nil
+	}
+	if err := os.Remove(ix.logPath(collectionName)); err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "search: unable to drop index")
+	}
+	return nil
+}
+
+// Rename moves the index of a collection to newName, replacing any index
+// newName had.
+func (ix *Index) Rename(collectionName, newName string) error {
+	if collectionName == newName {
+		return nil
+	}
+	if err := ix.Drop(newName); err != nil {
+		return err
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	c, ok := ix.collections[collectionName]
+	if !ok {
+		return nil
+	}
+	delete(ix.collections, collectionName)
+	if c.log == nil {
+		ix.collections[newName] = c
+		return nil
+	}
+
+	// The log is reloaded under its new name.
+	c.log.Close()
+	if err := os.Rename(ix.logPath(collectionName), ix.logPath(newName)); err != nil {
+		return errors.Wrap(err, "search: unable to rename index")
+	}
+	_, err := ix.collection(newName, true)
+	return err
+}
+
+// Close closes the collections' logs.
+func (ix *Index) Close() error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	var err error
+	for _, c := range ix.collections {
+		if c.log == nil {
+			continue
+		}
+		if closeErr := c.log.Close(); closeErr != nil && err == nil {
+			err = errors.Wrap(closeErr, "search: unable to close index")
+		}
+	}
+	ix.collections = map[string]*collection{}
+	if ix.lock != nil {
+		// Closing the file releases the lock.
+		if closeErr := ix.lock.Close(); closeErr != nil && err == nil {
+			err = errors.Wrap(closeErr, "search: unable to close index")
+		}
+		ix.lock = nil
+	}
+	return err
+}
+
+// Hit is an item matching a search.
+type Hit struct {
+	ID    string
+	Score float64
+	// Highlights holds an excerpt of each matching field, with the matched
+	// words wrapped in <mark> tags. The rest of the excerpt is HTML-escaped.
+	Highlights map[string]string
+}
+
+// Search returns the items of a collection matching text, best first, and
+// the number of matches. Only the hits from offset on, and at most count of
+// them if count is positive, are returned. Words within an edit or two of an
+// indexed word match it too, with a lower score.
+func (ix *Index) Search(collectionName, text string, offset, count int) (hits []Hit, total int, err error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	c, err := ix.collection(collectionName, false)
+	if err != nil || c == nil {
+		return []Hit{}, 0, err
+	}
+
+	scores := map[string]float64{}
+	matched := map[string]map[string]bool{}
+	seen := map[string]bool{}
+	for _, q := range tokenize(text) {
+		if seen[q.term] {
+			continue
+		}
+		seen[q.term] = true
+
+		// A document scores for the best of the terms q matches in it.
+		best := map[string]float64{}
+		for term, weight := range c.expand(q.term) {
+			for id, score := range c.score(term) {
+				if score *= weight; score > best[id] {
+					best[id] = score
+				}
+				if matched[id] == nil {
+					matched[id] = map[string]bool{}
+				}
+				matched[id][term] = true
+			}
+		}
+		for id, score := range best {
+			scores[id] += score
+		}
+	}
+
+	hits = make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, Hit{ID: id, Score: score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].ID < hits[j].ID
+	})
+
+	total = len(hits)
+	if offset > total {
+		offset = total
+	}
+	hits = hits[offset:]
+	if count > 0 && count < len(hits) {
+		hits = hits[:count]
+	}
+	for i := range hits {
+		hits[i].Highlights = map[string]string{}
+		for field, text := range c.docs[hits[i].ID].fields {
+			if fragment := highlight(text, matched[hits[i].ID]); fragment != "" {
+				hits[i].Highlights[field] = fragment
+			}
+		}
+	}
+	return hits, total, nil
+}
+
+// expand returns the indexed terms q matches, weighted by how close they are.
+func (c *collection) expand(q string) map[string]float64 {
+	terms := map[string]float64{}
+	if _, ok := c.postings[q]; ok {
+		terms[q] = 1
+	}
+	maxDistance := fuzziness(q)
+	if maxDistance == 0 {
+		return terms
+	}
+	for term := range c.postings {
+		if term == q {
+			continue
+		}
+		if d := distance(q, term, maxDistance); d <= maxDistance {
+			terms[term] = 1 / float64(1+d)
+		}
+	}
+	return terms
+}
+
+// score returns the BM25 score of term in each document holding it, summed
+// over their fields.
+func (c *collection) score(term string) map[string]float64 {
+	docs := c.postings[term]
+	n := float64(len(c.docs))
+	df := float64(len(docs))
+	idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+
+	scores := make(map[string]float64, len(docs))
+	for id, fields := range docs {
+		for field, tf := range fields {
+			avg := float64(c.lengths[field]) / n
+			length := float64(c.docs[id].lengths[field])
+			scores[id] += idf * float64(tf) * (k1 + 1) / (float64(tf) + k1*(1-b+b*length/avg))
+		}
+	}
+	return scores
+}
@@ -0,0 +1,194 @@
+package search
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func hitIDs(hits []Hit) []string {
+	ids := make([]string, len(hits))
+	for i, hit := range hits {
+		ids[i] = hit.ID
+	}
+	return ids
+}
+
+func newIndex(t *testing.T) *Index {
+	dir, err := ioutil.TempDir("", "ninja-search")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open index: %v", err)
+	}
+	t.Cleanup(func() { ix.Close() })
+	return ix
+}
+
+func TestRanking(t *testing.T) {
+	ix := newIndex(t)
+	ix.Index("posts", "a", map[string]string{"title": "Cooking with garlic", "body": "Garlic, garlic and more garlic."})
+	ix.Index("posts", "b", map[string]string{"title": "Gardening", "body": "Growing garlic in a small garden takes patience and a lot of water over many months."})
+	ix.Index("posts", "c", map[string]string{"title": "Baking bread"})
+	ix.Index("other", "d", map[string]string{"title": "Garlic bread"})
+
+	hits, total, err := ix.Search("posts", "GARLIC", 0, 0)
+	if err != nil {
+		t.Fatalf("search failed: %v", err)
+	}
+	if total != 2 || fmt.Sprint(hitIDs(hits)) != "[a b]" {
+		t.Errorf("expected hits [a b] of 2, got %v of %d", hitIDs(hits), total)
+	}
+	if len(hits) == 2 && hits[0].Score <= hits[1].Score {
+		t.Errorf("expected decreasing scores, got %v and %v", hits[0].Score, hits[1].Score)
+	}
+
+	hits, total, _ = ix.Search("posts", "garlic bread", 1, 1)
+	if total != 3 || len(hits) != 1 {
+		t.Errorf("expected 1 hit of 3, got %v of %d", hitIDs(hits), total)
+	}
+
+	ix.Index("posts", "a", map[string]string{"title": "Cooking with onions"})
+	ix.Delete("posts", "b")
+	if hits, _, _ = ix.Search("posts", "garlic", 0, 0); len(hits) != 0 {
+		t.Errorf("expected updated and deleted items not to match, got %v", hitIDs(hits))
+	}
+}
+
+func TestTypos(t *testing.T) {
+	ix := newIndex(t)
+	ix.Index("posts", "a", map[string]string{"title": "Café au lait"})
+	ix.Index("posts", "b", map[string]string{"title": "Chocolate mousse"})
+	ix.Index("posts", "c", map[string]string{"title": "Chocolates"})
+
+	tests := []struct {
+		query string
+		ids   string
+	}{
+		{"cafe", "[a]"},
+		{"cfae", "[]"},
+		{"caffe", "[a]"},
+		{"chocolate", "[b c]"},
+		{"chcolate", "[b c]"},
+		{"au", "[a]"},
+		{"an", "[]"},
+	}
+	for _, tt := range tests {
+		hits, _, err := ix.Search("posts", tt.query, 0, 0)
+		if err != nil {
+			t.Fatalf("search for %q failed: %v", tt.query, err)
+		}
+		if ids := fmt.Sprint(hitIDs(hits)); ids != tt.ids {
+			t.Errorf("expected %q to match %s, got %s", tt.query, tt.ids, ids)
+		}
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	ix := newIndex(t)
+	long := "Intro. "
+	for i := 0; i < 30; i++ {
+		long += "filler "
+	}
+	ix.Index("posts", "a", map[string]string{
+		"title": "Fish & <Chips>",
+		"body":  long + "chips are served hot, with more chips on the side. " + long,
+	})
+
+	hits, _, _ := ix.Search("posts", "chips", 0, 0)
+	if len(hits) != 1 {
+		t.Fatalf("expected a hit, got %v", hitIDs(hits))
+	}
+	if title := hits[0].Highlights["title"]; title != "Fish &amp; &lt;<mark>Chips</mark>&gt;" {
+		t.Errorf("unexpected title highlight %q", title)
+	}
+	body := hits[0].Highlights["body"]
+	if body[:len("…")] != "…" || body[len(body)-len("…"):] != "…" {
+		t.Errorf("expected a cut excerpt, got %q", body)
+	}
+	if len(body) > fragmentSize+50 {
+		t.Errorf("expected a short excerpt, got %d bytes", len(body))
+	}
+	for _, want := range []string{"<mark>chips</mark> are served", "more <mark>chips</mark> on"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in %q", want, body)
+		}
+	}
+}
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ninja-search")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open index: %v", err)
+	}
+	if _, err = Open(dir); errors.Cause(err) != ErrLocked {
+		t.Errorf("expected ErrLocked opening an open index, got %v", err)
+	}
+	ix.Close()
+	if ix, err = Open(dir); err != nil {
+		t.Fatalf("unable to reopen a closed index: %v", err)
+	}
+	ix.Close()
+}
+
+func TestPersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ninja-search")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	ix, err := Open(dir)
+	if err != nil {
+		t.Fatalf("unable to open index: %v", err)
+	}
+	// Enough updates to compact the log.
+	for i := 0; i < 200; i++ {
+		ix.Index("posts/drafts", fmt.Sprint(i%3), map[string]string{"title": fmt.Sprintf("draft %d", i)})
+	}
+	ix.Delete("posts/drafts", "2")
+	ix.Index("gone", "a", map[string]string{"title": "draft"})
+	ix.Drop("gone")
+	ix.Index("old", "a", map[string]string{"title": "draft"})
+	ix.Rename("old", "new")
+	if err = ix.Close(); err != nil {
+		t.Fatalf("unable to close index: %v", err)
+	}
+
+	if ix, err = Open(dir); err != nil {
+		t.Fatalf("unable to reopen index: %v", err)
+	}
+	defer ix.Close()
+	tests := []struct {
+		collection, query, ids string
+	}{
+		{"posts/drafts", "draft", "[0 1]"},
+		{"posts/drafts", "198", "[0]"},
+		{"posts/drafts", "197", "[]"},
+		{"gone", "draft", "[]"},
+		{"old", "draft", "[]"},
+		{"new", "draft", "[a]"},
+	}
+	for _, tt := range tests {
+		hits, _, err := ix.Search(tt.collection, tt.query, 0, 0)
+		if err != nil {
+			t.Fatalf("search failed: %v", err)
+		}
+		if ids := fmt.Sprint(hitIDs(hits)); ids != tt.ids {
+			t.Errorf("expected %q in %s to match %s, got %s", tt.query, tt.collection, tt.ids, ids)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	// fragmentSize is the length in bytes of a highlighted excerpt, and
+	// fragmentLead how much of it comes before the first match.
+	fragmentSize = 200
+	fragmentLead = 60
+)
+
+// token is a word of a text, and where it is in the text.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into words: runs of letters and digits. Terms are
+// lower-cased and stripped of accents, so "Café" matches "cafe".
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range text {
+		word := unicode.IsLetter(r) || unicode.IsDigit(r) || (start >= 0 && unicode.Is(unicode.Mn, r))
+		switch {
+		case word && start < 0:
+			start = i
+		case !word && start >= 0:
+			tokens = append(tokens, token{term: fold(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: fold(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+func fold(word string) string {
+	folded, _, err := transform.String(transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn)), norm.NFC), word)
+	if err != nil {
+		folded = word
+	}
+	return strings.ToLower(folded)
+}
+
+// fuzziness is the number of edits a query term may be away from the terms it
+// matches: none for very short terms and numbers, whose typos are too
+// ambiguous, one for short terms and two for longer ones.
+func fuzziness(term string) int {
+	switch n := utf8.RuneCountInString(term); {
+	case n <= 2 || strings.IndexFunc(term, unicode.IsDigit) >= 0:
+		return 0
+	case n <= 5:
+		return 1
+	}
+	return 2
+}
+
+// distance returns the Levenshtein distance between a and b, or max+1 if it
+// is larger than max.
+func distance(a, b string, max int) int {
+	x, y := []rune(a), []rune(b)
+	if d := len(x) - len(y); d > max || -d > max {
+		return max + 1
+	}
+	prev := make([]int, len(y)+1)
+	curr := make([]int, len(y)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		curr[0] = i
+		best := curr[0]
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if curr[j] < best {
+				best = curr[j]
+			}
+		}
+		if best > max {
+			return max + 1
+		}
+		prev, curr = curr, prev
+	}
+	if prev[len(y)] > max {
+		return max + 1
+	}
+	return prev[len(y)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// highlight returns an excerpt of text around its first word in terms, with
+// every such word in the excerpt wrapped in <mark> tags. It returns "" if
+// text holds none of terms.
+func highlight(text string, terms map[string]bool) string {
+	tokens := tokenize(text)
+	first := -1
+	for i, t := range tokens {
+		if terms[t.term] {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return ""
+	}
+
+	start := tokens[first].start - fragmentLead
+	if start < 0 {
+		start = 0
+	}
+	end := start + fragmentSize
+	if end > len(text) {
+		end = len(text)
+		if start = end - fragmentSize; start < 0 {
+			start = 0
+		}
+	}
+	// Excerpts never cut a word, or a character.
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+	for _, t := range tokens {
+		if t.start < start && t.end > start {
+			start = t.start
+		}
+		if t.start < end && t.end > end {
+			end = t.end
+		}
+	}
+
+	fragment := strings.Builder{}
+	if start > 0 {
+		fragment.WriteString("…")
+	}
+	pos := start
+	for _, t := range tokens {
+		if t.start < start || t.end > end || !terms[t.term] {
+			continue
+		}
+		fragment.WriteString(html.EscapeString(text[pos:t.start]))
+		fragment.WriteString("<mark>")
+		fragment.WriteString(html.EscapeString(text[t.start:t.end]))
+		fragment.WriteString("</mark>")
+		pos = t.end
+	}
+	fragment.WriteString(html.EscapeString(text[pos:end]))
+	if end < len(text) {
+		fragment.WriteString("…")
+	}
+	return fragment.String()
+}
@@ -0,0 +1,108 @@
+package datalayer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// SortField orders items by the value at Path, descending if Desc is set.
+type SortField struct {
+	Path []string
+	Desc bool
+}
+
+// Field returns the dot-separated Path of f.
+func (f SortField) Field() string {
+	return strings.Join(f.Path, ".")
+}
+
+// Sort orders items by each of its fields in turn. Items that tie on every
+// field are ordered by `_id`, so pages of a sorted listing never overlap.
+// Missing fields sort before any value, and so after every value when the
+// order is descending.
+type Sort []SortField
+
+// ParseSort reads the `sort` query parameter of an item listing: a
+// comma-separated list of dot-separated fields, each prefixed with `-` to sort
+// in descending order, e.g. `-publishedAt,title`.
+func ParseSort(value string) (Sort, error) {
+	if value == "" {
+		return nil, nil
+	}
+	s := Sort{}
+	seen := map[string]bool{}
+	for _, field := range strings.Split(value, ",") {
+		desc := strings.HasPrefix(field, "-")
+		field = strings.TrimPrefix(field, "-")
+		path, ok := parsePath(field)
+		if !ok {
+			return nil, &InvalidQueryError{Param: "sort", Reason: fmt.Sprintf("invalid field %q", field)}
+		}
+		if seen[field] {
+			return nil, &InvalidQueryError{Param: "sort", Reason: fmt.Sprintf("%q is repeated", field)}
+		}
+		seen[field] = true
+		s = append(s, SortField{Path: path, Desc: desc})
+	}
+	return s, nil
+}
+
+// Less reports whether item a sorts before item b.
+func (s Sort) Less(a, b map[string]interface{}) bool {
+	for _, f := range s {
+		x, _ := Lookup(a, f.Path)
+		y, _ := Lookup(b, f.Path)
+		if cmp := sortCompare(x, y); cmp != 0 {
+			return (cmp < 0) != f.Desc
+		}
+	}
+	return sortCompare(a["_id"], b["_id"]) < 0
+}
+
+// Apply sorts items in place. Drivers that can't sort their storage natively
+// sort with it.
+func (s Sort) Apply(items []map[string]interface{}) {
+	sort.SliceStable(items, func(i, j int) bool {
+		return s.Less(items[i], items[j])
+	})
+}
+
+// sortCompare orders any two values: missing and null first, then numbers,
+// strings and booleans. Values of other kinds tie.
+func sortCompare(a, b interface{}) int {
+	if ra, rb := sortRank(a), sortRank(b); ra != rb {
+		if ra < rb {
+			return -1
+		}
+		return 1
+	}
+	if x, ok := a.(bool); ok {
+		switch y := b.(bool); {
+		case x == y:
+			return 0
+		case y:
+			return -1
+		}
+		return 1
+	}
+	if cmp := compare(a, b); cmp != 2 {
+		return cmp
+	}
+	return 0
+}
+
+func sortRank(v interface{}) int {
+	if _, ok := number(v); ok {
+		return 1
+	}
+	switch v.(type) {
+	case nil:
+		return 0
+	case string:
+		return 2
+	case bool:
+		return 3
+	}
+	return 4
+}
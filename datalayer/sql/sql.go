@@ -0,0 +1,1172 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// Dialect hides the differences between SQL databases from the Datastore.
+// Every dialect is registered as its own datalayer driver, so a
+// `driver_type: sqlite` config opens the sqlite dialect.
+type Dialect interface {
+	// Name is the datalayer driver name the dialect registers under.
+	Name() string
+	// DriverName is the database/sql driver used to open connections.
+	DriverName() string
+	// Configure tunes a freshly opened connection pool.
+	Configure(db *sql.DB) error
+	// Placeholder returns the bind parameter for the n-th (1-based) argument.
+	Placeholder(n int) string
+	// QuoteIdent quotes a table or column name.
+	QuoteIdent(name string) string
+	// CreateMetaTable returns the DDL for the table holding collection
+	// schemas, with name, schema, metadata and versions columns.
+	CreateMetaTable(table string) string
+	// AddMetaVersions returns the DDL adding the versions column, holding a
+	// JSON array that defaults to empty, to a metadata table created before
+	// schemas were versioned.
+	AddMetaVersions(table string) string
+	// CreateItemsTable returns the DDL for the table holding a collection's items.
+	CreateItemsTable(table string) string
+	// CreateTrashTable returns the DDL for the table holding trashed items of
+	// every collection, keyed by (collection, id).
+	CreateTrashTable(table string) string
+	// CreateRevisionsTable returns the DDL for the table holding item
+	// revisions of every collection, keyed by (collection, id, revision).
+	CreateRevisionsTable(table string) string
+	// JSONExtract returns an expression selecting the value at path from the
+	// JSON document stored in column. Filters and sorting are pushed down to
+	// the database through it instead of being evaluated in Go.
+	JSONExtract(column string, path []string) string
+	// JSONElements returns a table expression whose `value` column holds the
+	// value at path, or each of its elements if it is an array. It has no
+	// rows when path is absent.
+	JSONElements(column string, path []string) string
+	// JSONExists returns a condition that holds when path is present in the
+	// JSON document, even if its value is null.
+	JSONExists(column string, path []string) string
+	// JSONValue returns an expression holding the value at path as JSON text,
+	// or NULL when path is absent. Projections are read through it.
+	JSONValue(column string, path []string) string
+	// CreateUniqueIndex returns the DDL for a unique index on expression
+	// over table, unless the index exists. Rows where expression is NULL
+	// don't conflict.
+	CreateUniqueIndex(index, table, expression string) string
+	// DropIndex returns the DDL dropping an index, if it exists.
+	DropIndex(index string) string
+	// IsUniqueViolation reports whether err was caused by a duplicate key.
+	IsUniqueViolation(err error) bool
+}
+
+// Datastore keeps collection schemas in a metadata table and stores every
+// collection's items as JSON documents in a table of its own, with a unique
+// <collection>_slug_index on item slugs. Trashed items of all collections
+// share the <schema collection>_trash table, and item revisions the
+// <schema collection>_revisions table.
+type Datastore struct {
+	DB               *sql.DB
+	SchemaCollection string
+	dialect          Dialect
+}
+
+const (
+	defaultSchemaCollection = "schema_collection"
+	trashSuffix             = "_trash"
+	revisionsSuffix         = "_revisions"
+	slugIndexSuffix         = "_slug_index"
+)
+
+// Register makes a dialect available as a datalayer driver named after it.
+func Register(dialect Dialect) {
+	datalayer.Register(dialect.Name(), &Datastore{dialect: dialect})
+}
+
+func NewDatastore(dialect Dialect, config datalayer.DBConfig) (*Datastore, error) {
+	ds := Datastore{dialect: dialect}
+	db, err := sql.Open(dialect.DriverName(), config.ConnectionString)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: unable to open database")
+	}
+	if err = dialect.Configure(db); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "sql: unable to configure database")
+	}
+	ds.DB = db
+	ds.SchemaCollection = config.SchemaCollectionName
+	if ds.SchemaCollection == "" {
+		ds.SchemaCollection = defaultSchemaCollection
+	}
+
+	_, err = db.Exec(dialect.CreateMetaTable(dialect.QuoteIdent(ds.SchemaCollection)))
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "sql: unable to create schema table")
+	}
+	if err = ds.migrateMetaTable(); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "sql: unable to migrate schema table")
+	}
+	_, err = db.Exec(dialect.CreateTrashTable(ds.trashTable()))
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "sql: unable to create trash table")
+	}
+	_, err = db.Exec(dialect.CreateRevisionsTable(ds.revisionsTable()))
+	if err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "sql: unable to create revisions table")
+	}
+	if err = ds.indexAllSlugs(context.Background()); err != nil {
+		db.Close()
+		return nil, errors.Wrap(err, "sql: unable to index slugs")
+	}
+	return &ds, nil
+}
+
+// migrateMetaTable adds the versions column to a metadata table that lacks it.
+// CREATE TABLE IF NOT EXISTS leaves tables of older databases as they are.
+func (ds *Datastore) migrateMetaTable() error {
+	rows, err := ds.DB.Query(fmt.Sprintf("SELECT * FROM %s LIMIT 0", ds.metaTable()))
+	if err != nil {
+		return err
+	}
+	columns, err := rows.Columns()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for _, column := range columns {
+		if column == "versions" {
+			return nil
+		}
+	}
+	_, err = ds.DB.Exec(ds.dialect.AddMetaVersions(ds.metaTable()))
+	return err
+}
+
+func (ds *Datastore) Connect(config datalayer.DBConfig) (datalayer.DataStore, error) {
+	return NewDatastore(ds.dialect, config)
+}
+
+func (ds *Datastore) Capabilities() datalayer.Capabilities {
+	return datalayer.Capabilities{datalayer.Transactions, datalayer.SecondaryIndexes}
+}
+
+// Close closes the underlying connection pool.
+func (ds *Datastore) Close() error {
+	return errors.Wrap(ds.DB.Close(), "sql: unable to close database")
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// execOne runs query and returns ErrNotFound if it touched no rows.
+func execOne(ctx context.Context, e execer, query string, args ...interface{}) error {
+	result, err := e.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return datalayer.ErrNotFound
+	}
+	return nil
+}
+
+func (ds *Datastore) metaTable() string {
+	return ds.dialect.QuoteIdent(ds.SchemaCollection)
+}
+
+func (ds *Datastore) trashTable() string {
+	return ds.dialect.QuoteIdent(ds.SchemaCollection + trashSuffix)
+}
+
+func (ds *Datastore) revisionsTable() string {
+	return ds.dialect.QuoteIdent(ds.SchemaCollection + revisionsSuffix)
+}
+
+func (ds *Datastore) itemsTable(collectionName string) string {
+	return ds.dialect.QuoteIdent(collectionName)
+}
+
+func (ds *Datastore) slugIndex(collectionName string) string {
+	return ds.dialect.QuoteIdent(collectionName + slugIndexSuffix)
+}
+
+// indexSlugs creates the unique index on the slugs of a collection's items.
+func (ds *Datastore) indexSlugs(ctx context.Context, e execer, collectionName string) error {
+	slug := ds.dialect.JSONExtract("doc", []string{datalayer.SlugField})
+	_, err := e.ExecContext(ctx, ds.dialect.CreateUniqueIndex(ds.slugIndex(collectionName), ds.itemsTable(collectionName), slug))
+	return err
+}
+
+// indexAllSlugs indexes the slugs of collections created before slugs were
+// indexed. It fails if two items of a collection share a slug.
+func (ds *Datastore) indexAllSlugs(ctx context.Context) error {
+	rows, err := ds.DB.QueryContext(ctx, fmt.Sprintf("SELECT name FROM %s", ds.metaTable()))
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		names = append(names, name)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err = ds.indexSlugs(ctx, ds.DB, name); err != nil {
+			return errors.Wrap(err, name)
+		}
+	}
+	return nil
+}
+
+func (ds *Datastore) bind(n int) string {
+	return ds.dialect.Placeholder(n)
+}
+
+// collectionExists returns ErrNotFound if collectionName was never created.
+func (ds *Datastore) collectionExists(ctx context.Context, q queryer, collectionName string) error {
+	var one int
+	query := fmt.Sprintf("SELECT 1 FROM %s WHERE name = %s", ds.metaTable(), ds.bind(1))
+	err := q.QueryRowContext(ctx, query, collectionName).Scan(&one)
+	if err == sql.ErrNoRows {
+		return datalayer.ErrNotFound
+	}
+	return err
+}
+
+func (ds *Datastore) CreateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	if ds.reserved(name) {
+		return errors.Errorf("sql: unable to create collection: %s is reserved", name)
+	}
+	schemaJSON, err := json.Marshal(schema)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to create collection")
+	}
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to create collection")
+	}
+	versionsJSON, err := json.Marshal([]map[string]interface{}{schema})
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to create collection")
+	}
+
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to create collection")
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s, %s, %s, %s)", ds.metaTable(), metaColumns, ds.bind(1), ds.bind(2), ds.bind(3), ds.bind(4))
+	_, err = tx.ExecContext(ctx, query, name, string(schemaJSON), string(metadataJSON), string(versionsJSON))
+	if ds.dialect.IsUniqueViolation(err) {
+		return errors.Wrapf(datalayer.ErrConflict, "sql: unable to create collection: %s", name)
+	}
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to create collection")
+	}
+
+	_, err = tx.ExecContext(ctx, ds.dialect.CreateItemsTable(ds.itemsTable(name)))
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to create collection")
+	}
+	if err = ds.indexSlugs(ctx, tx, name); err != nil {
+		return errors.Wrap(err, "sql: unable to create collection")
+	}
+	return errors.Wrap(tx.Commit(), "sql: unable to create collection")
+}
+
+// collectionRow is a row of the metadata table.
+type collectionRow struct {
+	datalayer.CollectionVM
+	versions []map[string]interface{}
+}
+
+const metaColumns = "name, schema, metadata, versions"
+
+// scanner is satisfied by both *sql.Row and *sql.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanCollection(row scanner) (c collectionRow, err error) {
+	var schemaJSON, metadataJSON, versionsJSON string
+	if err = row.Scan(&c.Name, &schemaJSON, &metadataJSON, &versionsJSON); err != nil {
+		return c, err
+	}
+	if err = json.Unmarshal([]byte(schemaJSON), &c.Schema); err != nil {
+		return c, err
+	}
+	if err = json.Unmarshal([]byte(metadataJSON), &c.Meta); err != nil {
+		return c, err
+	}
+	if err = json.Unmarshal([]byte(versionsJSON), &c.versions); err != nil {
+		return c, err
+	}
+	if len(c.versions) == 0 {
+		c.versions = []map[string]interface{}{c.Schema}
+	}
+	c.Version = len(c.versions)
+	return c, nil
+}
+
+func (ds *Datastore) getCollection(ctx context.Context, q queryer, name string) (collectionRow, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s WHERE name = %s", metaColumns, ds.metaTable(), ds.bind(1))
+	c, err := scanCollection(q.QueryRowContext(ctx, query, name))
+	if err == sql.ErrNoRows {
+		return c, datalayer.ErrNotFound
+	}
+	return c, err
+}
+
+func (ds *Datastore) GetCollections(ctx context.Context) (collections []datalayer.CollectionVM, err error) {
+	query := fmt.Sprintf("SELECT %s FROM %s ORDER BY name", metaColumns, ds.metaTable())
+	rows, err := ds.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: unable to get collections")
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, errors.Wrap(err, "sql: unable to get collections")
+		}
+		collections = append(collections, c.CollectionVM)
+	}
+	return collections, errors.Wrap(rows.Err(), "sql: unable to get collections")
+}
+
+func (ds *Datastore) GetCollection(ctx context.Context, name string) (collection datalayer.CollectionVM, err error) {
+	c, err := ds.getCollection(ctx, ds.DB, name)
+	return c.CollectionVM, errors.Wrap(err, "sql: unable to get collection")
+}
+
+func (ds *Datastore) GetSchemaVersions(ctx context.Context, name string) (versions []datalayer.SchemaVersion, err error) {
+	c, err := ds.getCollection(ctx, ds.DB, name)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: unable to get schema versions")
+	}
+	for i, schema := range c.versions {
+		versions = append(versions, datalayer.SchemaVersion{Version: i + 1, Schema: schema})
+	}
+	return versions, nil
+}
+
+// reserved reports whether name clashes with the tables and indexes the
+// datastore keeps for itself.
+func (ds *Datastore) reserved(name string) bool {
+	return name == ds.SchemaCollection || name == ds.SchemaCollection+trashSuffix || name == ds.SchemaCollection+revisionsSuffix ||
+		strings.HasSuffix(name, slugIndexSuffix)
+}
+
+func (ds *Datastore) UpdateCollection(ctx context.Context, name string, schema, metadata map[string]interface{}) error {
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to update collection")
+	}
+	defer tx.Rollback()
+
+	c, err := ds.getCollection(ctx, tx, name)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to update collection")
+	}
+	if schema != nil {
+		c.Schema = schema
+		c.versions = append(c.versions, schema)
+	}
+	if metadata != nil {
+		c.Meta = metadata
+	}
+
+	args := []interface{}{}
+	for _, v := range []interface{}{c.Schema, c.Meta, c.versions} {
+		value, err := json.Marshal(v)
+		if err != nil {
+			return errors.Wrap(err, "sql: unable to update collection")
+		}
+		args = append(args, string(value))
+	}
+	query := fmt.Sprintf("UPDATE %s SET schema = %s, metadata = %s, versions = %s WHERE name = %s", ds.metaTable(), ds.bind(1), ds.bind(2), ds.bind(3), ds.bind(4))
+	if _, err = tx.ExecContext(ctx, query, append(args, name)...); err != nil {
+		return errors.Wrap(err, "sql: unable to update collection")
+	}
+	return errors.Wrap(tx.Commit(), "sql: unable to update collection")
+}
+
+func (ds *Datastore) RenameCollection(ctx context.Context, name, newName string) error {
+	if ds.reserved(newName) {
+		return errors.Errorf("sql: unable to rename collection: %s is reserved", newName)
+	}
+
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to rename collection")
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("UPDATE %s SET name = %s WHERE name = %s", ds.metaTable(), ds.bind(1), ds.bind(2))
+	err = execOne(ctx, tx, query, newName, name)
+	if ds.dialect.IsUniqueViolation(err) {
+		return errors.Wrapf(datalayer.ErrConflict, "sql: unable to rename collection: %s", newName)
+	}
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to rename collection")
+	}
+
+	query = fmt.Sprintf("ALTER TABLE %s RENAME TO %s", ds.itemsTable(name), ds.itemsTable(newName))
+	if _, err = tx.ExecContext(ctx, query); err != nil {
+		return errors.Wrap(err, "sql: unable to rename collection")
+	}
+	// The slug index is named after the collection, so it is recreated to
+	// leave the old name free.
+	if _, err = tx.ExecContext(ctx, ds.dialect.DropIndex(ds.slugIndex(name))); err != nil {
+		return errors.Wrap(err, "sql: unable to rename collection")
+	}
+	if err = ds.indexSlugs(ctx, tx, newName); err != nil {
+		return errors.Wrap(err, "sql: unable to rename collection")
+	}
+	for _, table := range []string{ds.trashTable(), ds.revisionsTable()} {
+		query = fmt.Sprintf("UPDATE %s SET collection = %s WHERE collection = %s", table, ds.bind(1), ds.bind(2))
+		if _, err = tx.ExecContext(ctx, query, newName, name); err != nil {
+			return errors.Wrap(err, "sql: unable to rename collection")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "sql: unable to rename collection")
+}
+
+func (ds *Datastore) DeleteCollection(ctx context.Context, name string) error {
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to delete collection")
+	}
+	defer tx.Rollback()
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE name = %s", ds.metaTable(), ds.bind(1))
+	if err = execOne(ctx, tx, query, name); err != nil {
+		return errors.Wrap(err, "sql: unable to delete collection")
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", ds.itemsTable(name))); err != nil {
+		return errors.Wrap(err, "sql: unable to delete collection")
+	}
+	for _, table := range []string{ds.trashTable(), ds.revisionsTable()} {
+		query = fmt.Sprintf("DELETE FROM %s WHERE collection = %s", table, ds.bind(1))
+		if _, err = tx.ExecContext(ctx, query, name); err != nil {
+			return errors.Wrap(err, "sql: unable to delete collection")
+		}
+	}
+	return errors.Wrap(tx.Commit(), "sql: unable to delete collection")
+}
+
+func (ds *Datastore) GetSchema(ctx context.Context, collectionName string) (map[string]interface{}, error) {
+	var schemaJSON string
+	query := fmt.Sprintf("SELECT schema FROM %s WHERE name = %s", ds.metaTable(), ds.bind(1))
+	err := ds.DB.QueryRowContext(ctx, query, collectionName).Scan(&schemaJSON)
+	if err == sql.ErrNoRows {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "sql: unable to get schema")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: unable to get schema")
+	}
+
+	schema := map[string]interface{}{}
+	err = json.Unmarshal([]byte(schemaJSON), &schema)
+	return schema, errors.Wrap(err, "sql: unable to get schema")
+}
+
+func (ds *Datastore) SaveItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) error {
+	item["_id"] = itemID
+	item[datalayer.VersionField] = 1
+	doc, err := json.Marshal(item)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to save item")
+	}
+
+	if err = ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return errors.Wrap(err, "sql: unable to save item")
+	}
+
+	err = ds.insertItem(ctx, collectionName, itemID, string(doc))
+	if ds.dialect.IsUniqueViolation(err) {
+		// Either the ID or the slug is taken.
+		return errors.Wrapf(datalayer.ErrConflict, "sql: unable to save item: %s", itemID)
+	}
+	return errors.Wrap(err, "sql: unable to save item")
+}
+
+// insertItem inserts a new item row, unless itemID is in the collection's
+// trash, in which case it fails with ErrConflict so a new item can't take
+// over the revisions of the trashed one. Both checks run in one statement.
+func (ds *Datastore) insertItem(ctx context.Context, collectionName, itemID, doc string) error {
+	query := fmt.Sprintf("INSERT INTO %s (id, doc) SELECT %s, %s WHERE NOT EXISTS (SELECT 1 FROM %s WHERE collection = %s AND id = %s)",
+		ds.itemsTable(collectionName), ds.bind(1), ds.bind(2), ds.trashTable(), ds.bind(3), ds.bind(4))
+	err := execOne(ctx, ds.DB, query, itemID, doc, collectionName, itemID)
+	if err == datalayer.ErrNotFound {
+		return errors.Wrapf(datalayer.ErrConflict, "%s is in the trash", itemID)
+	}
+	return err
+}
+
+// UpdateItem only replaces the row if it is still at the version that was
+// read, and reads it again if another write got there first.
+func (ds *Datastore) UpdateItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}, ifVersion int) error {
+	if err := ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return errors.Wrap(err, "sql: unable to update item")
+	}
+
+	item["_id"] = itemID
+	for {
+		stored, err := ds.getItem(ctx, ds.DB, collectionName, itemID)
+		if err != nil {
+			return errors.Wrap(err, "sql: unable to update item")
+		}
+		if err = datalayer.CheckVersion(stored, ifVersion); err != nil {
+			return errors.Wrap(err, "sql: unable to update item")
+		}
+		version := datalayer.VersionOf(stored)
+		item[datalayer.VersionField] = version + 1
+		doc, err := json.Marshal(item)
+		if err != nil {
+			return errors.Wrap(err, "sql: unable to update item")
+		}
+
+		query := fmt.Sprintf("UPDATE %s SET doc = %s WHERE id = %s AND %s", ds.itemsTable(collectionName), ds.bind(1), ds.bind(2), ds.versionIs(3))
+		err = execOne(ctx, ds.DB, query, string(doc), itemID, version)
+		if ds.dialect.IsUniqueViolation(err) {
+			return errors.Wrapf(datalayer.ErrConflict, "sql: unable to update item: slug %s", datalayer.SlugOf(item))
+		}
+		if err != datalayer.ErrNotFound {
+			return errors.Wrap(err, "sql: unable to update item")
+		}
+	}
+}
+
+// UpsertItem inserts the item, or replaces it at the version that was read,
+// and starts over if another write got there first.
+func (ds *Datastore) UpsertItem(ctx context.Context, collectionName, itemID string, item map[string]interface{}) (created bool, err error) {
+	if err = ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return false, errors.Wrap(err, "sql: unable to upsert item")
+	}
+
+	item["_id"] = itemID
+	for {
+		stored, err := ds.getItem(ctx, ds.DB, collectionName, itemID)
+		if err == datalayer.ErrNotFound {
+			item[datalayer.VersionField] = 1
+			doc, err := json.Marshal(item)
+			if err != nil {
+				return false, errors.Wrap(err, "sql: unable to upsert item")
+			}
+			err = ds.insertItem(ctx, collectionName, itemID, string(doc))
+			if ds.dialect.IsUniqueViolation(err) {
+				// Another write inserted the item first, unless its slug
+				// is taken.
+				if _, err := ds.getItem(ctx, ds.DB, collectionName, itemID); err == datalayer.ErrNotFound {
+					return false, errors.Wrapf(datalayer.ErrConflict, "sql: unable to upsert item: slug %s", datalayer.SlugOf(item))
+				}
+				continue
+			}
+			return err == nil, errors.Wrap(err, "sql: unable to upsert item")
+		}
+		if err != nil {
+			return false, errors.Wrap(err, "sql: unable to upsert item")
+		}
+
+		version := datalayer.VersionOf(stored)
+		item[datalayer.VersionField] = version + 1
+		doc, err := json.Marshal(item)
+		if err != nil {
+			return false, errors.Wrap(err, "sql: unable to upsert item")
+		}
+		query := fmt.Sprintf("UPDATE %s SET doc = %s WHERE id = %s AND %s", ds.itemsTable(collectionName), ds.bind(1), ds.bind(2), ds.versionIs(3))
+		err = execOne(ctx, ds.DB, query, string(doc), itemID, version)
+		if ds.dialect.IsUniqueViolation(err) {
+			return false, errors.Wrapf(datalayer.ErrConflict, "sql: unable to upsert item: slug %s", datalayer.SlugOf(item))
+		}
+		if err != datalayer.ErrNotFound {
+			return false, errors.Wrap(err, "sql: unable to upsert item")
+		}
+	}
+}
+
+// versionIs returns a condition matching rows whose item is at the version
+// bound to the n-th argument.
+func (ds *Datastore) versionIs(n int) string {
+	return fmt.Sprintf("COALESCE(%s, 1) = %s", ds.dialect.JSONExtract("doc", []string{datalayer.VersionField}), ds.bind(n))
+}
+
+// getItem reads an item, without checking that its collection exists.
+func (ds *Datastore) getItem(ctx context.Context, q queryer, collectionName, itemID string) (item map[string]interface{}, err error) {
+	var doc string
+	query := fmt.Sprintf("SELECT doc FROM %s WHERE id = %s", ds.itemsTable(collectionName), ds.bind(1))
+	err = q.QueryRowContext(ctx, query, itemID).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return nil, datalayer.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal([]byte(doc), &item)
+	return item, err
+}
+
+func (ds *Datastore) GetItemBySlug(ctx context.Context, collectionName, slug string) (item map[string]interface{}, err error) {
+	if err = ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return nil, errors.Wrap(err, "sql: unable to get item by slug")
+	}
+
+	var doc string
+	query := fmt.Sprintf("SELECT doc FROM %s WHERE %s = %s ORDER BY id LIMIT 1", ds.itemsTable(collectionName), ds.dialect.JSONExtract("doc", []string{datalayer.SlugField}), ds.bind(1))
+	err = ds.DB.QueryRowContext(ctx, query, slug).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return nil, errors.Wrap(datalayer.ErrNotFound, "sql: unable to get item by slug")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: unable to get item by slug")
+	}
+	err = json.Unmarshal([]byte(doc), &item)
+	return item, errors.Wrap(err, "sql: unable to get item by slug")
+}
+
+func (ds *Datastore) GetItem(ctx context.Context, collectionName, itemID string) (item map[string]interface{}, err error) {
+	if err = ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return nil, errors.Wrap(err, "sql: unable to get item")
+	}
+	item, err = ds.getItem(ctx, ds.DB, collectionName, itemID)
+	return item, errors.Wrap(err, "sql: unable to get item")
+}
+
+// comparisons maps filter operators to SQL. Ne is negated Eq.
+var comparisons = map[datalayer.Operator]string{
+	datalayer.Eq:  "=",
+	datalayer.Ne:  "=",
+	datalayer.Gt:  ">",
+	datalayer.Gte: ">=",
+	datalayer.Lt:  "<",
+	datalayer.Lte: "<=",
+}
+
+// getProjectedItems runs the GetItems query for a projection, selecting only
+// the projected fields of each document.
+func (ds *Datastore) getProjectedItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta, where string, args []interface{}, total int) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	paths := append(datalayer.Projection{{"_id"}, {datalayer.VersionField}}, queryMeta.Fields...)
+	columns := make([]string, len(paths))
+	for i, path := range paths {
+		columns[i] = ds.dialect.JSONValue("doc", path)
+	}
+	query := fmt.Sprintf("SELECT %s FROM %s%s ORDER BY %s", strings.Join(columns, ", "), ds.itemsTable(collectionName), where, ds.orderBy(queryMeta.Sort))
+	if queryMeta.Count > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", queryMeta.Count, queryMeta.Skip())
+	}
+	rows, err := ds.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+	}
+	defer rows.Close()
+
+	items = []map[string]interface{}{}
+	values := make([]sql.NullString, len(paths))
+	dest := make([]interface{}, len(paths))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+		}
+		item := map[string]interface{}{}
+		for i, value := range values {
+			if !value.Valid {
+				continue
+			}
+			var v interface{}
+			if err = json.Unmarshal([]byte(value.String), &v); err != nil {
+				return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+			}
+			datalayer.SetPath(item, paths[i], v)
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+	}
+	return items, queryMeta.ResponseInfo(total, len(items)), nil
+}
+
+// orderBy translates s into an ORDER BY list. Ties, and listings without a
+// sort, are ordered by id.
+func (ds *Datastore) orderBy(s datalayer.Sort) string {
+	terms := make([]string, 0, len(s)+1)
+	for _, f := range s {
+		term := ds.dialect.JSONExtract("doc", f.Path)
+		if f.Desc {
+			term += " DESC"
+		}
+		terms = append(terms, term)
+	}
+	return strings.Join(append(terms, "id"), ", ")
+}
+
+// where translates filter into a WHERE clause and its arguments. Conditions
+// are tested against every element of array values, as the filter requires.
+func (ds *Datastore) where(filter datalayer.Filter) (clause string, args []interface{}) {
+	if len(filter) == 0 {
+		return "", nil
+	}
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return ds.bind(len(args))
+	}
+
+	conditions := make([]string, 0, len(filter))
+	for _, c := range filter {
+		elements := "SELECT 1 FROM " + ds.dialect.JSONElements("doc", c.Path) + " WHERE value "
+		var condition string
+		switch c.Op {
+		case datalayer.Exists:
+			condition = ds.dialect.JSONExists("doc", c.Path)
+			if !c.Value.(bool) {
+				condition = "NOT " + condition
+			}
+		case datalayer.In, datalayer.Nin:
+			values := c.Value.([]interface{})
+			placeholders := make([]string, len(values))
+			for i, v := range values {
+				placeholders[i] = bind(v)
+			}
+			condition = "EXISTS (" + elements + "IN (" + strings.Join(placeholders, ", ") + "))"
+		case datalayer.Prefix:
+			prefix := bind(c.Value)
+			condition = "EXISTS (" + elements + "IS NOT NULL AND substr(value, 1, length(" + prefix + ")) = " + bind(c.Value) + ")"
+		default:
+			condition = "EXISTS (" + elements + comparisons[c.Op] + " " + bind(c.Value) + ")"
+		}
+		if c.Op == datalayer.Ne || c.Op == datalayer.Nin {
+			condition = "NOT " + condition
+		}
+		conditions = append(conditions, condition)
+	}
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// after translates cursor into a condition selecting the items that follow
+// it in the order of s and then id, appending its arguments to args. Nulls
+// come first, as in orderBy.
+func (ds *Datastore) after(s datalayer.Sort, cursor *datalayer.Cursor, args []interface{}) (string, []interface{}) {
+	bind := func(v interface{}) string {
+		args = append(args, v)
+		return ds.bind(len(args))
+	}
+
+	// Alternative k keeps the fields before field k equal and moves past the
+	// cursor on field k, or on id once every field is equal. Arguments are
+	// bound in the order they appear, for dialects with positional
+	// placeholders.
+	alternatives := []string{}
+	for k := 0; k <= len(s); k++ {
+		if k < len(s) && s[k].Desc && cursor.Values[k] == nil {
+			// Nothing sorts after null in descending order.
+			continue
+		}
+		conditions := []string{}
+		for i, f := range s[:k] {
+			if value := ds.dialect.JSONExtract("doc", f.Path); cursor.Values[i] == nil {
+				conditions = append(conditions, value+" IS NULL")
+			} else {
+				conditions = append(conditions, value+" = "+bind(cursor.Values[i]))
+			}
+		}
+
+		var beyond string
+		if k == len(s) {
+			beyond = "id > " + bind(cursor.ID)
+		} else if value, v := ds.dialect.JSONExtract("doc", s[k].Path), cursor.Values[k]; v == nil {
+			beyond = value + " IS NOT NULL"
+		} else if !s[k].Desc {
+			beyond = value + " > " + bind(v)
+		} else {
+			beyond = "(" + value + " < " + bind(v) + " OR " + value + " IS NULL)"
+		}
+		conditions = append(conditions, beyond)
+		alternatives = append(alternatives, "("+strings.Join(conditions, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args
+}
+
+func (ds *Datastore) GetItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err = ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+	}
+	where, args := ds.where(queryMeta.Filter)
+
+	var total int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", ds.itemsTable(collectionName), where)
+	if err = ds.DB.QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+	}
+
+	// The cursor narrows the page, not the count.
+	if queryMeta.Cursor != nil {
+		var after string
+		after, args = ds.after(queryMeta.Sort, queryMeta.Cursor, args)
+		if where == "" {
+			where = " WHERE " + after
+		} else {
+			where += " AND " + after
+		}
+	}
+
+	if len(queryMeta.Fields) > 0 {
+		return ds.getProjectedItems(ctx, collectionName, queryMeta, where, args, total)
+	}
+	query = fmt.Sprintf("SELECT doc FROM %s%s ORDER BY %s", ds.itemsTable(collectionName), where, ds.orderBy(queryMeta.Sort))
+	if queryMeta.Count > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", queryMeta.Count, queryMeta.Skip())
+	}
+	rows, err := ds.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+	}
+	defer rows.Close()
+
+	items = []map[string]interface{}{}
+	for rows.Next() {
+		var doc string
+		if err = rows.Scan(&doc); err != nil {
+			return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+		}
+		item := map[string]interface{}{}
+		if err = json.Unmarshal([]byte(doc), &item); err != nil {
+			return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get items")
+	}
+	return items, queryMeta.ResponseInfo(total, len(items)), nil
+}
+
+// Aggregate streams the items matching the filter through a
+// datalayer.Aggregator.
+func (ds *Datastore) Aggregate(ctx context.Context, collectionName string, aggregation datalayer.Aggregation) (groups []datalayer.Group, err error) {
+	if err = ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return nil, errors.Wrap(err, "sql: unable to aggregate items")
+	}
+	where, args := ds.where(aggregation.Filter)
+	rows, err := ds.DB.QueryContext(ctx, fmt.Sprintf("SELECT doc FROM %s%s", ds.itemsTable(collectionName), where), args...)
+	if err != nil {
+		return nil, errors.Wrap(err, "sql: unable to aggregate items")
+	}
+	defer rows.Close()
+
+	aggregator := datalayer.NewAggregator(aggregation)
+	for rows.Next() {
+		var doc string
+		if err = rows.Scan(&doc); err != nil {
+			return nil, errors.Wrap(err, "sql: unable to aggregate items")
+		}
+		item := map[string]interface{}{}
+		if err = json.Unmarshal([]byte(doc), &item); err != nil {
+			return nil, errors.Wrap(err, "sql: unable to aggregate items")
+		}
+		aggregator.Add(item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, errors.Wrap(err, "sql: unable to aggregate items")
+	}
+	return aggregator.Groups(), nil
+}
+
+func (ds *Datastore) DeleteItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to delete item")
+	}
+	defer tx.Rollback()
+
+	if err = ds.collectionExists(ctx, tx, collectionName); err != nil {
+		return errors.Wrap(err, "sql: unable to delete item")
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE id = %s", ds.itemsTable(collectionName), ds.bind(1))
+	args := []interface{}{itemID}
+	if ifVersion != 0 {
+		query += " AND " + ds.versionIs(2)
+		args = append(args, ifVersion)
+	}
+	err = execOne(ctx, tx, query, args...)
+	if err == datalayer.ErrNotFound && ifVersion != 0 {
+		// Tell a missing item from one at another version.
+		if _, err = ds.getItem(ctx, tx, collectionName, itemID); err == nil {
+			err = datalayer.ErrVersionMismatch
+		}
+	}
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to delete item")
+	}
+	if err = ds.deleteRevisions(ctx, tx, collectionName, itemID); err != nil {
+		return errors.Wrap(err, "sql: unable to delete item")
+	}
+	return errors.Wrap(tx.Commit(), "sql: unable to delete item")
+}
+
+func (ds *Datastore) TrashItem(ctx context.Context, collectionName, itemID string, ifVersion int) error {
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to trash item")
+	}
+	defer tx.Rollback()
+
+	if err = ds.collectionExists(ctx, tx, collectionName); err != nil {
+		return errors.Wrap(err, "sql: unable to trash item")
+	}
+	item, err := ds.getItem(ctx, tx, collectionName, itemID)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to trash item")
+	}
+	if err = datalayer.CheckVersion(item, ifVersion); err != nil {
+		return errors.Wrap(err, "sql: unable to trash item")
+	}
+	version := datalayer.VersionOf(item)
+	item[datalayer.DeletedAtField] = datalayer.DeletedAt()
+	trashed, err := json.Marshal(item)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to trash item")
+	}
+
+	query := fmt.Sprintf("DELETE FROM %s WHERE collection = %s AND id = %s", ds.trashTable(), ds.bind(1), ds.bind(2))
+	if _, err = tx.ExecContext(ctx, query, collectionName, itemID); err != nil {
+		return errors.Wrap(err, "sql: unable to trash item")
+	}
+	query = fmt.Sprintf("INSERT INTO %s (collection, id, doc) VALUES (%s, %s, %s)", ds.trashTable(), ds.bind(1), ds.bind(2), ds.bind(3))
+	if _, err = tx.ExecContext(ctx, query, collectionName, itemID, string(trashed)); err != nil {
+		return errors.Wrap(err, "sql: unable to trash item")
+	}
+	// The item may have been written since it was read.
+	query = fmt.Sprintf("DELETE FROM %s WHERE id = %s AND %s", ds.itemsTable(collectionName), ds.bind(1), ds.versionIs(2))
+	err = execOne(ctx, tx, query, itemID, version)
+	if err == datalayer.ErrNotFound {
+		err = datalayer.ErrVersionMismatch
+	}
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to trash item")
+	}
+	return errors.Wrap(tx.Commit(), "sql: unable to trash item")
+}
+
+func (ds *Datastore) RestoreItem(ctx context.Context, collectionName, itemID string) error {
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to restore item")
+	}
+	defer tx.Rollback()
+
+	if err = ds.collectionExists(ctx, tx, collectionName); err != nil {
+		return errors.Wrap(err, "sql: unable to restore item")
+	}
+	var doc string
+	query := fmt.Sprintf("SELECT doc FROM %s WHERE collection = %s AND id = %s", ds.trashTable(), ds.bind(1), ds.bind(2))
+	err = tx.QueryRowContext(ctx, query, collectionName, itemID).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return errors.Wrap(datalayer.ErrNotFound, "sql: unable to restore item")
+	}
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to restore item")
+	}
+
+	item := map[string]interface{}{}
+	if err = json.Unmarshal([]byte(doc), &item); err != nil {
+		return errors.Wrap(err, "sql: unable to restore item")
+	}
+	delete(item, datalayer.DeletedAtField)
+	restored, err := json.Marshal(item)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to restore item")
+	}
+
+	query = fmt.Sprintf("INSERT INTO %s (id, doc) VALUES (%s, %s)", ds.itemsTable(collectionName), ds.bind(1), ds.bind(2))
+	_, err = tx.ExecContext(ctx, query, itemID, string(restored))
+	if ds.dialect.IsUniqueViolation(err) {
+		return errors.Wrapf(datalayer.ErrConflict, "sql: unable to restore item: %s", itemID)
+	}
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to restore item")
+	}
+	query = fmt.Sprintf("DELETE FROM %s WHERE collection = %s AND id = %s", ds.trashTable(), ds.bind(1), ds.bind(2))
+	if _, err = tx.ExecContext(ctx, query, collectionName, itemID); err != nil {
+		return errors.Wrap(err, "sql: unable to restore item")
+	}
+	return errors.Wrap(tx.Commit(), "sql: unable to restore item")
+}
+
+func (ds *Datastore) PurgeItem(ctx context.Context, collectionName, itemID string) error {
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to purge item")
+	}
+	defer tx.Rollback()
+
+	if err = ds.collectionExists(ctx, tx, collectionName); err != nil {
+		return errors.Wrap(err, "sql: unable to purge item")
+	}
+	query := fmt.Sprintf("DELETE FROM %s WHERE collection = %s AND id = %s", ds.trashTable(), ds.bind(1), ds.bind(2))
+	if err = execOne(ctx, tx, query, collectionName, itemID); err != nil {
+		return errors.Wrap(err, "sql: unable to purge item")
+	}
+	if err = ds.deleteRevisions(ctx, tx, collectionName, itemID); err != nil {
+		return errors.Wrap(err, "sql: unable to purge item")
+	}
+	return errors.Wrap(tx.Commit(), "sql: unable to purge item")
+}
+
+func (ds *Datastore) GetTrashedItems(ctx context.Context, collectionName string, queryMeta datalayer.QueryMeta) (items []map[string]interface{}, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err = ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get trashed items")
+	}
+
+	var total int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE collection = %s", ds.trashTable(), ds.bind(1))
+	if err = ds.DB.QueryRowContext(ctx, query, collectionName).Scan(&total); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get trashed items")
+	}
+
+	query = fmt.Sprintf("SELECT doc FROM %s WHERE collection = %s ORDER BY id", ds.trashTable(), ds.bind(1))
+	if queryMeta.Count > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", queryMeta.Count, queryMeta.Skip())
+	}
+	rows, err := ds.DB.QueryContext(ctx, query, collectionName)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get trashed items")
+	}
+	defer rows.Close()
+
+	items = []map[string]interface{}{}
+	for rows.Next() {
+		var doc string
+		if err = rows.Scan(&doc); err != nil {
+			return nil, respInfo, errors.Wrap(err, "sql: unable to get trashed items")
+		}
+		item := map[string]interface{}{}
+		if err = json.Unmarshal([]byte(doc), &item); err != nil {
+			return nil, respInfo, errors.Wrap(err, "sql: unable to get trashed items")
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get trashed items")
+	}
+	return items, queryMeta.ResponseInfo(total, len(items)), nil
+}
+
+func (ds *Datastore) deleteRevisions(ctx context.Context, e execer, collectionName, itemID string) error {
+	query := fmt.Sprintf("DELETE FROM %s WHERE collection = %s AND id = %s", ds.revisionsTable(), ds.bind(1), ds.bind(2))
+	_, err := e.ExecContext(ctx, query, collectionName, itemID)
+	return err
+}
+
+func (ds *Datastore) SaveRevision(ctx context.Context, collectionName, itemID string, revision datalayer.Revision) error {
+	doc, err := json.Marshal(revision)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to save revision")
+	}
+
+	tx, err := ds.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to save revision")
+	}
+	defer tx.Rollback()
+
+	if err = ds.collectionExists(ctx, tx, collectionName); err != nil {
+		return errors.Wrap(err, "sql: unable to save revision")
+	}
+	query := fmt.Sprintf("INSERT INTO %s (collection, id, revision, doc) VALUES (%s, %s, %s, %s)", ds.revisionsTable(), ds.bind(1), ds.bind(2), ds.bind(3), ds.bind(4))
+	_, err = tx.ExecContext(ctx, query, collectionName, itemID, revision.Revision, string(doc))
+	if ds.dialect.IsUniqueViolation(err) {
+		return errors.Wrapf(datalayer.ErrConflict, "sql: unable to save revision: %d", revision.Revision)
+	}
+	if err != nil {
+		return errors.Wrap(err, "sql: unable to save revision")
+	}
+	return errors.Wrap(tx.Commit(), "sql: unable to save revision")
+}
+
+func (ds *Datastore) GetRevisions(ctx context.Context, collectionName, itemID string, queryMeta datalayer.QueryMeta) (revisions []datalayer.Revision, respInfo datalayer.ItemsResponseInfo, err error) {
+	if err = ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get revisions")
+	}
+
+	var total int
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE collection = %s AND id = %s", ds.revisionsTable(), ds.bind(1), ds.bind(2))
+	if err = ds.DB.QueryRowContext(ctx, query, collectionName, itemID).Scan(&total); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get revisions")
+	}
+
+	query = fmt.Sprintf("SELECT doc FROM %s WHERE collection = %s AND id = %s ORDER BY revision", ds.revisionsTable(), ds.bind(1), ds.bind(2))
+	if queryMeta.Count > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", queryMeta.Count, queryMeta.Skip())
+	}
+	rows, err := ds.DB.QueryContext(ctx, query, collectionName, itemID)
+	if err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get revisions")
+	}
+	defer rows.Close()
+
+	revisions = []datalayer.Revision{}
+	for rows.Next() {
+		var doc string
+		if err = rows.Scan(&doc); err != nil {
+			return nil, respInfo, errors.Wrap(err, "sql: unable to get revisions")
+		}
+		revision := datalayer.Revision{}
+		if err = json.Unmarshal([]byte(doc), &revision); err != nil {
+			return nil, respInfo, errors.Wrap(err, "sql: unable to get revisions")
+		}
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, respInfo, errors.Wrap(err, "sql: unable to get revisions")
+	}
+	return revisions, queryMeta.ResponseInfo(total, len(revisions)), nil
+}
+
+func (ds *Datastore) GetRevision(ctx context.Context, collectionName, itemID string, number int) (revision datalayer.Revision, err error) {
+	if err = ds.collectionExists(ctx, ds.DB, collectionName); err != nil {
+		return revision, errors.Wrap(err, "sql: unable to get revision")
+	}
+
+	var doc string
+	query := fmt.Sprintf("SELECT doc FROM %s WHERE collection = %s AND id = %s AND revision = %s", ds.revisionsTable(), ds.bind(1), ds.bind(2), ds.bind(3))
+	err = ds.DB.QueryRowContext(ctx, query, collectionName, itemID, number).Scan(&doc)
+	if err == sql.ErrNoRows {
+		return revision, errors.Wrap(datalayer.ErrNotFound, "sql: unable to get revision")
+	}
+	if err != nil {
+		return revision, errors.Wrap(err, "sql: unable to get revision")
+	}
+
+	err = json.Unmarshal([]byte(doc), &revision)
+	return revision, errors.Wrap(err, "sql: unable to get revision")
+}
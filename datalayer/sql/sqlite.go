@@ -0,0 +1,100 @@
+package sql
+
+import (
+	"database/sql"
+	"strings"
+
+	_ "modernc.org/sqlite"
+)
+
+// SQLite is the pure-Go SQLite dialect, registered as the `sqlite` driver.
+// The connection string is a file name or DSN understood by modernc.org/sqlite.
+var SQLite Dialect = sqliteDialect{}
+
+func init() {
+	Register(SQLite)
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string {
+	return "sqlite"
+}
+
+func (sqliteDialect) DriverName() string {
+	return "sqlite"
+}
+
+// Configure limits the pool to one connection. SQLite serialises writers
+// anyway, and a single connection keeps `:memory:` databases shared.
+func (sqliteDialect) Configure(db *sql.DB) error {
+	db.SetMaxOpenConns(1)
+	_, err := db.Exec("PRAGMA busy_timeout = 5000")
+	return err
+}
+
+func (sqliteDialect) Placeholder(n int) string {
+	return "?"
+}
+
+func (sqliteDialect) QuoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+func (sqliteDialect) CreateMetaTable(table string) string {
+	return "CREATE TABLE IF NOT EXISTS " + table + " (name TEXT PRIMARY KEY, schema TEXT NOT NULL, metadata TEXT NOT NULL, versions TEXT NOT NULL DEFAULT '[]')"
+}
+
+func (sqliteDialect) AddMetaVersions(table string) string {
+	return "ALTER TABLE " + table + " ADD COLUMN versions TEXT NOT NULL DEFAULT '[]'"
+}
+
+func (sqliteDialect) CreateItemsTable(table string) string {
+	return "CREATE TABLE " + table + " (id TEXT PRIMARY KEY, doc TEXT NOT NULL)"
+}
+
+func (sqliteDialect) CreateTrashTable(table string) string {
+	return "CREATE TABLE IF NOT EXISTS " + table + " (collection TEXT NOT NULL, id TEXT NOT NULL, doc TEXT NOT NULL, PRIMARY KEY (collection, id))"
+}
+
+func (sqliteDialect) CreateRevisionsTable(table string) string {
+	return "CREATE TABLE IF NOT EXISTS " + table + " (collection TEXT NOT NULL, id TEXT NOT NULL, revision INTEGER NOT NULL, doc TEXT NOT NULL, PRIMARY KEY (collection, id, revision))"
+}
+
+// jsonPath returns path as a quoted SQLite JSON path literal.
+func jsonPath(path []string) string {
+	jsonPath := strings.Builder{}
+	jsonPath.WriteString("$")
+	for _, p := range path {
+		jsonPath.WriteString(`."` + p + `"`)
+	}
+	return "'" + strings.Replace(jsonPath.String(), "'", "''", -1) + "'"
+}
+
+func (sqliteDialect) JSONExtract(column string, path []string) string {
+	return "json_extract(" + column + ", " + jsonPath(path) + ")"
+}
+
+func (sqliteDialect) JSONElements(column string, path []string) string {
+	return "json_each(" + column + ", " + jsonPath(path) + ")"
+}
+
+func (sqliteDialect) JSONValue(column string, path []string) string {
+	return "(" + column + " -> " + jsonPath(path) + ")"
+}
+
+func (sqliteDialect) JSONExists(column string, path []string) string {
+	return "json_type(" + column + ", " + jsonPath(path) + ") IS NOT NULL"
+}
+
+func (sqliteDialect) CreateUniqueIndex(index, table, expression string) string {
+	return "CREATE UNIQUE INDEX IF NOT EXISTS " + index + " ON " + table + " (" + expression + ")"
+}
+
+func (sqliteDialect) DropIndex(index string) string {
+	return "DROP INDEX IF EXISTS " + index
+}
+
+func (sqliteDialect) IsUniqueViolation(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,67 @@
+package sql
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/tonyalaribe/ninja/datalayer/datastoretest"
+)
+
+func TestSQLiteConformance(t *testing.T) {
+	datastoretest.RunConformance(t, func(t *testing.T) datalayer.DataStore {
+		ds, err := NewDatastore(SQLite, datalayer.DBConfig{ConnectionString: ":memory:"})
+		if err != nil {
+			t.Fatalf("unable to create datastore: %v", err)
+		}
+		t.Cleanup(func() { ds.DB.Close() })
+		return ds
+	})
+}
+
+func TestSQLiteMetaTableMigration(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ninja-sqlite")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "ninja.db")
+
+	// A schema table from before schemas were versioned.
+	db, err := sql.Open(SQLite.DriverName(), file)
+	if err != nil {
+		t.Fatalf("unable to open database: %v", err)
+	}
+	_, err = db.Exec(`CREATE TABLE schema_collection (name TEXT PRIMARY KEY, schema TEXT NOT NULL, metadata TEXT NOT NULL)`)
+	if err == nil {
+		_, err = db.Exec(`INSERT INTO schema_collection VALUES ('posts', '{"type":"object"}', '{}')`)
+	}
+	if err == nil {
+		_, err = db.Exec(SQLite.CreateItemsTable("posts"))
+	}
+	db.Close()
+	if err != nil {
+		t.Fatalf("unable to create old schema table: %v", err)
+	}
+
+	ds, err := NewDatastore(SQLite, datalayer.DBConfig{ConnectionString: file})
+	if err != nil {
+		t.Fatalf("unable to create datastore: %v", err)
+	}
+	defer ds.DB.Close()
+	ctx := context.Background()
+	versions, err := ds.GetSchemaVersions(ctx, "posts")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("got versions %v with error %v, want the current schema", versions, err)
+	}
+	if err = ds.UpdateCollection(ctx, "posts", map[string]interface{}{"type": "object", "title": "Posts"}, nil); err != nil {
+		t.Fatalf("UpdateCollection failed: %v", err)
+	}
+	if versions, err = ds.GetSchemaVersions(ctx, "posts"); err != nil || len(versions) != 2 {
+		t.Errorf("got versions %v with error %v, want 2", versions, err)
+	}
+}
@@ -0,0 +1,29 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// Aggregate summarises the items of a collection, e.g.
+// `?groupBy=status&metrics=count,sum(price)`, taking the same filters as item
+// listings.
+func (server *Server) Aggregate(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+
+	aggregation, err := datalayer.ParseAggregation(r.URL.Query())
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: Aggregate failed")
+	}
+	groups, err := server.core.Aggregate(r.Context(), collectionName, aggregation)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: Aggregate failed")
+	}
+
+	return map[string]interface{}{
+		"groups": groups,
+	}, http.StatusOK, nil
+}
@@ -0,0 +1,11 @@
+package rest
+
+import (
+	"net/http"
+)
+
+// GetCapabilities lists the optional features the configured datastore
+// supports, so clients can hide what would be rejected anyway.
+func (server *Server) GetCapabilities(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	return server.core.Capabilities(), http.StatusOK, nil
+}
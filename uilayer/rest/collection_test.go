@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io"
 	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/go-chi/chi"
@@ -180,3 +182,492 @@ func TestSaveItem(t *testing.T) {
 
 	RespIsNotError(t, resp.Body)
 }
+
+func TestQueryMetaFromRequest(t *testing.T) {
+	tests := []struct {
+		url     string
+		want    datalayer.QueryMeta
+		wantErr bool
+	}{
+		{url: "/posts", want: datalayer.QueryMeta{}},
+		{url: "/posts?page=2&count=10", want: datalayer.QueryMeta{Page: 2, Count: 10}},
+		{url: "/posts?page=0", wantErr: true},
+		{url: "/posts?count=ten", wantErr: true},
+	}
+	for _, tt := range tests {
+		query, err := queryMetaFromRequest(httptest.NewRequest("GET", tt.url, nil))
+		AssertEqual(t, err != nil, tt.wantErr)
+		if !tt.wantErr {
+			AssertEqual(t, reflect.DeepEqual(query, tt.want), true)
+		}
+	}
+}
+
+func TestSaveItemConflict(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	rec, _ := DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	AssertEqual(t, rec.Code, 200)
+	rec, _ = DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	AssertEqual(t, rec.Code, 409)
+
+	rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+	AssertEqual(t, rec.Code, 200)
+	rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Tony"}`)
+	AssertEqual(t, rec.Code, 409)
+
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["firstName"], "Anthony")
+
+	// Items under these IDs would be shadowed by the collection's routes.
+	for _, id := range []string{"schema", "search", "aggregate", "trash", "by-slug"} {
+		rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name, fmt.Sprintf(`{"_id":%q,"firstName":"Tony"}`, id))
+		AssertEqual(t, rec.Code, 400)
+		rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name+"?upsert=true", fmt.Sprintf(`{"_id":%q,"firstName":"Tony"}`, id))
+		AssertEqual(t, rec.Code, 400)
+	}
+}
+
+func TestUpsertItem(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+
+	rec, resp := DoRequest(t, router, "POST", "/api/collections/"+name+"?upsert=true", `{"_id":"ext-1","firstName":"Anthony"}`)
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data, "Saved Item Successfully")
+	AssertEqual(t, rec.Header().Get("ETag"), `"1"`)
+
+	// Running the same import again replaces the item instead of failing.
+	rec, resp = DoRequest(t, router, "POST", "/api/collections/"+name+"?upsert=true", `{"_id":"ext-1","firstName":"Tony"}`)
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data, "Updated Item Successfully")
+	AssertEqual(t, rec.Header().Get("ETag"), `"2"`)
+
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name, "")
+	AssertEqual(t, rec.Code, 200)
+	items := resp.Data.(map[string]interface{})["Items"].([]interface{})
+	AssertEqual(t, len(items), 1)
+	AssertEqual(t, items[0].(map[string]interface{})["firstName"], "Tony")
+
+	rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name+"?upsert=true", `{"firstName":"Tony"}`)
+	AssertEqual(t, rec.Code, 400)
+	rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name+"?upsert=true", `{"_id":"ext-2","lastName":"Alaribe"}`)
+	AssertEqual(t, rec.Code, 400)
+	rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name+"?upsert=maybe", `{"_id":"ext-2","firstName":"Tony"}`)
+	AssertEqual(t, rec.Code, 400)
+}
+
+func TestGetItemBySlug(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+	req := strings.Replace(fmt.Sprintf(TestSchema1, name), `"meta":{}`, `"meta":{"slug_field":"firstName"}`, 1)
+
+	DoRequest(t, router, "POST", "/api/collections", req)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Zoë Anthony"}`)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-2","firstName":"Zoë Anthony"}`)
+
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name+"/by-slug/zoe-anthony-2", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["_id"], "item-2")
+	AssertEqual(t, rec.Header().Get("ETag"), `"1"`)
+
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/by-slug/missing", "")
+	AssertEqual(t, rec.Code, 404)
+}
+
+func TestQueryItems(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-2","firstName":"Tony"}`)
+
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name+"?filter[firstName][prefix]=To", "")
+	AssertEqual(t, rec.Code, 200)
+	items := resp.Data.(map[string]interface{})["Items"].([]interface{})
+	AssertEqual(t, len(items), 1)
+	AssertEqual(t, items[0].(map[string]interface{})["_id"], "item-2")
+
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"?sort=-firstName", "")
+	AssertEqual(t, rec.Code, 200)
+	items = resp.Data.(map[string]interface{})["Items"].([]interface{})
+	AssertEqual(t, items[0].(map[string]interface{})["_id"], "item-2")
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"?sort=lastName", "")
+	AssertEqual(t, rec.Code, 400)
+
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"?sort=-firstName&count=1", "")
+	AssertEqual(t, rec.Code, 200)
+	cursor := resp.Data.(map[string]interface{})["Meta"].(map[string]interface{})["nextCursor"].(string)
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"?sort=-firstName&count=1&cursor="+cursor, "")
+	AssertEqual(t, rec.Code, 200)
+	items = resp.Data.(map[string]interface{})["Items"].([]interface{})
+	AssertEqual(t, items[0].(map[string]interface{})["_id"], "item-1")
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"?sort=firstName&count=1&cursor="+cursor, "")
+	AssertEqual(t, rec.Code, 400)
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"?cursor=garbage", "")
+	AssertEqual(t, rec.Code, 400)
+
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"?fields=lastName", "")
+	AssertEqual(t, rec.Code, 400)
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"/item-1?fields=_id", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["firstName"], nil)
+	AssertEqual(t, rec.Header().Get("ETag"), `"1"`)
+
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"?filter[lastName]=Alaribe", "")
+	AssertEqual(t, rec.Code, 400)
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"?filter[firstName][like]=To", "")
+	AssertEqual(t, rec.Code, 400)
+}
+
+func TestSearch(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	req := strings.Replace(fmt.Sprintf(TestSchema1, name), `"meta":{}`, `"meta":{"search_fields":["lastName"]}`, 1)
+	rec, _ := DoRequest(t, router, "POST", "/api/collections", req)
+	AssertEqual(t, rec.Code, 400)
+
+	req = strings.Replace(fmt.Sprintf(TestSchema1, name), `"meta":{}`, `"meta":{"search_fields":["firstName"]}`, 1)
+	DoRequest(t, router, "POST", "/api/collections", req)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-2","firstName":"Tony"}`)
+
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name+"/search?q=anthoy", "")
+	AssertEqual(t, rec.Code, 200)
+	hits := resp.Data.(map[string]interface{})["hits"].([]interface{})
+	AssertEqual(t, len(hits), 1)
+	hit := hits[0].(map[string]interface{})
+	AssertEqual(t, hit["item"].(map[string]interface{})["_id"], "item-1")
+	AssertEqual(t, hit["highlights"].(map[string]interface{})["firstName"], "<mark>Anthony</mark>")
+	AssertEqual(t, resp.Data.(map[string]interface{})["meta"].(map[string]interface{})["totalCount"], float64(1))
+
+	DoRequest(t, router, "DELETE", "/api/collections/"+name+"/item-1", "")
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"/search?q=anthony", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, len(resp.Data.(map[string]interface{})["hits"].([]interface{})), 0)
+
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/search", "")
+	AssertEqual(t, rec.Code, 400)
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/search?q=tony&fields=lastName", "")
+	AssertEqual(t, rec.Code, 400)
+}
+
+func TestAggregate(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-2","firstName":"Tony"}`)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-3","firstName":"Tony"}`)
+
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name+"/aggregate?groupBy=firstName&metrics=count,min(firstName)", "")
+	AssertEqual(t, rec.Code, 200)
+	groups := resp.Data.(map[string]interface{})["groups"].([]interface{})
+	AssertEqual(t, len(groups), 2)
+	group := groups[1].(map[string]interface{})
+	AssertEqual(t, group["key"].(map[string]interface{})["firstName"], "Tony")
+	AssertEqual(t, group["metrics"].(map[string]interface{})["count"], float64(2))
+
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"/aggregate?filter[firstName][prefix]=To", "")
+	AssertEqual(t, rec.Code, 200)
+	groups = resp.Data.(map[string]interface{})["groups"].([]interface{})
+	AssertEqual(t, groups[0].(map[string]interface{})["metrics"].(map[string]interface{})["count"], float64(2))
+
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/aggregate?metrics=sum(firstName)", "")
+	AssertEqual(t, rec.Code, 400)
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/aggregate?metrics=median(firstName)", "")
+	AssertEqual(t, rec.Code, 400)
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/aggregate?groupBy=lastName", "")
+	AssertEqual(t, rec.Code, 400)
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/missing-"+name+"/aggregate", "")
+	AssertEqual(t, rec.Code, 404)
+}
+
+func TestUpdateItem(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	rec, _ := DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	AssertEqual(t, rec.Code, 200)
+	rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+	AssertEqual(t, rec.Code, 200)
+
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name+"/item-1", `{"firstName":"Tony","lastName":"Alaribe"}`)
+	AssertEqual(t, rec.Code, 200)
+
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 200)
+	item := resp.Data.(map[string]interface{})
+	AssertEqual(t, item["firstName"], "Tony")
+	AssertEqual(t, item["lastName"], "Alaribe")
+	AssertEqual(t, item["_id"], "item-1")
+
+	// The schema requires firstName.
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name+"/item-1", `{"lastName":"Alaribe"}`)
+	AssertEqual(t, rec.Code, 400)
+
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name+"/missing", `{"firstName":"Tony"}`)
+	AssertEqual(t, rec.Code, 404)
+}
+
+func TestPatchItem(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony","city":"Lagos"}`)
+
+	rec, resp := DoRequest(t, router, "PATCH", "/api/collections/"+name+"/item-1", `{"firstName":"Tony"}`, "Content-Type", "application/merge-patch+json")
+	AssertEqual(t, rec.Code, 200)
+	item := resp.Data.(map[string]interface{})
+	AssertEqual(t, item["firstName"], "Tony")
+	AssertEqual(t, item["city"], "Lagos")
+
+	rec, _ = DoRequest(t, router, "PATCH", "/api/collections/"+name+"/item-1", `{"firstName":"Tony"}`)
+	AssertEqual(t, rec.Code, 415)
+
+	rec, _ = DoRequest(t, router, "PATCH", "/api/collections/"+name+"/missing", `{"firstName":"Tony"}`, "Content-Type", "application/merge-patch+json")
+	AssertEqual(t, rec.Code, 404)
+}
+
+func TestIfMatch(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+
+	rec, _ := DoRequest(t, router, "GET", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, rec.Header().Get("ETag"), `"1"`)
+
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name+"/item-1", `{"firstName":"Tony"}`, "If-Match", `"1"`)
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, rec.Header().Get("ETag"), `"2"`)
+
+	// Every write against the old ETag is refused.
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name+"/item-1", `{"firstName":"Stale"}`, "If-Match", `"1"`)
+	AssertEqual(t, rec.Code, 412)
+	rec, _ = DoRequest(t, router, "PATCH", "/api/collections/"+name+"/item-1", `{"firstName":"Stale"}`, "Content-Type", "application/merge-patch+json", "If-Match", `"1"`)
+	AssertEqual(t, rec.Code, 412)
+	rec, _ = DoRequest(t, router, "DELETE", "/api/collections/"+name+"/item-1", "", "If-Match", `"1"`)
+	AssertEqual(t, rec.Code, 412)
+	rec, _ = DoRequest(t, router, "DELETE", "/api/collections/"+name+"/item-1", "", "If-Match", "not-an-etag")
+	AssertEqual(t, rec.Code, 412)
+
+	rec, _ = DoRequest(t, router, "PATCH", "/api/collections/"+name+"/item-1", `{"lastName":"Alaribe"}`, "Content-Type", "application/merge-patch+json", "If-Match", `"2"`)
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, rec.Header().Get("ETag"), `"3"`)
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name+"/item-1", `{"firstName":"Anthony"}`, "If-Match", "*")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, rec.Header().Get("ETag"), `"4"`)
+
+	rec, _ = DoRequest(t, router, "DELETE", "/api/collections/"+name+"/item-1", "", "If-Match", `"4"`)
+	AssertEqual(t, rec.Code, 200)
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name+"/item-1", `{"firstName":"Tony"}`, "If-Match", `"4"`)
+	AssertEqual(t, rec.Code, 404)
+}
+
+func TestDeleteItem(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+
+	rec, _ := DoRequest(t, router, "DELETE", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 200)
+
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 404)
+
+	// Without soft delete nothing is kept in the trash.
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name+"/trash", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, len(resp.Data.(map[string]interface{})["Items"].([]interface{})), 0)
+
+	rec, _ = DoRequest(t, router, "DELETE", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 404)
+}
+
+func TestSoftDeleteItem(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+	req := strings.Replace(fmt.Sprintf(TestSchema1, name), `"meta":{}`, `"meta":{"soft_delete":true}`, 1)
+
+	DoRequest(t, router, "POST", "/api/collections", req)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-2","firstName":"Tony"}`)
+
+	rec, _ := DoRequest(t, router, "DELETE", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 200)
+	rec, _ = DoRequest(t, router, "DELETE", "/api/collections/"+name+"/item-2", "")
+	AssertEqual(t, rec.Code, 200)
+
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name, "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, len(resp.Data.(map[string]interface{})["Items"].([]interface{})), 0)
+
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"/trash", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, len(resp.Data.(map[string]interface{})["Items"].([]interface{})), 2)
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/trash?q=Tony", "")
+	AssertEqual(t, rec.Code, 400)
+
+	rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name+"/trash/item-1/restore", "")
+	AssertEqual(t, rec.Code, 200)
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["firstName"], "Anthony")
+
+	rec, _ = DoRequest(t, router, "DELETE", "/api/collections/"+name+"/trash/item-2", "")
+	AssertEqual(t, rec.Code, 200)
+	rec, _ = DoRequest(t, router, "POST", "/api/collections/"+name+"/trash/item-2/restore", "")
+	AssertEqual(t, rec.Code, 404)
+
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"/trash", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, len(resp.Data.(map[string]interface{})["Items"].([]interface{})), 0)
+}
+
+func TestUpdateCollection(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+	newName := uuid.Must(uuid.NewV4()).String()
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+
+	rec, _ := DoRequest(t, router, "PUT", "/api/collections/"+name, `{"schema":{"title":"Contacts","type":"object","required":["firstName"]}}`)
+	AssertEqual(t, rec.Code, 200)
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name+"/schema", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["title"], "Contacts")
+
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name, fmt.Sprintf(`{"name":%q}`, newName))
+	AssertEqual(t, rec.Code, 200)
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/schema", "")
+	AssertEqual(t, rec.Code, 404)
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+newName+"/item-1", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["firstName"], "Anthony")
+
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name, `{"meta":{}}`)
+	AssertEqual(t, rec.Code, 404)
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name, fmt.Sprintf(`{"name":%q,"schema":{"title":"Renamed","type":"object"}}`, newName))
+	AssertEqual(t, rec.Code, 409)
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"/schema", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["title"], "A registration form")
+}
+
+func TestDeleteCollection(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+
+	rec, _ := DoRequest(t, router, "DELETE", "/api/collections/"+name, "")
+	AssertEqual(t, rec.Code, 200)
+	rec, _ = DoRequest(t, router, "GET", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 404)
+	rec, _ = DoRequest(t, router, "DELETE", "/api/collections/"+name, "")
+	AssertEqual(t, rec.Code, 404)
+}
+
+func TestUpdateCollectionSchemaCheck(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+	schema := `{"schema":{"type":"object","required":["lastName"]}}`
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-2","firstName":"Tony","lastName":"Alaribe"}`)
+
+	rec, resp := DoRequest(t, router, "PUT", "/api/collections/"+name+"?dry_run=true", schema)
+	AssertEqual(t, rec.Code, 200)
+	report := resp.Data.(map[string]interface{})
+	AssertEqual(t, report["itemsChecked"], 2.0)
+	failures := report["failures"].([]interface{})
+	AssertEqual(t, len(failures), 1)
+	AssertEqual(t, failures[0].(map[string]interface{})["itemID"], "item-1")
+
+	rec, resp = DoRequest(t, router, "PUT", "/api/collections/"+name, schema)
+	AssertEqual(t, rec.Code, 409)
+	AssertEqual(t, len(resp.Data.(map[string]interface{})["failures"].([]interface{})), 1)
+
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name+"?force=true", schema)
+	AssertEqual(t, rec.Code, 200)
+}
+
+func TestSchemaVersions(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+
+	rec, _ := DoRequest(t, router, "PUT", "/api/collections/"+name, `{"schema":{"type":"object","required":["name"]},"meta":{"migrations":[{"version":1}]}}`)
+	AssertEqual(t, rec.Code, 400)
+
+	rec, _ = DoRequest(t, router, "PUT", "/api/collections/"+name, `{"schema":{"type":"object","required":["name"]},"meta":{"migrations":[{"version":2,"rename":{"firstName":"name"}}]}}`)
+	AssertEqual(t, rec.Code, 200)
+
+	rec, resp := DoRequest(t, router, "GET", "/api/collections/"+name+"/schema/versions", "")
+	AssertEqual(t, rec.Code, 200)
+	versions := resp.Data.([]interface{})
+	AssertEqual(t, len(versions), 2)
+	AssertEqual(t, versions[1].(map[string]interface{})["version"], 2.0)
+
+	rec, resp = DoRequest(t, router, "GET", "/api/collections/"+name+"/item-1", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["name"], "Anthony")
+	AssertEqual(t, resp.Data.(map[string]interface{})["_schema_version"], 2.0)
+}
+
+func TestRevisions(t *testing.T) {
+	_, router := GetMemoryServer(t)
+	name := uuid.Must(uuid.NewV4()).String()
+	item := "/api/collections/" + name + "/item-1"
+
+	DoRequest(t, router, "POST", "/api/collections", fmt.Sprintf(TestSchema1, name))
+	DoRequest(t, router, "POST", "/api/collections/"+name, `{"_id":"item-1","firstName":"Anthony"}`)
+	DoRequest(t, router, "PUT", item, `{"firstName":"Tony"}`)
+
+	rec, resp := DoRequest(t, router, "GET", item+"/revisions", "")
+	AssertEqual(t, rec.Code, 200)
+	revisions := resp.Data.(map[string]interface{})["revisions"].([]interface{})
+	AssertEqual(t, len(revisions), 2)
+
+	rec, resp = DoRequest(t, router, "GET", item+"/revisions/1", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["item"].(map[string]interface{})["firstName"], "Anthony")
+
+	rec, resp = DoRequest(t, router, "GET", item+"/revisions/diff?from=1&to=2", "")
+	AssertEqual(t, rec.Code, 200)
+	ops := resp.Data.([]interface{})
+	AssertEqual(t, len(ops), 1)
+	AssertEqual(t, ops[0].(map[string]interface{})["path"], "/firstName")
+
+	rec, _ = DoRequest(t, router, "GET", item+"/revisions/diff?from=1", "")
+	AssertEqual(t, rec.Code, 400)
+	rec, _ = DoRequest(t, router, "GET", item+"/revisions/7", "")
+	AssertEqual(t, rec.Code, 404)
+
+	rec, resp = DoRequest(t, router, "POST", item+"/revisions/1/revert", "", ActorHeader, "editor")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["firstName"], "Anthony")
+	rec, resp = DoRequest(t, router, "GET", item+"/revisions/3", "")
+	AssertEqual(t, rec.Code, 200)
+	AssertEqual(t, resp.Data.(map[string]interface{})["actor"], "editor")
+}
@@ -2,10 +2,14 @@ package rest
 
 import (
 	"encoding/json"
+	"io/ioutil"
+	"mime"
 	"net/http"
+	"strconv"
 
 	"github.com/go-chi/chi"
 	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/core"
 	"github.com/tonyalaribe/ninja/datalayer"
 )
 
@@ -24,7 +28,7 @@ func (server *Server) CreateCollection(w http.ResponseWriter, r *http.Request) (
 
 	err = server.core.CreateCollection(r.Context(), resource.Name, resource.Schema, resource.Meta)
 	if err != nil {
-		return nil, http.StatusInternalServerError, errors.Wrap(err, "REST: CreateCollection failed")
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: CreateCollection failed")
 	}
 
 	return "Collection created successfully", http.StatusOK, nil
@@ -38,6 +42,83 @@ func (server *Server) GetCollections(w http.ResponseWriter, r *http.Request) (re
 	return collections, http.StatusOK, nil
 }
 
+// UpdateCollection replaces the schema and/or meta of a collection, and
+// renames it when the body carries a different name. A schema that stored
+// items fail to validate against is refused with 409 unless `force=true` is
+// set, and `dry_run=true` only reports which items would fail.
+//
+// The update and the rename are two steps, not one transaction. A name taken
+// before the request is refused up front, but if another request takes it in
+// between, the rename fails after the schema and meta have been replaced.
+func (server *Server) UpdateCollection(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+
+	dryRun, err := boolParam(r, "dry_run")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: UpdateCollection failed")
+	}
+	force, err := boolParam(r, "force")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: UpdateCollection failed")
+	}
+
+	resource := NewCollectionVM{}
+	err = json.NewDecoder(r.Body).Decode(&resource)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: UpdateCollection failed")
+	}
+
+	if dryRun {
+		if resource.Schema == nil {
+			return nil, http.StatusBadRequest, errors.New("REST: UpdateCollection failed: dry_run needs a schema")
+		}
+		report, err := server.core.CheckSchemaChange(r.Context(), collectionName, resource.Schema, resource.Meta)
+		if err != nil {
+			return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: UpdateCollection failed")
+		}
+		return report, http.StatusOK, nil
+	}
+
+	rename := resource.Name != "" && resource.Name != collectionName
+	if rename {
+		// Refuse a taken name before changing the schema, so a rename that is
+		// bound to fail doesn't leave the update half done.
+		_, err = server.core.GetSchema(r.Context(), resource.Name)
+		if err == nil {
+			err = errors.Wrapf(datalayer.ErrConflict, "collection %s already exists", resource.Name)
+		}
+		if errors.Cause(err) != datalayer.ErrNotFound {
+			return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: UpdateCollection failed")
+		}
+	}
+
+	err = server.core.UpdateCollection(r.Context(), collectionName, resource.Schema, resource.Meta, force)
+	if incompatible, ok := errors.Cause(err).(*core.IncompatibleSchemaError); ok {
+		return incompatible.Report, http.StatusConflict, errors.Wrap(err, "REST: UpdateCollection failed")
+	}
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: UpdateCollection failed")
+	}
+
+	if rename {
+		err = server.core.RenameCollection(r.Context(), collectionName, resource.Name)
+		if err != nil {
+			return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: UpdateCollection failed")
+		}
+	}
+	return "Collection updated successfully", http.StatusOK, nil
+}
+
+func (server *Server) DeleteCollection(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+
+	err = server.core.DeleteCollection(r.Context(), collectionName)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: DeleteCollection failed")
+	}
+	return "Collection deleted successfully", http.StatusOK, nil
+}
+
 func (server *Server) GetSchema(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
 	collectionName := chi.URLParam(r, "collectionName")
 
@@ -48,34 +129,197 @@ func (server *Server) GetSchema(w http.ResponseWriter, r *http.Request) (respons
 	return schema, http.StatusOK, nil
 }
 
+func (server *Server) GetSchemaVersions(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+
+	versions, err := server.core.GetSchemaVersions(r.Context(), collectionName)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: GetSchemaVersions failed")
+	}
+	return versions, http.StatusOK, nil
+}
+
+// SaveItem stores a new item. An item whose `_id` is taken is refused with 409,
+// unless `upsert=true` is set, in which case it replaces the stored one. IDs
+// clashing with collection routes, like `schema`, are refused with 400.
 func (server *Server) SaveItem(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
 	collectionName := chi.URLParam(r, "collectionName")
 
+	upsert, err := boolParam(r, "upsert")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: SaveItem failed")
+	}
+
 	resource := map[string]interface{}{}
 	err = json.NewDecoder(r.Body).Decode(&resource)
 	if err != nil {
 		return nil, http.StatusInternalServerError, errors.Wrap(err, "REST: SaveItem failed")
 	}
+	if id, _ := resource["_id"].(string); reservedItemIDs[id] {
+		return nil, http.StatusBadRequest, errors.Errorf("REST: SaveItem failed: %q is a reserved item ID", id)
+	}
+
+	if upsert {
+		if id, _ := resource["_id"].(string); id == "" {
+			return nil, http.StatusBadRequest, errors.New("REST: SaveItem failed: upsert needs an _id")
+		}
+		created, err := server.core.UpsertItem(r.Context(), collectionName, resource)
+		if err != nil {
+			return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: SaveItem failed")
+		}
+		setETag(w, resource)
+		if !created {
+			return "Updated Item Successfully", http.StatusOK, nil
+		}
+		return "Saved Item Successfully", http.StatusOK, nil
+	}
 
 	err = server.core.SaveItem(r.Context(), collectionName, resource)
 	if err != nil {
-		return nil, http.StatusInternalServerError, errors.Wrap(err, "REST: SaveItem failed")
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: SaveItem failed")
 	}
 	return "Saved Item Successfully", http.StatusOK, nil
 }
 
+func (server *Server) UpdateItem(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	itemID := chi.URLParam(r, "itemID")
+
+	resource := map[string]interface{}{}
+	err = json.NewDecoder(r.Body).Decode(&resource)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: UpdateItem failed")
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusBadRequest), errors.Wrap(err, "REST: UpdateItem failed")
+	}
+
+	err = server.core.UpdateItem(r.Context(), collectionName, itemID, resource, version)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: UpdateItem failed")
+	}
+	setETag(w, resource)
+	return "Updated Item Successfully", http.StatusOK, nil
+}
+
+func (server *Server) PatchItem(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	itemID := chi.URLParam(r, "itemID")
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, http.StatusUnsupportedMediaType, errors.Wrap(err, "REST: PatchItem failed")
+	}
+	patchType := core.PatchType(mediaType)
+	if patchType != core.MergePatch && patchType != core.JSONPatch {
+		return nil, http.StatusUnsupportedMediaType, errors.Errorf("REST: PatchItem failed: expected %s or %s, got %s", core.MergePatch, core.JSONPatch, mediaType)
+	}
+
+	patch, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: PatchItem failed")
+	}
+	version, err := ifMatch(r)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusBadRequest), errors.Wrap(err, "REST: PatchItem failed")
+	}
+
+	item, err := server.core.PatchItem(r.Context(), collectionName, itemID, patchType, patch, version)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: PatchItem failed")
+	}
+	setETag(w, item)
+	return item, http.StatusOK, nil
+}
+
 func (server *Server) GetItem(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
 	collectionName := chi.URLParam(r, "collectionName")
 	itemID := chi.URLParam(r, "itemID")
 
-	item, err := server.core.GetItem(r.Context(), collectionName, itemID)
+	fields, err := datalayer.ParseProjection(r.URL.Query().Get("fields"))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: GetItem failed")
+	}
+	item, err := server.core.GetItem(r.Context(), collectionName, itemID, fields)
+	if err != nil {
+		return item, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: GetItem failed")
+	}
+
+	setETag(w, item)
+	return item, http.StatusOK, nil
+}
+
+func (server *Server) GetItemBySlug(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	slug := chi.URLParam(r, "slug")
+
+	item, err := server.core.GetItemBySlug(r.Context(), collectionName, slug)
 	if err != nil {
-		return item, http.StatusInternalServerError, errors.Wrap(err, "REST: GetItem failed")
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: GetItemBySlug failed")
 	}
 
+	setETag(w, item)
 	return item, http.StatusOK, nil
 }
 
+// DeleteItem moves the item to the trash if its collection has soft delete
+// turned on, and deletes it permanently otherwise.
+func (server *Server) DeleteItem(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	itemID := chi.URLParam(r, "itemID")
+
+	version, err := ifMatch(r)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusBadRequest), errors.Wrap(err, "REST: DeleteItem failed")
+	}
+	err = server.core.DeleteItem(r.Context(), collectionName, itemID, version)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: DeleteItem failed")
+	}
+	return "Deleted Item Successfully", http.StatusOK, nil
+}
+
+func (server *Server) GetTrashedItems(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+
+	query, err := queryMetaFromRequest(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: GetTrashedItems failed")
+	}
+	items, respInfo, err := server.core.GetTrashedItems(r.Context(), collectionName, query)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: GetTrashedItems failed")
+	}
+
+	return ItemsResponse{
+		Items: items,
+		Meta:  respInfo,
+	}, http.StatusOK, nil
+}
+
+func (server *Server) RestoreItem(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	itemID := chi.URLParam(r, "itemID")
+
+	err = server.core.RestoreItem(r.Context(), collectionName, itemID)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: RestoreItem failed")
+	}
+	return "Restored Item Successfully", http.StatusOK, nil
+}
+
+func (server *Server) PurgeItem(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	itemID := chi.URLParam(r, "itemID")
+
+	err = server.core.PurgeItem(r.Context(), collectionName, itemID)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: PurgeItem failed")
+	}
+	return "Purged Item Successfully", http.StatusOK, nil
+}
+
 type ItemsResponse struct {
 	Items []map[string]interface{}
 	Meta  datalayer.ItemsResponseInfo
@@ -84,10 +328,25 @@ type ItemsResponse struct {
 func (server *Server) GetItems(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
 	collectionName := chi.URLParam(r, "collectionName")
 
-	query := datalayer.QueryMeta{}
+	query, err := queryMetaFromRequest(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: GetItems failed")
+	}
+	if query.Filter, err = datalayer.ParseFilter(r.URL.Query()); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: GetItems failed")
+	}
+	if query.Sort, err = datalayer.ParseSort(r.URL.Query().Get("sort")); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: GetItems failed")
+	}
+	if query.Fields, err = datalayer.ParseProjection(r.URL.Query().Get("fields")); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: GetItems failed")
+	}
+	if query.Cursor, err = datalayer.ParseCursor(r.URL.Query().Get("cursor"), query.Sort); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: GetItems failed")
+	}
 	items, respInfo, err := server.core.GetItems(r.Context(), collectionName, query)
 	if err != nil {
-		return nil, http.StatusInternalServerError, errors.Wrap(err, "REST: GetItem failed")
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: GetItems failed")
 	}
 
 	return ItemsResponse{
@@ -96,16 +355,35 @@ func (server *Server) GetItems(w http.ResponseWriter, r *http.Request) (response
 	}, http.StatusOK, nil
 }
 
-// TODO: Get single Item
-// TODO: Get paginated list of items
-// Item list should include meta. Get collections should be adjusted to return meta as well. eg
-/*
-{
-	Items  []Items,
-	Count int
-	PerPage int
-	ItemsSkipped int
-	PagesCount int
-	TotalCount int
+// boolParam reads an optional boolean query parameter.
+func boolParam(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.Errorf("invalid %s %q", name, value)
+	}
+	return b, nil
+}
+
+// queryMetaFromRequest reads the `page`, `count` and `q` query parameters of
+// an item listing request.
+func queryMetaFromRequest(r *http.Request) (query datalayer.QueryMeta, err error) {
+	params := r.URL.Query()
+	query.QueryString = params.Get("q")
+	if page := params.Get("page"); page != "" {
+		query.Page, err = strconv.Atoi(page)
+		if err != nil || query.Page < 1 {
+			return query, errors.Errorf("invalid page %q", page)
+		}
+	}
+	if count := params.Get("count"); count != "" {
+		query.Count, err = strconv.Atoi(count)
+		if err != nil || query.Count < 1 {
+			return query, errors.Errorf("invalid count %q", count)
+		}
+	}
+	return query, nil
 }
-*/
@@ -3,6 +3,7 @@
 package rest
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"os"
@@ -38,7 +39,7 @@ func TestMain(m *testing.M) {
 // DropDB deletes the db in use by casting the datastore to a mongodb Datastore struct and accessing the underlying db instance.
 func DropDB(db datalayer.DataStore) {
 	mongoDBInstance := dataStore.(*mongodb.Datastore)
-	err := mongoDBInstance.DB.DropDatabase()
+	err := mongoDBInstance.DB.Drop(context.Background())
 	if err != nil {
 		log.Panicf("unable to delete db instance with err=%v", err)
 	}
@@ -8,12 +8,16 @@ import (
 	"net/http/httptest"
 	"reflect"
 	"runtime/debug"
+	"strings"
 	"testing"
 
+	"github.com/go-chi/chi"
 	"github.com/golang/mock/gomock"
 	"github.com/tonyalaribe/ninja/core"
 	"github.com/tonyalaribe/ninja/datalayer"
+	"github.com/tonyalaribe/ninja/datalayer/memory"
 	"github.com/tonyalaribe/ninja/datalayer/mock"
+	"github.com/tonyalaribe/ninja/datalayer/search"
 )
 
 var dataStore datalayer.DataStore
@@ -43,6 +47,43 @@ func GetCoreManager(t *testing.T) (coreManager core.Manager, mockDataStore *mock
 	return coreManager, mockDataStore, mockCtrler, err
 }
 
+// GetMemoryServer returns a Server backed by a fresh in-memory datastore, for
+// tests that exercise routes end to end instead of against mock expectations.
+func GetMemoryServer(t *testing.T) (*Server, *chi.Mux) {
+	ds, err := memory.NewDatastore(datalayer.DBConfig{})
+	AssertEqual(t, err, nil)
+	index, err := search.Open("")
+	AssertEqual(t, err, nil)
+	coreManager, err := core.New(core.UseDataStore(ds), core.UseSearchIndex(index))
+	AssertEqual(t, err, nil)
+
+	s := &Server{
+		core: coreManager,
+	}
+	return s, s.Routes()
+}
+
+// DoRequest sends a request with an optional JSON body through router and
+// decodes the wrapped response.
+func DoRequest(t *testing.T, router http.Handler, method, url, body string, headers ...string) (*httptest.ResponseRecorder, ResponseResource) {
+	t.Helper()
+	var reqBody io.Reader
+	if body != "" {
+		reqBody = strings.NewReader(body)
+	}
+	req := httptest.NewRequest(method, url, reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var respData ResponseResource
+	json.Unmarshal(rec.Body.Bytes(), &respData)
+	return rec, respData
+}
+
 func RespIsNotError(t *testing.T, resp io.Reader) {
 	var respData ResponseResource
 	err := json.NewDecoder(resp).Decode(&respData)
@@ -2,6 +2,7 @@ package rest
 
 import (
 	"context"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -9,7 +10,9 @@ import (
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/render"
+	"github.com/pkg/errors"
 	"github.com/tonyalaribe/ninja/core"
+	"github.com/tonyalaribe/ninja/datalayer"
 )
 
 type Server struct {
@@ -39,7 +42,7 @@ func (server *Server) Run() {
 	}
 
 	idleConnsClosed := make(chan struct{})
-	go ShutdownOnNotify(baseCtx, &srv, idleConnsClosed)
+	go ShutdownOnNotify(baseCtx, &srv, server.core, idleConnsClosed)
 
 	log.Printf("Serving at 🔥 :%s \n", port)
 	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
@@ -71,7 +74,32 @@ func ResponseWrapper(f func(w http.ResponseWriter, r *http.Request) (interface{}
 	}
 }
 
-func ShutdownOnNotify(ctx context.Context, srv *http.Server, idleConnsClosed chan struct{}) {
+// errorStatus maps errors from core to HTTP status codes, falling back to
+// fallback for errors it doesn't know.
+func errorStatus(err error, fallback int) int {
+	cause := errors.Cause(err)
+	switch cause {
+	case datalayer.ErrNotFound:
+		return http.StatusNotFound
+	case datalayer.ErrConflict:
+		return http.StatusConflict
+	case datalayer.ErrVersionMismatch:
+		return http.StatusPreconditionFailed
+	}
+	switch cause.(type) {
+	case core.ValidationErrors, *core.InvalidPatchError, *core.InvalidMigrationError, *core.InvalidSearchFieldsError, *datalayer.InvalidQueryError:
+		return http.StatusBadRequest
+	case *core.IncompatibleSchemaError:
+		return http.StatusConflict
+	case *datalayer.UnsupportedError:
+		return http.StatusNotImplemented
+	}
+	return fallback
+}
+
+// ShutdownOnNotify waits for an interrupt, stops srv and then closes the
+// datastore connections through closer.
+func ShutdownOnNotify(ctx context.Context, srv *http.Server, closer io.Closer, idleConnsClosed chan struct{}) {
 	sigint := make(chan os.Signal, 1)
 	signal.Notify(sigint, os.Interrupt)
 	<-sigint
@@ -82,6 +110,9 @@ func ShutdownOnNotify(ctx context.Context, srv *http.Server, idleConnsClosed cha
 		// Error from closing listeners, or context timeout:
 		log.Fatalf("⚠️  HTTP server ListenAndServe error: %v", err)
 	}
+	if err := closer.Close(); err != nil {
+		log.Printf("⚠️  Unable to close datastore: %v", err)
+	}
 	close(idleConnsClosed)
 }
 
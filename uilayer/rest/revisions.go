@@ -0,0 +1,106 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/core"
+)
+
+// ActorHeader names who is making a request. It is recorded on the item
+// revisions the request creates.
+const ActorHeader = "X-Ninja-Actor"
+
+// WithActor copies the ActorHeader of each request into its context.
+func WithActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if actor := r.Header.Get(ActorHeader); actor != "" {
+			r = r.WithContext(core.WithActor(r.Context(), actor))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// revisionParam parses a revision number from the URL parameter or query
+// parameter name.
+func revisionParam(value, name string) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 1 {
+		return 0, errors.Errorf("invalid %s %q", name, value)
+	}
+	return number, nil
+}
+
+func (server *Server) GetRevisions(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	itemID := chi.URLParam(r, "itemID")
+
+	query, err := queryMetaFromRequest(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: GetRevisions failed")
+	}
+	revisions, respInfo, err := server.core.GetRevisions(r.Context(), collectionName, itemID, query)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: GetRevisions failed")
+	}
+
+	return map[string]interface{}{
+		"revisions": revisions,
+		"meta":      respInfo,
+	}, http.StatusOK, nil
+}
+
+func (server *Server) GetRevision(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	itemID := chi.URLParam(r, "itemID")
+
+	number, err := revisionParam(chi.URLParam(r, "revision"), "revision")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: GetRevision failed")
+	}
+	revision, err := server.core.GetRevision(r.Context(), collectionName, itemID, number)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: GetRevision failed")
+	}
+	return revision, http.StatusOK, nil
+}
+
+// DiffRevisions returns the JSON Patch between the revisions named by the
+// `from` and `to` query parameters.
+func (server *Server) DiffRevisions(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	itemID := chi.URLParam(r, "itemID")
+
+	params := r.URL.Query()
+	from, err := revisionParam(params.Get("from"), "from")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: DiffRevisions failed")
+	}
+	to, err := revisionParam(params.Get("to"), "to")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: DiffRevisions failed")
+	}
+	patch, err := server.core.DiffRevisions(r.Context(), collectionName, itemID, from, to)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: DiffRevisions failed")
+	}
+	return patch, http.StatusOK, nil
+}
+
+func (server *Server) RevertItem(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+	itemID := chi.URLParam(r, "itemID")
+
+	number, err := revisionParam(chi.URLParam(r, "revision"), "revision")
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: RevertItem failed")
+	}
+	item, err := server.core.RevertItem(r.Context(), collectionName, itemID, number)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: RevertItem failed")
+	}
+	setETag(w, item)
+	return item, http.StatusOK, nil
+}
@@ -10,6 +10,16 @@ import (
 	"github.com/go-chi/render"
 )
 
+// reservedItemIDs are the collection routes that would shadow an item of the
+// same ID, so items can't be saved under them.
+var reservedItemIDs = map[string]bool{
+	"schema":    true,
+	"search":    true,
+	"aggregate": true,
+	"trash":     true,
+	"by-slug":   true,
+}
+
 func (server *Server) Routes() *chi.Mux {
 	router := chi.NewRouter()
 	router.Use(
@@ -19,19 +29,38 @@ func (server *Server) Routes() *chi.Mux {
 		middleware.RedirectSlashes,                    // Redirect slashes to no slash URL versions
 		middleware.Recoverer,                          // Recover from panics without crashing server
 		middleware.Timeout(60*time.Second),            // Timeout requests after 60 seconds
+		WithActor,                                     // Record who made the request on item revisions
 	)
 	chiCors := cors.New(cors.Options{
 		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "DELETE"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
 		AllowCredentials: true,
 		AllowedHeaders:   []string{"Accept", "Content-Type", "X-Auth-Token", "*"},
 		Debug:            false,
 	})
 	router.Use(chiCors.Handler)
 	router.Get("/api/collections/{collectionName}/schema", ResponseWrapper(server.GetSchema))
+	router.Get("/api/collections/{collectionName}/schema/versions", ResponseWrapper(server.GetSchemaVersions))
+	router.Get("/api/collections/{collectionName}/search", ResponseWrapper(server.Search))
+	router.Get("/api/collections/{collectionName}/aggregate", ResponseWrapper(server.Aggregate))
 	router.Get("/api/collections/{collectionName}", ResponseWrapper(server.GetItems))
 	router.Post("/api/collections/{collectionName}", ResponseWrapper(server.SaveItem))
+	router.Put("/api/collections/{collectionName}", ResponseWrapper(server.UpdateCollection))
+	router.Delete("/api/collections/{collectionName}", ResponseWrapper(server.DeleteCollection))
+	router.Get("/api/collections/{collectionName}/{itemID}", ResponseWrapper(server.GetItem))
+	router.Put("/api/collections/{collectionName}/{itemID}", ResponseWrapper(server.UpdateItem))
+	router.Patch("/api/collections/{collectionName}/{itemID}", ResponseWrapper(server.PatchItem))
+	router.Delete("/api/collections/{collectionName}/{itemID}", ResponseWrapper(server.DeleteItem))
+	router.Get("/api/collections/{collectionName}/{itemID}/revisions", ResponseWrapper(server.GetRevisions))
+	router.Get("/api/collections/{collectionName}/{itemID}/revisions/diff", ResponseWrapper(server.DiffRevisions))
+	router.Get("/api/collections/{collectionName}/{itemID}/revisions/{revision}", ResponseWrapper(server.GetRevision))
+	router.Post("/api/collections/{collectionName}/{itemID}/revisions/{revision}/revert", ResponseWrapper(server.RevertItem))
+	router.Get("/api/collections/{collectionName}/trash", ResponseWrapper(server.GetTrashedItems))
+	router.Post("/api/collections/{collectionName}/trash/{itemID}/restore", ResponseWrapper(server.RestoreItem))
+	router.Delete("/api/collections/{collectionName}/trash/{itemID}", ResponseWrapper(server.PurgeItem))
+	router.Get("/api/collections/{collectionName}/by-slug/{slug}", ResponseWrapper(server.GetItemBySlug))
 
+	router.Get("/api/capabilities", ResponseWrapper(server.GetCapabilities))
 	router.Get("/api/collections", ResponseWrapper(server.GetCollections))
 	router.Post("/api/collections", ResponseWrapper(server.CreateCollection))
 	router.Get("/ping", PingPong)
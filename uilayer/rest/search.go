@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// Search returns the items of a collection matching the text in `q`, most
+// relevant first. It is paged with `page` and `count`, and `fields` picks the
+// fields of each item, as in item listings.
+func (server *Server) Search(w http.ResponseWriter, r *http.Request) (responseData interface{}, statusCode int, err error) {
+	collectionName := chi.URLParam(r, "collectionName")
+
+	query, err := queryMetaFromRequest(r)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: Search failed")
+	}
+	if query.Fields, err = datalayer.ParseProjection(r.URL.Query().Get("fields")); err != nil {
+		return nil, http.StatusBadRequest, errors.Wrap(err, "REST: Search failed")
+	}
+	hits, respInfo, err := server.core.Search(r.Context(), collectionName, query.QueryString, query)
+	if err != nil {
+		return nil, errorStatus(err, http.StatusInternalServerError), errors.Wrap(err, "REST: Search failed")
+	}
+
+	return map[string]interface{}{
+		"hits": hits,
+		"meta": respInfo,
+	}, http.StatusOK, nil
+}
@@ -0,0 +1,33 @@
+package rest
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tonyalaribe/ninja/datalayer"
+)
+
+// setETag sends the version of item as the response's ETag.
+func setETag(w http.ResponseWriter, item map[string]interface{}) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(datalayer.VersionOf(item))))
+}
+
+// ifMatch returns the item version required by the request's If-Match
+// header, or 0 if any version will do. A value that can't be an ETag of ours
+// never matches, so it is reported as datalayer.ErrVersionMismatch.
+func ifMatch(r *http.Request) (int, error) {
+	value := strings.TrimSpace(r.Header.Get("If-Match"))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+	if unquoted, err := strconv.Unquote(value); err == nil {
+		value = unquoted
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, errors.Wrapf(datalayer.ErrVersionMismatch, "invalid If-Match %q", r.Header.Get("If-Match"))
+	}
+	return version, nil
+}